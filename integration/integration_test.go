@@ -1,6 +1,8 @@
 package integration
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/fs"
 	"os"
 	"os/exec"
@@ -11,7 +13,7 @@ import (
 
 // TestGazelleIntegration runs Gazelle on a 3-bundle workspace and verifies
 // BUILD file generation. The workspace has:
-//   - user-service bundle (java+python+js enabled, protos in api/v1/ subdirectory)
+//   - user-service bundle (java+python+js+go enabled, protos in api/v1/ subdirectory)
 //   - common-types bundle (java+python enabled, js DISABLED, protos in types/v1/
 //     subdirectory; BUILD seeded with stale old-form JS rules that must be deleted)
 //   - legacy-service bundle (all languages enabled; BUILD seeded pre-PL-bstm —
@@ -137,6 +139,1271 @@ proto_library(
 	runGazelleExpectFatal(t, testDir, "leaves package_name empty")
 }
 
+// TestGazelleRejectsUnpublishableVersions: a bundle.yaml version that isn't
+// semver, or that lake.yaml's versioning policy disallows, fails the run
+// before anything is baked into maven_publish coordinates.
+func TestGazelleRejectsUnpublishableVersions(t *testing.T) {
+	for _, tt := range []struct {
+		name, versioning, version, want string
+	}{
+		{"NotSemver", "", "v1.2", `has an unpublishable ` + "`version`" + ` in bundle.yaml: "v1.2" is not a semantic version (MAJOR.MINOR.PATCH[-PRERELEASE]); did you mean "1.2.0"?`},
+		{"DisallowedPrerelease", "  versioning:\n    prerelease_tags: [\"rc\"]\n", "1.2.0-beta.1", `prerelease tag "beta" of "1.2.0-beta.1" is not one of lake.yaml's versioning.prerelease_tags (rc)`},
+		{"Snapshot", "  versioning:\n    snapshots: reject\n", "1.2.0-SNAPSHOT", `"1.2.0-SNAPSHOT" is a snapshot, and lake.yaml's versioning.snapshots rejects them`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			testDir := t.TempDir()
+
+			writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+`)
+			writeFile(t, testDir, "lake.yaml", `config:
+  language_defaults:
+    java:
+      enabled: true
+      group_id: "com.testcompany"
+      artifact_id: "{{.Name}}-proto"
+`+tt.versioning)
+
+			bundleDir := filepath.Join(testDir, "shipping")
+			if err := os.MkdirAll(bundleDir, 0755); err != nil {
+				t.Fatalf("Failed to create %s: %v", bundleDir, err)
+			}
+			writeFile(t, bundleDir, "shipment.proto", `syntax = "proto3";
+
+package shipping;
+
+message Shipment {
+  string id = 1;
+}
+`)
+			writeFile(t, bundleDir, "bundle.yaml", fmt.Sprintf("name: \"shipping\"\nversion: %q\n", tt.version))
+			// The version check sits in generateBundleRules, which a bundle
+			// without proto targets never reaches.
+			writeFile(t, bundleDir, "BUILD.bazel", `load("@rules_proto//proto:defs.bzl", "proto_library")
+
+proto_library(
+    name = "shipping_proto",
+    srcs = ["shipment.proto"],
+    visibility = ["//visibility:public"],
+)
+`)
+
+			runGazelleExpectFatal(t, testDir, tt.want)
+		})
+	}
+}
+
+// TestGazelleReportsAllConfigErrors: config mistakes across the lake fail
+// the run together, each located at file:line:column — a misspelled key is not
+// silently dropped, and a bundle.yaml without a name is not silently skipped.
+func TestGazelleReportsAllConfigErrors(t *testing.T) {
+	testDir := t.TempDir()
+
+	writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+`)
+	writeFile(t, testDir, "lake.yaml", `config:
+  language_defaults:
+    java:
+      enabled: true
+      group-id: "com.testcompany"
+`)
+	for _, bundle := range []struct{ dir, yaml string }{
+		{"com/testcompany/orders", `version: "1.0.0"
+`},
+		{"com/testcompany/shared", `name: "shared"
+version: "1.0.0"
+config:
+  languages:
+    java:
+      enabled: maybe
+`},
+	} {
+		dir := filepath.Join(testDir, filepath.FromSlash(bundle.dir))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+		writeFile(t, dir, "bundle.yaml", bundle.yaml)
+		writeFile(t, dir, "api.proto", `syntax = "proto3";
+
+package com.testcompany;
+`)
+	}
+
+	output, err := runGazelleCmd(t, testDir)
+	if err == nil {
+		t.Fatal("Gazelle succeeded but invalid config files should fail the run")
+	}
+	for _, want := range []string{
+		"invalid lake configuration (3 errors)",
+		"com/testcompany/orders/bundle.yaml:1:1: missing required field name",
+		`com/testcompany/shared/bundle.yaml:6:16: config.languages.java.enabled must be true or false, got "maybe"`,
+		`lake.yaml:5:7: unknown field "group-id" in config.language_defaults.java (did you mean "group_id"?)`,
+	} {
+		requireContains(t, output, want, "aggregated config error report")
+	}
+}
+
+// TestGazelleSinglePassConvergence: a brand-new bundle — bundle.yaml and
+// .proto files, no BUILD files at all — must come out complete from ONE
+// `bazel run //:gazelle` (proto + protolake languages). The proto_library
+// rules the proto extension generates in the bundle's subdirectories only
+// exist in memory during that pass; the protolake pass has to pick them up
+// from there rather than from disk, or the bundle needs a second run to get
+// its aggregate and per-language rules. A second pass must then be a no-op.
+func TestGazelleSinglePassConvergence(t *testing.T) {
+	testDir := t.TempDir()
+
+	writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+`)
+	writeFile(t, testDir, "BUILD.bazel", "")
+	writeFile(t, testDir, "lake.yaml", `config:
+  language_defaults:
+    java:
+      enabled: false
+    python:
+      enabled: true
+    javascript:
+      enabled: false
+`)
+
+	bundleDir := filepath.Join(testDir, "com", "testcompany", "fresh")
+	apiDir := filepath.Join(bundleDir, "api", "v1")
+	typesDir := filepath.Join(bundleDir, "types", "v1")
+	for _, dir := range []string{apiDir, typesDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	writeFile(t, bundleDir, "bundle.yaml", `name: "fresh-service"
+display_name: "Fresh Service"
+version: "1.0.0"
+config:
+  languages:
+    python:
+      package_name: "testcompany_fresh_proto"
+`)
+	writeFile(t, typesDir, "types.proto", `syntax = "proto3";
+
+package com.testcompany.fresh.types.v1;
+
+message Thing {
+  string id = 1;
+}
+`)
+	writeFile(t, apiDir, "api.proto", `syntax = "proto3";
+
+package com.testcompany.fresh.api.v1;
+
+import "com/testcompany/fresh/types/v1/types.proto";
+
+service FreshService {
+  rpc Get(com.testcompany.fresh.types.v1.Thing) returns (com.testcompany.fresh.types.v1.Thing);
+}
+`)
+
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	content := readBuildFile(t, bundleDir)
+	requireContains(t, content, `name = "fresh-service_all_protos"`, "aggregate proto_library on the first pass")
+	requireContains(t, content, `"//com/testcompany/fresh/api/v1:com_testcompany_fresh_api_v1_proto"`, "in-memory api proto_library discovered")
+	requireContains(t, content, `"//com/testcompany/fresh/types/v1:com_testcompany_fresh_types_v1_proto"`, "in-memory types proto_library discovered")
+	requireContains(t, content, `name = "fresh-service_py_bundle"`, "python bundle on the first pass")
+
+	pass1 := captureBuildFiles(t, testDir)
+	runGazelle(t, testDir, "-lang=proto,protolake")
+	pass2 := captureBuildFiles(t, testDir)
+	requireBuildFilesIdentical(t, pass1, pass2)
+}
+
+// TestGazelleResolvesThroughRuleIndex: with the proto language in the run,
+// a bundle's cross-bundle imports resolve through gazelle's RuleIndex, and a
+// `# gazelle:resolve proto ...` override — the directive users already write
+// for the proto extension — wins over whatever the index holds.
+func TestGazelleResolvesThroughRuleIndex(t *testing.T) {
+	testDir := t.TempDir()
+
+	writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+`)
+	writeFile(t, testDir, "BUILD.bazel", `# gazelle:resolve proto vendor/acme/v1/acme.proto //third_party/acme:acme_proto
+`)
+	writeFile(t, testDir, "lake.yaml", `config:
+  language_defaults:
+    java:
+      enabled: false
+    python:
+      enabled: true
+    javascript:
+      enabled: false
+`)
+
+	typesDir := filepath.Join(testDir, "com", "testcompany", "shared", "types", "v1")
+	apiDir := filepath.Join(testDir, "com", "testcompany", "orders", "api", "v1")
+	for _, dir := range []string{typesDir, apiDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+
+	sharedDir := filepath.Join(testDir, "com", "testcompany", "shared")
+	writeFile(t, sharedDir, "bundle.yaml", `name: "shared-types"
+version: "1.0.0"
+config:
+  languages:
+    python:
+      package_name: "testcompany_shared_proto"
+`)
+	writeFile(t, typesDir, "money.proto", `syntax = "proto3";
+
+package com.testcompany.shared.types.v1;
+
+message Money {
+  int64 units = 1;
+}
+`)
+	writeFile(t, typesDir, "BUILD.bazel", `load("@rules_proto//proto:defs.bzl", "proto_library")
+
+proto_library(
+    name = "money_proto",
+    srcs = ["money.proto"],
+    visibility = ["//visibility:public"],
+)
+`)
+
+	ordersDir := filepath.Join(testDir, "com", "testcompany", "orders")
+	writeFile(t, ordersDir, "bundle.yaml", `name: "orders-service"
+version: "1.0.0"
+config:
+  languages:
+    python:
+      package_name: "testcompany_orders_proto"
+`)
+	writeFile(t, apiDir, "orders.proto", `syntax = "proto3";
+
+package com.testcompany.orders.api.v1;
+
+import "com/testcompany/shared/types/v1/money.proto";
+import "vendor/acme/v1/acme.proto";
+
+message Order {
+  com.testcompany.shared.types.v1.Money total = 1;
+  acme.v1.Tracking tracking = 2;
+}
+`)
+
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	content := readBuildFile(t, ordersDir)
+	requireContains(t, content, `"//com/testcompany/shared/types/v1:money_proto"`,
+		"cross-bundle import resolved through the RuleIndex")
+	requireContains(t, content, `"//third_party/acme:acme_proto"`,
+		"gazelle:resolve proto override honored")
+
+	pass1 := captureBuildFiles(t, testDir)
+	runGazelle(t, testDir, "-lang=proto,protolake")
+	pass2 := captureBuildFiles(t, testDir)
+	requireBuildFilesIdentical(t, pass1, pass2)
+}
+
+// TestGazelleArtifactBundleDependencies checks that with
+// `bundle_dependencies: artifact` a cross-bundle import is declared as the
+// other bundle's published artifact in every language instead of compiled in.
+func TestGazelleArtifactBundleDependencies(t *testing.T) {
+	testDir := t.TempDir()
+
+	writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+`)
+	writeFile(t, testDir, "lake.yaml", `config:
+  bundle_dependencies: artifact
+  language_defaults:
+    java:
+      enabled: true
+      group_id: "com.testcompany"
+    python:
+      enabled: true
+    javascript:
+      enabled: true
+`)
+
+	typesDir := filepath.Join(testDir, "com", "testcompany", "shared", "types", "v1")
+	apiDir := filepath.Join(testDir, "com", "testcompany", "orders", "api", "v1")
+	for _, dir := range []string{typesDir, apiDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+
+	sharedDir := filepath.Join(testDir, "com", "testcompany", "shared")
+	writeFile(t, sharedDir, "bundle.yaml", `name: "shared_types"
+version: "1.2.0"
+config:
+  languages:
+    java:
+      artifact_id: "shared-types-proto"
+    python:
+      package_name: "testcompany_shared_proto"
+    javascript:
+      package_name: "@testcompany/shared"
+`)
+	writeFile(t, typesDir, "money.proto", `syntax = "proto3";
+
+package com.testcompany.shared.types.v1;
+
+message Money {
+  int64 units = 1;
+}
+`)
+
+	ordersDir := filepath.Join(testDir, "com", "testcompany", "orders")
+	writeFile(t, ordersDir, "bundle.yaml", `name: "orders"
+version: "1.0.0"
+config:
+  languages:
+    java:
+      artifact_id: "orders-proto"
+    python:
+      package_name: "testcompany_orders_proto"
+    javascript:
+      package_name: "@testcompany/orders"
+`)
+	writeFile(t, apiDir, "orders.proto", `syntax = "proto3";
+
+package com.testcompany.orders.api.v1;
+
+import "com/testcompany/shared/types/v1/money.proto";
+
+message Order {
+  com.testcompany.shared.types.v1.Money total = 1;
+}
+`)
+
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	content := readBuildFile(t, ordersDir)
+	requireContains(t, content, "--dependency com.testcompany:shared-types-proto:1.2.0 ",
+		"POM declares the shared bundle's artifact")
+	requireContains(t, content, "--dependency com.testcompany:shared-types-proto:1.2.0-local ",
+		"local POM declares the shared bundle's local artifact")
+	requireContains(t, content, `"--requirement=testcompany_shared_proto==1.2.0"`,
+		"pypi publisher requires the shared wheel")
+	requireContains(t, content, `"--dependency=@testcompany/shared@1.2.0"`,
+		"npm publisher depends on the shared package")
+	requireContains(t, content, `"//com/testcompany/shared:shared_types_java_grpc"`,
+		"java_grpc_library compiles against the shared Java library")
+	if strings.Contains(content, "money_proto") {
+		t.Errorf("Expected the shared protos not to be compiled into the orders bundle:\n%s", content)
+	}
+
+	// The lake root releases shared before the orders bundle that declares it.
+	root := readBuildFile(t, testDir)
+	requireContains(t, root, `load("@rules_multirun//:defs.bzl", "multirun")`, "multirun load")
+	requireContains(t, root, `multirun(
+    name = "publish_all",
+    commands = [
+        "//com/testcompany/shared:publish_shared_types_to_maven",
+        "//com/testcompany/shared:publish_shared_types_to_pypi",
+        "//com/testcompany/shared:publish_shared_types_to_npm",
+        "//com/testcompany/orders:publish_orders_to_maven",
+        "//com/testcompany/orders:publish_orders_to_pypi",
+        "//com/testcompany/orders:publish_orders_to_npm",
+    ],
+    jobs = 1,
+)`, "publish_all in dependency order")
+	requireContains(t, root, `multirun(
+    name = "publish_all_pypi",
+    commands = [
+        "//com/testcompany/shared:publish_shared_types_to_pypi",
+        "//com/testcompany/orders:publish_orders_to_pypi",
+    ],
+    jobs = 1,
+)`, "per-registry publish_all")
+	requireAbsent(t, root, "publish_all_goproxy", "no target for a registry no bundle publishes to")
+
+	pass1 := captureBuildFiles(t, testDir)
+	runGazelle(t, testDir, "-lang=proto,protolake")
+	pass2 := captureBuildFiles(t, testDir)
+	requireBuildFilesIdentical(t, pass1, pass2)
+}
+
+// TestGazelleCoordinateTemplates: with naming templates in lake.yaml, a
+// bundle.yaml holding only a name and a version gets a full rule set with
+// derived coordinates.
+func TestGazelleCoordinateTemplates(t *testing.T) {
+	testDir := t.TempDir()
+
+	writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+`)
+	writeFile(t, testDir, "lake.yaml", `config:
+  language_defaults:
+    java:
+      enabled: true
+      group_id: "com.testcompany"
+      artifact_id: "{{.Name}}-proto"
+    python:
+      enabled: true
+      package_prefix: "testcompany_proto"
+    javascript:
+      enabled: true
+      package_scope: "@testcompany"
+`)
+
+	apiDir := filepath.Join(testDir, "com", "testcompany", "billing", "api", "v1")
+	if err := os.MkdirAll(apiDir, 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", apiDir, err)
+	}
+	billingDir := filepath.Join(testDir, "com", "testcompany", "billing")
+	writeFile(t, apiDir, "invoice.proto", `syntax = "proto3";
+
+package com.testcompany.billing.api.v1;
+
+message Invoice {
+  string id = 1;
+}
+`)
+	writeFile(t, billingDir, "bundle.yaml", `name: "billing-service"
+version: "1.0.0"
+`)
+
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	content := readBuildFile(t, billingDir)
+	requireContains(t, content, `artifact_id = "billing-service-proto"`, "artifact_id from the lake template")
+	requireContains(t, content, `coordinates = "com.testcompany:billing-service-proto:1.0.0"`, "Maven coordinates from the template")
+	requireContains(t, content, `package_name = "testcompany_proto_billing_service"`, "Python package from package_prefix")
+	requireContains(t, content, `package_name = "@testcompany/billing-service"`, "npm package from package_scope")
+}
+
+// TestGazelleGroupConfigLayers: a group.yaml sets defaults for the bundles in
+// its subtree only, between lake.yaml and bundle.yaml.
+func TestGazelleGroupConfigLayers(t *testing.T) {
+	testDir := t.TempDir()
+
+	writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+`)
+	writeFile(t, testDir, "lake.yaml", `config:
+  language_defaults:
+    java:
+      enabled: true
+      group_id: "com.testcompany"
+      artifact_id: "{{.Name}}-proto"
+`)
+
+	paymentsDir := filepath.Join(testDir, "payments")
+	for _, bundle := range []struct{ dir, name string }{
+		{filepath.Join(paymentsDir, "ledger"), "ledger"},
+		{filepath.Join(testDir, "catalog"), "catalog"},
+	} {
+		apiDir := filepath.Join(bundle.dir, "api")
+		if err := os.MkdirAll(apiDir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", apiDir, err)
+		}
+		writeFile(t, apiDir, bundle.name+".proto", fmt.Sprintf(`syntax = "proto3";
+
+package %s.api;
+
+message Entry {
+  string id = 1;
+}
+`, bundle.name))
+		writeFile(t, bundle.dir, "bundle.yaml", fmt.Sprintf(`name: %q
+version: "1.0.0"
+`, bundle.name))
+	}
+	writeFile(t, paymentsDir, "group.yaml", `config:
+  languages:
+    java:
+      group_id: "com.testcompany.payments"
+`)
+
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	requireContains(t, readBuildFile(t, filepath.Join(paymentsDir, "ledger")),
+		`coordinates = "com.testcompany.payments:ledger-proto:1.0.0"`, "group_id from payments/group.yaml")
+	requireContains(t, readBuildFile(t, filepath.Join(testDir, "catalog")),
+		`coordinates = "com.testcompany:catalog-proto:1.0.0"`, "lake group_id outside the group")
+}
+
+// TestGazellePublishMetadata: lake.yaml metadata with bundle.yaml overrides
+// reaches the pom genrule and the pypi and npm publishers, quoted for Bazel's
+// tokenization, and a second pass leaves it alone.
+func TestGazellePublishMetadata(t *testing.T) {
+	testDir := t.TempDir()
+
+	writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+`)
+	writeFile(t, testDir, "lake.yaml", `config:
+  language_defaults:
+    java:
+      enabled: true
+      group_id: "com.testcompany"
+      artifact_id: "{{.Name}}-proto"
+    python:
+      enabled: true
+      package_prefix: "testcompany_proto"
+    javascript:
+      enabled: true
+      package_scope: "@testcompany"
+metadata:
+  homepage: "https://protos.testcompany.com"
+  license: "Apache-2.0"
+  license_url: "https://www.apache.org/licenses/LICENSE-2.0"
+  scm_url: "https://github.com/testcompany/protos"
+  developers:
+    - name: "Platform Team"
+      email: "platform@testcompany.com"
+`)
+
+	apiDir := filepath.Join(testDir, "billing", "api", "v1")
+	if err := os.MkdirAll(apiDir, 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", apiDir, err)
+	}
+	billingDir := filepath.Join(testDir, "billing")
+	writeFile(t, apiDir, "invoice.proto", `syntax = "proto3";
+
+package billing.api.v1;
+
+message Invoice {
+  string id = 1;
+}
+`)
+	writeFile(t, billingDir, "bundle.yaml", `name: "billing"
+display_name: "Billing API"
+description: >
+  Invoices and payments
+  for the billing service
+version: "1.0.0"
+metadata:
+  keywords: ["billing", "invoices"]
+  classifiers:
+    - "License :: OSI Approved :: Apache Software License"
+`)
+
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	content := readBuildFile(t, billingDir)
+	requireContains(t, content, "--name='Billing API' --description='Invoices and payments for the billing service' "+
+		"--homepage=https://protos.testcompany.com --license=Apache-2.0 "+
+		"--license-url=https://www.apache.org/licenses/LICENSE-2.0 --scm-url=https://github.com/testcompany/protos "+
+		"--developer='Platform Team <platform@testcompany.com>' ", "POM metadata flags")
+	requireContains(t, content, `"--classifier='License :: OSI Approved :: Apache Software License'"`, "pypi classifier")
+	requireContains(t, content, `"--keyword=invoices"`, "publisher keywords")
+
+	pass1 := captureBuildFiles(t, testDir)
+	runGazelle(t, testDir, "-lang=proto,protolake")
+	requireBuildFilesIdentical(t, pass1, captureBuildFiles(t, testDir))
+}
+
+// TestGazelleRuntimeVersions: the protobuf and gRPC versions lake.yaml pins
+// end up in the POM cmd and the wheel's requirements, and a pin MODULE.bazel
+// disagrees with fails the run at the MODULE.bazel line.
+func TestGazelleRuntimeVersions(t *testing.T) {
+	testDir := t.TempDir()
+
+	writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+
+bazel_dep(name = "protobuf", version = "31.1")
+`)
+	lake := `config:
+  language_defaults:
+    java:
+      enabled: true
+      group_id: "com.testcompany"
+      artifact_id: "{{.Name}}-proto"
+      protobuf_version: "%s"
+      grpc_version: "1.75.0"
+    python:
+      enabled: true
+      package_prefix: "testcompany_proto"
+      protobuf_version: "6.31.1"
+`
+	writeFile(t, testDir, "lake.yaml", fmt.Sprintf(lake, "4.31.1"))
+
+	ledgerDir := filepath.Join(testDir, "ledger")
+	if err := os.MkdirAll(ledgerDir, 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", ledgerDir, err)
+	}
+	writeFile(t, ledgerDir, "entry.proto", `syntax = "proto3";
+
+package ledger;
+
+message Entry {
+  string id = 1;
+}
+`)
+	writeFile(t, ledgerDir, "bundle.yaml", `name: "ledger"
+version: "1.0.0"
+`)
+
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	content := readBuildFile(t, ledgerDir)
+	requireContains(t, content, "--protobuf-version 4.31.1 --grpc-version 1.75.0 ", "pinned POM runtime versions")
+	requireContains(t, content, `"--requirement='protobuf>=6.31.1,<7'"`, "pinned wheel protobuf requirement")
+
+	writeFile(t, testDir, "lake.yaml", fmt.Sprintf(lake, "4.30.2"))
+	runGazelleExpectFatal(t, testDir,
+		"MODULE.bazel:3:1: lake.yaml pins java protobuf_version 4.30.2, but MODULE.bazel resolves module protobuf to 31.1")
+}
+
+// TestGazelleBufModule: an opt-in buf block gets the bundle a buf.yaml
+// genrule, a buf_proto_bundle and a buf push publisher; turning it back off
+// deletes all of them.
+func TestGazelleBufModule(t *testing.T) {
+	testDir := t.TempDir()
+
+	writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+`)
+	writeFile(t, testDir, "lake.yaml", `config:
+  language_defaults:
+    python:
+      enabled: true
+      package_prefix: "testcompany_proto"
+    buf:
+      module: "buf.build/testcompany/{{.Name}}"
+      local_registry: "bsr-registry"
+`)
+
+	catalogDir := filepath.Join(testDir, "catalog")
+	if err := os.MkdirAll(catalogDir, 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", catalogDir, err)
+	}
+	writeFile(t, catalogDir, "item.proto", `syntax = "proto3";
+
+package catalog;
+
+message Item {
+  string sku = 1;
+}
+`)
+	bundleYaml := `name: "catalog"
+version: "1.0.0"
+config:
+  languages:
+    buf:
+      enabled: %t
+`
+	writeFile(t, catalogDir, "bundle.yaml", fmt.Sprintf(bundleYaml, true))
+
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	content := readBuildFile(t, catalogDir)
+	requireContains(t, content, `name = "catalog_buf_yaml"`, "buf.yaml genrule")
+	requireContains(t, content, "'    name: buf.build/testcompany/catalog'", "buf.yaml module name")
+	requireContains(t, content, "buf_proto_bundle(", "buf_proto_bundle rule")
+	requireContains(t, content, `buf_yaml = ":catalog_buf_yaml"`, "buf_proto_bundle buf.yaml")
+	requireContains(t, content, `"--module=buf.build/testcompany/catalog"`, "buf publisher module arg")
+	requireContains(t, content, `"--local-registry=bsr-registry"`, "buf publisher local registry arg")
+	requireContains(t, content, `name = "publish_to_buf"`, "publish_to_buf alias")
+	requireContains(t, content, `":catalog_buf_bundle"`, "buf bundle in build_validation")
+
+	pass1 := captureBuildFiles(t, testDir)
+	runGazelle(t, testDir, "-lang=proto,protolake")
+	requireBuildFilesIdentical(t, pass1, captureBuildFiles(t, testDir))
+
+	writeFile(t, catalogDir, "bundle.yaml", fmt.Sprintf(bundleYaml, false))
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	content = readBuildFile(t, catalogDir)
+	for _, name := range []string{"catalog_buf_yaml", "catalog_buf_bundle", "publish_catalog_to_buf", "publish_to_buf"} {
+		requireAbsent(t, content, fmt.Sprintf("name = %q", name), name+" (buf disabled)")
+	}
+	requireAbsent(t, content, "buf_proto_bundle", "buf_proto_bundle load (buf disabled)")
+	requireContains(t, content, "py_proto_bundle(", "python bundle kept")
+}
+
+// TestGazelleBreakingCheck: a bundle.yaml breaking_check section gets the
+// bundle a <bundle>_breaking_check proto_breaking_test over its descriptor
+// set, and removing the section deletes the test.
+func TestGazelleBreakingCheck(t *testing.T) {
+	testDir := t.TempDir()
+
+	writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+`)
+	writeFile(t, testDir, "lake.yaml", `config:
+  language_defaults:
+    python:
+      enabled: true
+      package_prefix: "testcompany_proto"
+`)
+
+	ordersDir := filepath.Join(testDir, "orders")
+	if err := os.MkdirAll(ordersDir, 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", ordersDir, err)
+	}
+	writeFile(t, ordersDir, "order.proto", `syntax = "proto3";
+
+package orders;
+
+message Order {
+  string id = 1;
+}
+`)
+	writeFile(t, ordersDir, "orders.baseline.pb", "")
+	writeFile(t, ordersDir, "bundle.yaml", `name: "orders"
+version: "2.0.0"
+config:
+  breaking_check:
+    baseline: "orders.baseline.pb"
+    baseline_version: "1.3.0"
+`)
+
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	content := readBuildFile(t, ordersDir)
+	requireContains(t, content, "proto_breaking_test(", "breaking check test")
+	requireContains(t, content, `name = "orders_breaking_check"`, "breaking check name")
+	requireContains(t, content, `descriptor = ":orders_descriptor"`, "descriptor attr")
+	requireContains(t, content, `baseline = "orders.baseline.pb"`, "baseline attr")
+	requireContains(t, content, `baseline_version = "1.3.0"`, "baseline version attr")
+	requireContains(t, content, `bundle_yaml = ":bundle.yaml"`, "version input")
+	requireContains(t, content, `name = "orders_descriptor"`, "descriptor set for the check")
+
+	pass1 := captureBuildFiles(t, testDir)
+	runGazelle(t, testDir, "-lang=proto,protolake")
+	requireBuildFilesIdentical(t, pass1, captureBuildFiles(t, testDir))
+
+	writeFile(t, ordersDir, "bundle.yaml", `name: "orders"
+version: "2.0.0"
+`)
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	content = readBuildFile(t, ordersDir)
+	requireAbsent(t, content, "orders_breaking_check", "breaking check (section removed)")
+	requireAbsent(t, content, "proto_breaking_test", "proto_breaking_test load (section removed)")
+}
+
+// TestGazelleVersionSource: a git_tag bundle gets the stamped version
+// genrule and reads it everywhere, going back to a literal version restores
+// maven_publish and removes the version plumbing, and a `version` left in
+// bundle.yaml alongside git_tag, or an artifact dependency on a git_tag
+// bundle, fails the run.
+func TestGazelleVersionSource(t *testing.T) {
+	testDir := t.TempDir()
+
+	writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+`)
+	lake := `config:
+  language_defaults:
+    java:
+      enabled: true
+      group_id: "com.testcompany"
+      artifact_id: "{{.Name}}-proto"
+    python:
+      enabled: true
+      package_prefix: "testcompany_proto"
+`
+	writeFile(t, testDir, "lake.yaml", lake+"  version_source: git_tag\n")
+
+	ordersDir := filepath.Join(testDir, "orders")
+	if err := os.MkdirAll(ordersDir, 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", ordersDir, err)
+	}
+	writeFile(t, ordersDir, "order.proto", `syntax = "proto3";
+
+package orders;
+
+message Order {
+  string id = 1;
+}
+`)
+	writeFile(t, ordersDir, "bundle.yaml", `name: "orders"
+`)
+
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	content := readBuildFile(t, ordersDir)
+	requireContains(t, content, `name = "orders_version"`, "version genrule")
+	requireContains(t, content, "stamp = 1", "stamped version genrule")
+	requireContains(t, content, `STABLE_GIT_TAG_orders orders-v`, "tag status key in the cmd")
+	requireContains(t, content, `version_file = ":orders_version"`, "bundle rules read the version file")
+	requireContains(t, content, `--version-file $(location :orders_version)`, "pom reads the version file")
+	requireContains(t, content, `"--version-file=$(location :orders_version)"`, "publishers read the version file")
+	requireContains(t, content, `main = "publish/maven_publisher_generated.py"`, "py_binary maven publisher")
+	requireAbsent(t, content, "maven_publish(", "maven_publish (no literal version)")
+	requireAbsent(t, content, "--expected-version", "expected-version guard (no literal version)")
+	requireAbsent(t, content, "bundle_yaml", "bundle_yaml attrs")
+
+	pass1 := captureBuildFiles(t, testDir)
+	runGazelle(t, testDir, "-lang=proto,protolake")
+	requireBuildFilesIdentical(t, pass1, captureBuildFiles(t, testDir))
+
+	// Back to a literal version.
+	writeFile(t, testDir, "lake.yaml", lake)
+	writeFile(t, ordersDir, "bundle.yaml", `name: "orders"
+version: "1.4.0"
+`)
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	content = readBuildFile(t, ordersDir)
+	requireContains(t, content, `coordinates = "com.testcompany:orders-proto:1.4.0"`, "maven_publish coordinates")
+	requireContains(t, content, "--expected-version 1.4.0", "expected-version guard")
+	requireAbsent(t, content, "orders_version", "version genrule (literal version)")
+	requireAbsent(t, content, "version_file", "version_file attrs (literal version)")
+	requireAbsent(t, content, "maven_publisher_generated", "py_binary maven publisher (literal version)")
+
+	writeFile(t, testDir, "lake.yaml", lake+"  version_source: git_tag\n")
+	runGazelleExpectFatal(t, testDir, "sets `version` in bundle.yaml, but its version_source is git_tag")
+
+	// An artifact dependency can't name a git_tag bundle's version, and
+	// compiling the bundle in instead would publish its classes twice.
+	writeFile(t, ordersDir, "bundle.yaml", `name: "orders"
+`)
+	billingDir := filepath.Join(testDir, "billing")
+	if err := os.MkdirAll(billingDir, 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", billingDir, err)
+	}
+	writeFile(t, billingDir, "invoice.proto", `syntax = "proto3";
+
+package billing;
+
+import "orders/order.proto";
+
+message Invoice {
+  orders.Order order = 1;
+}
+`)
+	writeFile(t, billingDir, "bundle.yaml", `name: "billing"
+version: "1.0.0"
+config:
+  version_source: literal
+  bundle_dependencies: artifact
+`)
+	output, err := runGazelleCmd(t, testDir, "-lang=proto,protolake")
+	if want := "takes its version from STABLE_GIT_TAG_orders at build time, so it can't be declared as an artifact dependency"; err == nil || !strings.Contains(output, want) {
+		t.Errorf("Expected gazelle to fail with %q, got %v", want, err)
+	}
+}
+
+// TestGazelleBundleGraph: -protolake_graph writes the lake's bundles, the
+// imports between them and their external providers as JSON and DOT.
+func TestGazelleBundleGraph(t *testing.T) {
+	testDir := t.TempDir()
+	setupWorkspace(t, testDir)
+	runGazelle(t, testDir, "-protolake_graph=graph/lake")
+
+	data, err := os.ReadFile(filepath.Join(testDir, "graph", "lake.json"))
+	if err != nil {
+		t.Fatalf("Failed to read the JSON graph: %v", err)
+	}
+	var graph struct {
+		Bundles []struct {
+			Name        string            `json:"name"`
+			Package     string            `json:"package"`
+			Coordinates map[string]string `json:"coordinates"`
+			External    struct {
+				Java []string `json:"java"`
+			} `json:"external"`
+		} `json:"bundles"`
+		Imports []struct {
+			From   string `json:"from"`
+			Import string `json:"import"`
+			Bundle string `json:"bundle"`
+		} `json:"imports"`
+	}
+	if err := json.Unmarshal(data, &graph); err != nil {
+		t.Fatalf("Failed to parse the JSON graph: %v\n%s", err, data)
+	}
+
+	var names []string
+	for _, b := range graph.Bundles {
+		names = append(names, b.Name)
+		if b.Name != "user-service" {
+			continue
+		}
+		if got := b.Coordinates["maven"]; got != "com.testcompany.proto:user-service-proto" {
+			t.Errorf("Expected user-service's Maven coordinates, got %q", got)
+		}
+		if len(b.External.Java) == 0 {
+			t.Errorf("Expected user-service's googleapis provider in the graph, got %s", data)
+		}
+	}
+	if got := strings.Join(names, ","); got != "common-types,legacy-service,user-service" {
+		t.Errorf("Expected the three bundles in package order, got %s", got)
+	}
+	found := false
+	for _, imp := range graph.Imports {
+		found = found || (imp.From == "com/testcompany/user" &&
+			imp.Import == "com/testcompany/common/types/v1/common.proto" && imp.Bundle == "com/testcompany/common")
+	}
+	if !found {
+		t.Errorf("Expected the user-service import of common-types in the graph, got %s", data)
+	}
+
+	dot, err := os.ReadFile(filepath.Join(testDir, "graph", "lake.dot"))
+	if err != nil {
+		t.Fatalf("Failed to read the DOT graph: %v", err)
+	}
+	requireContains(t, string(dot), `"//com/testcompany/user" -> "//com/testcompany/common" [label = "com/testcompany/common/types/v1/common.proto"];`,
+		"bundle edge in the DOT graph")
+}
+
+// TestGazelleNestedBundles: a bundle under another bundle's directory is its
+// own bundle. The outer bundle's aggregate leaves its protos out, compiling
+// them in as well fails the run, a dependency on its artifact passes, and
+// publish_all releases the inner bundle first.
+func TestGazelleNestedBundles(t *testing.T) {
+	testDir := t.TempDir()
+
+	writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+`)
+	writeFile(t, testDir, "lake.yaml", `config:
+  language_defaults:
+    java:
+      enabled: true
+      group_id: "com.testcompany"
+`)
+
+	outerDir := filepath.Join(testDir, "com", "testcompany", "billing")
+	innerDir := filepath.Join(outerDir, "ledger")
+	for _, dir := range []string{filepath.Join(outerDir, "v1"), filepath.Join(innerDir, "v1")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	writeFile(t, outerDir, "bundle.yaml", `name: "billing"
+version: "1.0.0"
+config:
+  languages:
+    java:
+      artifact_id: "billing-proto"
+`)
+	writeFile(t, filepath.Join(outerDir, "v1"), "invoice.proto", `syntax = "proto3";
+
+package com.testcompany.billing.v1;
+
+import "com/testcompany/billing/ledger/v1/entry.proto";
+
+message Invoice {
+  repeated com.testcompany.billing.ledger.v1.Entry entries = 1;
+}
+`)
+	writeFile(t, innerDir, "bundle.yaml", `name: "ledger"
+version: "2.0.0"
+config:
+  languages:
+    java:
+      artifact_id: "ledger-proto"
+`)
+	writeFile(t, filepath.Join(innerDir, "v1"), "entry.proto", `syntax = "proto3";
+
+package com.testcompany.billing.ledger.v1;
+
+message Entry {
+  int64 cents = 1;
+}
+`)
+
+	// Compiling the nested bundle's protos into the outer bundle's JAR
+	// would publish them twice.
+	output, err := runGazelleCmd(t, testDir, "-lang=proto,protolake")
+	if want := `//com/testcompany/billing/ledger/v1:com_testcompany_billing_ledger_v1_proto, published by bundle "ledger" at //com/testcompany/billing/ledger for java`; err == nil || !strings.Contains(output, want) {
+		t.Fatalf("Expected gazelle to fail with %q, got %v", want, err)
+	}
+
+	writeFile(t, outerDir, "bundle.yaml", `name: "billing"
+version: "1.0.0"
+config:
+  bundle_dependencies: artifact
+  languages:
+    java:
+      artifact_id: "billing-proto"
+`)
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	outer := readBuildFile(t, outerDir)
+	requireContains(t, outer, `proto_library(
+    name = "billing_all_protos",
+    visibility = ["//visibility:public"],
+    deps = ["//com/testcompany/billing/v1:com_testcompany_billing_v1_proto"],
+)`, "outer aggregate without the nested bundle's protos")
+	requireAbsent(t, outer, "//com/testcompany/billing/ledger/v1:",
+		"outer bundle leaves the nested bundle's protos to it")
+	requireContains(t, outer, "--dependency com.testcompany:ledger-proto:2.0.0 ",
+		"outer POM depends on the nested bundle's artifact")
+
+	inner := readBuildFile(t, innerDir)
+	requireContains(t, inner, `proto_library(
+    name = "ledger_all_protos",
+    visibility = ["//visibility:public"],
+    deps = ["//com/testcompany/billing/ledger/v1:com_testcompany_billing_ledger_v1_proto"],
+)`, "nested bundle's own aggregate")
+
+	root := readBuildFile(t, testDir)
+	requireContains(t, root, `multirun(
+    name = "publish_all",
+    commands = [
+        "//com/testcompany/billing/ledger:publish_ledger_to_maven",
+        "//com/testcompany/billing:publish_billing_to_maven",
+    ],
+    jobs = 1,
+)`, "publish_all releases the nested bundle first")
+
+	pass1 := captureBuildFiles(t, testDir)
+	runGazelle(t, testDir, "-lang=proto,protolake")
+	pass2 := captureBuildFiles(t, testDir)
+	requireBuildFilesIdentical(t, pass1, pass2)
+}
+
+// TestGazelleGoPackages: a Go bundle gets one go_proto_library per proto
+// package, importing another Go bundle's package is a dep on it plus a
+// go.mod requirement, protos no bundle owns are compiled in as a package of
+// their own, and the rule of a package whose protos moved away is deleted.
+func TestGazelleGoPackages(t *testing.T) {
+	testDir := t.TempDir()
+
+	writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+`)
+	writeFile(t, testDir, "lake.yaml", `config:
+  language_defaults:
+    go:
+      enabled: true
+`)
+
+	ordersDir := filepath.Join(testDir, "com", "acme", "orders")
+	moneyDir := filepath.Join(testDir, "com", "acme", "money")
+	sharedDir := filepath.Join(testDir, "com", "acme", "shared", "v1")
+	for _, dir := range []string{filepath.Join(ordersDir, "v1"), filepath.Join(ordersDir, "v2"), filepath.Join(moneyDir, "v1"), sharedDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	writeFile(t, ordersDir, "bundle.yaml", `name: "orders"
+version: "1.0.0"
+config:
+  languages:
+    go:
+      module_path: "example.com/acme/orders"
+`)
+	// The package of protos that used to sit in the bundle directory.
+	writeFile(t, ordersDir, "BUILD.bazel", `load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+go_proto_library(
+    name = "orders_go_proto",
+    importpath = "example.com/acme/orders",
+    protos = ["//com/acme/orders:orders_proto"],
+    visibility = ["//visibility:public"],
+)
+`)
+	writeFile(t, filepath.Join(ordersDir, "v1"), "order.proto", `syntax = "proto3";
+
+package com.acme.orders.v1;
+
+import "com/acme/money/v1/money.proto";
+import "com/acme/shared/v1/audit.proto";
+
+message Order {
+  com.acme.money.v1.Money total = 1;
+  com.acme.shared.v1.Audit audit = 2;
+}
+`)
+	writeFile(t, filepath.Join(ordersDir, "v2"), "order.proto", `syntax = "proto3";
+
+package com.acme.orders.v2;
+
+import "com/acme/orders/v1/order.proto";
+
+message Order {
+  com.acme.orders.v1.Order legacy = 1;
+}
+`)
+	writeFile(t, moneyDir, "bundle.yaml", `name: "money"
+version: "1.3.0"
+config:
+  languages:
+    go:
+      module_path: "example.com/acme/money"
+`)
+	writeFile(t, filepath.Join(moneyDir, "v1"), "money.proto", `syntax = "proto3";
+
+package com.acme.money.v1;
+
+message Money {
+  int64 units = 1;
+}
+`)
+	writeFile(t, sharedDir, "audit.proto", `syntax = "proto3";
+
+package com.acme.shared.v1;
+
+message Audit {
+  string actor = 1;
+}
+`)
+
+	runGazelle(t, testDir, "-lang=proto,protolake")
+
+	content := readBuildFile(t, ordersDir)
+	requireContains(t, content, `go_proto_library(
+    name = "orders_v1_go_proto",
+    compilers = [
+        "@io_bazel_rules_go//proto:go_proto",
+        "@io_bazel_rules_go//proto:go_grpc_v2",
+    ],
+    importpath = "example.com/acme/orders/v1",
+    protos = ["//com/acme/orders/v1:com_acme_orders_v1_proto"],
+    visibility = ["//visibility:public"],
+    deps = [
+        ":orders_com_acme_shared_v1_go_proto",
+        "//com/acme/money:money_v1_go_proto",
+    ],
+)`, "v1 package depends on the shared package and the money bundle's package")
+	requireContains(t, content, `go_proto_library(
+    name = "orders_v2_go_proto",
+    compilers = [
+        "@io_bazel_rules_go//proto:go_proto",
+        "@io_bazel_rules_go//proto:go_grpc_v2",
+    ],
+    importpath = "example.com/acme/orders/v2",
+    protos = ["//com/acme/orders/v2:com_acme_orders_v2_proto"],
+    visibility = ["//visibility:public"],
+    deps = [":orders_v1_go_proto"],
+)`, "v2 package depends on v1")
+	requireContains(t, content, `go_proto_library(
+    name = "orders_com_acme_shared_v1_go_proto",
+    compilers = [
+        "@io_bazel_rules_go//proto:go_proto",
+        "@io_bazel_rules_go//proto:go_grpc_v2",
+    ],
+    importpath = "example.com/acme/orders/com/acme/shared/v1",
+    protos = ["//com/acme/shared/v1:com_acme_shared_v1_proto"],
+    visibility = ["//visibility:public"],
+)`, "unowned protos compiled in as their own package")
+	requireContains(t, content, `requires = ["example.com/acme/money@v1.3.0"],`, "go.mod requirement on the money module")
+	requireContains(t, content, `go_deps = [
+        ":orders_v1_go_proto",
+        ":orders_v2_go_proto",
+        ":orders_com_acme_shared_v1_go_proto",
+    ],`, "module bundle depends on every package")
+	requireAbsent(t, content, `"orders_go_proto"`, "the package whose protos moved away")
+	requireAbsent(t, content, "com_acme_money_v1_proto", "the money bundle's protos compiled in")
+
+	money := readBuildFile(t, moneyDir)
+	requireContains(t, money, `name = "money_v1_go_proto",`, "the money package the orders bundle depends on")
+
+	pass1 := captureBuildFiles(t, testDir)
+	runGazelle(t, testDir, "-lang=proto,protolake")
+	requireBuildFilesIdentical(t, pass1, captureBuildFiles(t, testDir))
+
+	// From v2 on, the go tool wants the major version in the module path.
+	writeFile(t, moneyDir, "bundle.yaml", `name: "money"
+version: "2.0.0"
+config:
+  languages:
+    go:
+      module_path: "example.com/acme/money"
+`)
+	output, err := runGazelleCmd(t, testDir, "-lang=proto,protolake")
+	if want := "the module path must end in /v2 (example.com/acme/money/v2)"; err == nil || !strings.Contains(output, want) {
+		t.Fatalf("Expected gazelle to fail with %q, got %v", want, err)
+	}
+
+	writeFile(t, moneyDir, "bundle.yaml", `name: "money"
+version: "2.0.0"
+config:
+  languages:
+    go:
+      module_path: "example.com/acme/money/v2"
+`)
+	runGazelle(t, testDir, "-lang=proto,protolake")
+	requireContains(t, readBuildFile(t, ordersDir), `requires = ["example.com/acme/money/v2@v2.0.0"],`,
+		"go.mod requirement on the money module's v2 path")
+}
+
+// TestGazelleCheckMode: -protolake_check passes on an up-to-date lake, and
+// after a bundle.yaml edit without regeneration fails with a per-bundle diff
+// of the stale attrs, leaving the BUILD files untouched. With -mode=diff, a
+// BUILD file another language would change fails the run too.
+func TestGazelleCheckMode(t *testing.T) {
+	testDir := t.TempDir()
+
+	writeFile(t, testDir, "MODULE.bazel", `module(name = "test_workspace", version = "0.0.1")
+`)
+	writeFile(t, testDir, "lake.yaml", `config:
+  language_defaults:
+    java:
+      enabled: true
+      group_id: "com.testcompany"
+`)
+
+	apiDir := filepath.Join(testDir, "com", "testcompany", "orders", "api", "v1")
+	if err := os.MkdirAll(apiDir, 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", apiDir, err)
+	}
+	ordersDir := filepath.Join(testDir, "com", "testcompany", "orders")
+	writeFile(t, apiDir, "orders.proto", `syntax = "proto3";
+
+package com.testcompany.orders.api.v1;
+
+message Order {
+  string id = 1;
+}
+`)
+	writeFile(t, ordersDir, "bundle.yaml", `name: "orders"
+version: "1.0.0"
+config:
+  languages:
+    java:
+      artifact_id: "orders-proto"
+`)
+
+	runGazelle(t, testDir, "-lang=proto,protolake")
+	runGazelle(t, testDir, "-lang=proto,protolake", "-protolake_check")
+
+	writeFile(t, ordersDir, "bundle.yaml", `name: "orders"
+version: "1.1.0"
+config:
+  languages:
+    java:
+      artifact_id: "orders-api-proto"
+    python:
+      enabled: true
+      package_name: "testcompany_orders_proto"
+`)
+	before := captureBuildFiles(t, testDir)
+	output, err := runGazelleCmd(t, testDir, "-lang=proto,protolake", "-protolake_check")
+	if err == nil {
+		t.Fatal("Gazelle check mode succeeded but the orders BUILD file is stale")
+	}
+	for _, want := range []string{
+		"BUILD files are out of date",
+		"bundle orders (com/testcompany/orders/BUILD.bazel):",
+		`publish_orders_to_maven: coordinates: "com.testcompany:orders-proto:1.0.0" -> "com.testcompany:orders-api-proto:1.1.0"`,
+		`orders_java_bundle: artifact_id: "orders-proto" -> "orders-api-proto"`,
+		"orders_py_bundle: missing, would be added",
+	} {
+		requireContains(t, output, want, "check mode report")
+	}
+	requireBuildFilesIdentical(t, before, captureBuildFiles(t, testDir))
+
+	runGazelle(t, testDir, "-lang=proto,protolake")
+	runGazelle(t, testDir, "-lang=proto,protolake", "-protolake_check")
+
+	// A proto outside every bundle is the proto extension's alone: the
+	// bundle check passes, and -mode=diff fails on the BUILD file gazelle
+	// would create for it without writing it.
+	miscDir := filepath.Join(testDir, "misc", "v1")
+	if err := os.MkdirAll(miscDir, 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", miscDir, err)
+	}
+	writeFile(t, miscDir, "misc.proto", `syntax = "proto3";
+
+package misc.v1;
+
+message Note {
+  string text = 1;
+}
+`)
+	before = captureBuildFiles(t, testDir)
+	output, err = runGazelleCmd(t, testDir, "-lang=proto,protolake", "-mode=diff", "-protolake_check")
+	if err == nil {
+		t.Fatal("Gazelle check mode with -mode=diff succeeded but misc/v1 has no BUILD file")
+	}
+	requireContains(t, output, "bundle BUILD files are up to date", "bundle check passes")
+	requireContains(t, output, "misc_v1_proto", "diff of the BUILD file the proto extension would create")
+	requireBuildFilesIdentical(t, before, captureBuildFiles(t, testDir))
+	if _, err := os.Stat(filepath.Join(miscDir, "BUILD.bazel")); !os.IsNotExist(err) {
+		t.Errorf("Expected -mode=diff to leave misc/v1 without a BUILD file, got %v", err)
+	}
+}
+
 // readBuildFile reads a BUILD.bazel or BUILD file from the given directory.
 func readBuildFile(t *testing.T, dir string) string {
 	t.Helper()
@@ -182,7 +1449,7 @@ gazelle(name = "gazelle")
       enabled: true
       group_id: "com.testcompany.proto"
       source_version: "11"
-      target_version: "8"
+      target_version: "17"
     python:
       enabled: true
       package_name: "testcompany_proto"
@@ -190,6 +1457,8 @@ gazelle(name = "gazelle")
     javascript:
       enabled: true
       package_name: "@testcompany/proto"
+  signing:
+    enabled: true
 `)
 
 	// --- tools/ ---
@@ -205,6 +1474,12 @@ def py_proto_bundle(name, proto_deps=[], py_deps=[], py_grpc_deps=[], package_na
 def js_proto_bundle(name, proto_deps=[], es_deps=[], package_name="", **kwargs):
     native.filegroup(name = name, srcs = es_deps + proto_deps, visibility = kwargs.get("visibility", []))
 
+def go_proto_bundle(name, proto_deps=[], go_deps=[], module_path="", **kwargs):
+    native.filegroup(name = name, srcs = go_deps + proto_deps, visibility = kwargs.get("visibility", []))
+
+def buf_proto_bundle(name, proto_deps=[], buf_yaml="", **kwargs):
+    native.filegroup(name = name, srcs = proto_deps + [buf_yaml], visibility = kwargs.get("visibility", []))
+
 def build_validation(name, targets=[], **kwargs):
     native.genrule(name = name, outs = [name + ".validation"], cmd = "echo 'Build validation passed' > $@", **kwargs)
 
@@ -246,6 +1521,9 @@ config:
     javascript:
       enabled: true
       package_name: "@testcompany/user-service-proto"
+    go:
+      enabled: true
+      module_path: "github.com/testcompany/user-service-proto"
 `)
 
 	// Empty BUILD at bundle root (protos live in subdirectory)
@@ -393,6 +1671,19 @@ py_binary(
     visibility = ["//visibility:public"],
 )
 
+py_binary(
+    name = "publish_common-types_to_npm_local",
+    srcs = ["//tools:publish/npm_publisher_generated.py"],
+    args = [
+        "$(location :common-types_js_bundle)",
+        "--package-name=@testcompany/common-types-proto",
+        "--version-suffix=-local",
+    ],
+    data = [":common-types_js_bundle"],
+    main = "publish/npm_publisher_generated.py",
+    visibility = ["//visibility:public"],
+)
+
 alias(
     name = "publish_to_npm",
     actual = ":publish_common-types_to_npm",
@@ -635,8 +1926,8 @@ func writeFile(t *testing.T, dir, name, content string) {
 
 // runGazelleCmd executes the gazelle binary on the test workspace and returns
 // its combined output and exit error, letting callers assert success or an
-// expected fail-fast.
-func runGazelleCmd(t *testing.T, testDir string) (string, error) {
+// expected fail-fast. args default to a protolake-only pass.
+func runGazelleCmd(t *testing.T, testDir string, args ...string) (string, error) {
 	t.Helper()
 	gazelleBinary := findGazelleBinary(t)
 
@@ -654,7 +1945,10 @@ func runGazelleCmd(t *testing.T, testDir string) (string, error) {
 		t.Fatalf("Failed to change to test directory: %v", err)
 	}
 
-	cmd := exec.Command(gazelleBinary, "-lang=protolake")
+	if len(args) == 0 {
+		args = []string{"-lang=protolake"}
+	}
+	cmd := exec.Command(gazelleBinary, args...)
 	cmd.Env = append(os.Environ(),
 		"MAVEN_REPO=file://~/.m2/repository",
 		"PYPI_REPO=file://~/.pypi",
@@ -668,9 +1962,9 @@ func runGazelleCmd(t *testing.T, testDir string) (string, error) {
 
 // runGazelle executes the gazelle binary on the test workspace, failing the
 // test if gazelle fails.
-func runGazelle(t *testing.T, testDir string) {
+func runGazelle(t *testing.T, testDir string, args ...string) {
 	t.Helper()
-	if output, err := runGazelleCmd(t, testDir); err != nil {
+	if output, err := runGazelleCmd(t, testDir, args...); err != nil {
 		t.Fatalf("Gazelle execution failed: %v\nOutput: %s", err, output)
 	}
 }
@@ -738,7 +2032,7 @@ func findGazelleBinary(t *testing.T) string {
 // ---------------------------------------------------------------------------
 
 // verifyUserBundle checks the user-service bundle BUILD file.
-// All 3 languages are enabled; cross-bundle dependency on common.
+// All 4 languages are enabled; cross-bundle dependency on common.
 func verifyUserBundle(t *testing.T, content string) {
 	// Bundle rules
 	requireContains(t, content, "java_proto_bundle", "java_proto_bundle rule")
@@ -754,6 +2048,46 @@ func verifyUserBundle(t *testing.T, content string) {
 	requireContains(t, content, "python_grpc_library", "python_grpc_library")
 	requireContains(t, content, "es_proto_compile", "es_proto_compile")
 
+	// Lake-wide Java and Python versions.
+	requireContains(t, content, `javacopts = [
+        "-source",
+        "11",
+        "-target",
+        "17",
+    ]`, "java_grpc_library pins the lake's source/target levels")
+	requireContains(t, content, "--java-source 11 --java-target 17 ", "POM records the Java levels")
+	requireContains(t, content, `python_requires = ">=3.8"`, "wheel carries Requires-Python")
+
+	// Go path: one go_proto_library per proto package with message + gRPC
+	// compilers, module bundle, goproxy publisher. import_path_prefix is
+	// unset, so importpaths sit under the module path. common-types sits
+	// outside the bundle, so its package is compiled in at its repo path.
+	requireContains(t, content, `go_proto_library(
+    name = "user-service_api_v1_go_proto",`, "go_proto_library per proto package")
+	requireContains(t, content, `importpath = "github.com/testcompany/user-service-proto/api/v1"`,
+		"go_proto_library importpath defaults to module_path plus the package path")
+	requireContains(t, content, `importpath = "github.com/testcompany/user-service-proto/extra"`,
+		"second proto package gets its own go_proto_library")
+	requireContains(t, content, `importpath = "github.com/testcompany/user-service-proto/com/testcompany/common/types/v1"`,
+		"compiled-in package sits at its repo path")
+	requireContains(t, content, `":user-service_com_testcompany_common_types_v1_go_proto",`,
+		"importing package depends on the compiled-in package")
+	requireContains(t, content, `go_deps = [
+        ":user-service_api_v1_go_proto",
+        ":user-service_extra_go_proto",
+        ":user-service_com_testcompany_common_types_v1_go_proto",
+    ],`, "module bundle depends on every Go package")
+	requireContains(t, content, `"@io_bazel_rules_go//proto:go_grpc_v2"`, "Go gRPC compiler")
+	requireContains(t, content, "go_proto_bundle(", "go_proto_bundle rule")
+	requireContains(t, content, `module_path = "github.com/testcompany/user-service-proto"`, "Go module_path")
+	requireContains(t, content, "publish_user-service_to_goproxy", "goproxy publish target")
+	requireContains(t, content, `--module-path=github.com/testcompany/user-service-proto`,
+		"goproxy publisher module path arg")
+	requireContains(t, content, `"@org_golang_google_genproto_googleapis_api//annotations"`,
+		"go_proto_library deps include genproto annotations")
+	requireContains(t, content, `"@com_google_cloud_go_longrunning//autogen/longrunningpb"`,
+		"go_proto_library deps include longrunning Go package")
+
 	// Aggregated proto rule
 	requireContains(t, content, "_all_protos", "aggregated proto rule")
 
@@ -772,8 +2106,35 @@ func verifyUserBundle(t *testing.T, content string) {
 	requireContains(t, content, "publish_user-service_to_maven_local", "maven local publish target")
 	requireContains(t, content, `coordinates = "com.testcompany.proto:user-service-proto:1.0.0-local"`,
 		"local Maven coordinates carry the -local qualifier")
+	requireContains(t, content, "publish_user-service_to_pypi_local", "pypi local publish target")
+	requireContains(t, content, `"--version-suffix=+local"`, "pypi local twin uses a PEP 440 local version")
+	requireContains(t, content, "publish_user-service_to_npm_local", "npm local publish target")
+	requireContains(t, content, `"--version-suffix=-local"`, "npm local twin uses a semver prerelease")
+	// Snapshot twin: CI publishes ahead of the next patch release.
+	requireContains(t, content, `coordinates = "com.testcompany.proto:user-service-proto:1.0.1-SNAPSHOT"`,
+		"snapshot Maven coordinates carry the next patch version and -SNAPSHOT")
+	requireContains(t, content, `"--next-version=1.0.1"`, "pypi and npm snapshot twins publish the next patch version")
+	requireContains(t, content, "publish_user-service_to_pypi_snapshot", "pypi snapshot publish target")
+	requireContains(t, content, `"--snapshot-qualifier=.dev"`, "pypi snapshot twin publishes a dev release")
+	requireContains(t, content, "publish_user-service_to_npm_snapshot", "npm snapshot publish target")
+	requireContains(t, content, `"--dist-tag=next"`, "npm snapshot twin stays off latest")
 	requireAbsent(t, content, "${VERSION:", "no runtime VERSION env-var dance after gazelle bake")
 
+	// Central's sources and javadoc JARs ride on every maven_publish rule;
+	// only the release one is signed.
+	requireContains(t, content, `java_source_bundle(
+    name = "user-service_java_sources",
+    srcjars = [":libuser-service_java_grpc-src.jar"],`, "sources JAR from the generated source JAR")
+	requireContains(t, content, `javadoc(
+    name = "user-service_javadoc",`, "javadoc JAR target")
+	requireContains(t, content, `load("@rules_jvm_external//:defs.bzl", "javadoc")`, "javadoc load")
+	if n := strings.Count(content, `":user-service_java_sources": "sources",`); n != 3 {
+		t.Errorf("Expected the sources JAR on all three maven_publish rules, found it on %d", n)
+	}
+	if n := strings.Count(content, `toolchains = ["//tools:maven_gpg_signing"]`); n != 1 {
+		t.Errorf("Expected only the release maven_publish to be signed, found %d signing toolchains", n)
+	}
+
 	// Version-from-bundle.yaml wiring (PL-bstm): the pom genrules read
 	// bundle.yaml at build time, the local twin appends the -local qualifier,
 	// and the py_binary publishers get bundle.yaml via data + --bundle-yaml.
@@ -796,11 +2157,12 @@ func verifyUserBundle(t *testing.T, content string) {
 		"py_binary publishers resolve version from bundle.yaml at run time")
 	requireAbsent(t, content, `--version=`, "no version literal in py_binary args")
 
-	// build_validation with all 3 language bundle targets
+	// build_validation with all 4 language bundle targets
 	requireContains(t, content, "build_validation", "build_validation rule")
 	requireContains(t, content, "user-service_java_bundle", "java target in build_validation")
 	requireContains(t, content, "user-service_py_bundle", "python target in build_validation")
 	requireContains(t, content, "user-service_js_bundle", "js target in build_validation")
+	requireContains(t, content, "user-service_go_bundle", "go target in build_validation")
 
 	// java_proto_bundle, py_proto_bundle, js_proto_bundle each carry a
 	// `bundle_yaml` label instead of a baked `version` attr — the bundlers
@@ -866,10 +2228,14 @@ func verifyCommonBundle(t *testing.T, content string) {
 	// carried each of these rules in stale old form.
 	requireAbsent(t, content, "js_proto_bundle", "js_proto_bundle (JS disabled, stale rule deleted)")
 	requireAbsent(t, content, "es_proto_compile", "es_proto_compile (JS disabled, stale rule deleted)")
-	requireAbsent(t, content, "publish_common-types_to_npm", "npm publish (JS disabled, stale rule deleted)")
+	requireAbsent(t, content, "publish_common-types_to_npm", "npm publish and its local twin (JS disabled, stale rules deleted)")
 	requireAbsent(t, content, "publish_to_npm", "npm publish alias (JS disabled, stale alias deleted)")
 	requireAbsent(t, content, "9.9.9", "stale seeded version literal fully gone")
 
+	// Go is not enabled anywhere for common-types (no lake default).
+	requireAbsent(t, content, "go_proto_library", "go_proto_library (Go not enabled)")
+	requireAbsent(t, content, "go_proto_bundle", "go_proto_bundle (Go not enabled)")
+
 	// Publish rules — maven coordinates keep the gazelle-baked literal (2.3.0);
 	// everything else resolves the version from bundle.yaml at build/run time.
 	requireContains(t, content, "maven_publish", "maven_publish rule")
@@ -0,0 +1,374 @@
+package language
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/merger"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+const (
+	protoLibraryKind = "proto_library"
+
+	// noProtolakeTag opts a proto_library out of bundle discovery, following
+	// Bazel's `no-<feature>` tag convention. The target still resolves imports
+	// for other bundles — it is only kept out of this bundle's artifacts.
+	noProtolakeTag = "no-protolake"
+)
+
+// protoLibraryInfo is the structurally parsed view of a proto_library rule
+// (or a macro known to wrap one) in a BUILD file.
+type protoLibraryInfo struct {
+	Name              string
+	Srcs              []string // package-relative, as written or glob-expanded
+	StripImportPrefix string
+	ImportPrefix      string
+	Tags              []string
+}
+
+// hasTag reports whether the rule carries the given tag.
+func (p protoLibraryInfo) hasTag(tag string) bool {
+	for _, t := range p.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// importPath returns the path other protos use to import src, applying
+// proto_library's strip_import_prefix / import_prefix the way Bazel does: a
+// strip prefix starting with "/" is repo-root relative, anything else is
+// relative to the package.
+func (p protoLibraryInfo) importPath(pkg, src string) string {
+	full := path.Join(pkg, src)
+	if p.StripImportPrefix != "" {
+		strip := p.StripImportPrefix
+		if strings.HasPrefix(strip, "/") {
+			strip = strings.TrimPrefix(strip, "/")
+		} else {
+			strip = path.Join(pkg, strip)
+		}
+		// A bare "/" strips nothing.
+		if strip != "" && strip != "." && (full == strip || strings.HasPrefix(full, strip+"/")) {
+			full = strings.TrimPrefix(strings.TrimPrefix(full, strip), "/")
+		}
+	}
+	if p.ImportPrefix != "" {
+		full = path.Join(p.ImportPrefix, full)
+	}
+	return full
+}
+
+// findBuildFile returns the path of the BUILD file in dir, honoring the
+// configured build file names, or "" when there is none.
+func findBuildFile(c *config.Config, dir string) string {
+	names := []string{buildBazelFile, buildFile}
+	if c != nil && len(c.ValidBuildFileNames) > 0 {
+		names = c.ValidBuildFileNames
+	}
+	for _, name := range names {
+		bf := filepath.Join(dir, name)
+		if info, err := os.Stat(bf); err == nil && !info.IsDir() {
+			return bf
+		}
+	}
+	return ""
+}
+
+// loadProtoLibraries parses the BUILD file in dir with gazelle's Starlark
+// loader and returns every proto_library in it. Unlike a textual scan this
+// survives parens in attribute values (select(), glob(), comments) and
+// recognizes proto_library loaded under an alias, mapped with
+// `# gazelle:map_kind`, or wrapped by a macro declared with
+// `# gazelle:alias_kind`. Returns nil when dir has no BUILD file or it fails
+// to parse (logged — a broken BUILD file fails the bazel build on its own).
+func loadProtoLibraries(c *config.Config, dir string) []protoLibraryInfo {
+	f := loadBuildFile(c, dir)
+	if f == nil {
+		return nil
+	}
+	return protoLibrariesInFile(c, f, dir)
+}
+
+// loadBuildFile parses the BUILD file in dir, returning nil when there is
+// none or it fails to parse.
+func loadBuildFile(c *config.Config, dir string) *rule.File {
+	bf := findBuildFile(c, dir)
+	if bf == "" {
+		return nil
+	}
+	pkg := ""
+	if c != nil && c.RepoRoot != "" {
+		if rel, err := filepath.Rel(c.RepoRoot, dir); err == nil && rel != "." {
+			pkg = filepath.ToSlash(rel)
+		}
+	}
+	f, err := rule.LoadFile(bf, pkg)
+	if err != nil {
+		log.Printf("Failed to parse BUILD file %s: %v", bf, err)
+		return nil
+	}
+	return f
+}
+
+// protoLibrariesInFile extracts proto_library rules from an already-loaded
+// BUILD file. dir is the package directory, used to expand glob() in srcs.
+func protoLibrariesInFile(c *config.Config, f *rule.File, dir string) []protoLibraryInfo {
+	kinds := protoLibraryKinds(c, f)
+	var libs []protoLibraryInfo
+	for _, r := range f.Rules {
+		if !kinds[r.Kind()] || r.Name() == "" {
+			continue
+		}
+		libs = append(libs, protoLibraryFromRule(r, dir))
+	}
+	return libs
+}
+
+// protoLibraryFromRule builds the structural view of one proto_library rule.
+func protoLibraryFromRule(r *rule.Rule, dir string) protoLibraryInfo {
+	return protoLibraryInfo{
+		Name:              r.Name(),
+		Srcs:              evalStringList(r.Attr("srcs"), dir),
+		StripImportPrefix: r.AttrString("strip_import_prefix"),
+		ImportPrefix:      r.AttrString("import_prefix"),
+		Tags:              evalStringList(r.Attr("tags"), dir),
+	}
+}
+
+// effectiveProtoLibraries returns the proto_library rules the package in args
+// will hold once gazelle writes this pass: the existing BUILD file's rules,
+// minus those the proto extension emptied, with the proto extension's
+// generated rules merged in. Matching mirrors merger.MergeFile — an existing
+// rule matched by name or by srcs keeps its name — so the labels recorded
+// here are the labels that end up on disk.
+func effectiveProtoLibraries(args language.GenerateArgs) []protoLibraryInfo {
+	var existing []*rule.Rule
+	if args.File != nil {
+		kinds := protoLibraryKinds(args.Config, args.File)
+		for _, r := range args.File.Rules {
+			if kinds[r.Kind()] && r.Name() != "" {
+				existing = append(existing, r)
+			}
+		}
+	}
+	var aliases map[string]string
+	if args.Config != nil {
+		aliases = args.Config.AliasMap
+	}
+
+	// The proto extension empties rules whose srcs no longer exist. Empty
+	// rules match by name only.
+	for _, empty := range args.OtherEmpty {
+		if empty.Kind() != protoLibraryKind {
+			continue
+		}
+		if old, _ := merger.Match(existing, empty, rule.KindInfo{}, aliases); old != nil && !old.ShouldKeep() {
+			existing = removeRule(existing, old)
+		}
+	}
+
+	var libs []protoLibraryInfo
+	for _, gen := range args.OtherGen {
+		if gen.Kind() != protoLibraryKind {
+			continue
+		}
+		lib := protoLibraryFromRule(gen, args.Dir)
+		if old, _ := merger.Match(existing, gen, rule.KindInfo{MatchAttrs: []string{"srcs"}}, aliases); old != nil {
+			// Merged into the existing rule: its name and non-mergeable
+			// attrs (tags) survive.
+			lib.Name = old.Name()
+			lib.Tags = evalStringList(old.Attr("tags"), args.Dir)
+			existing = removeRule(existing, old)
+		}
+		libs = append(libs, lib)
+	}
+	for _, r := range existing {
+		libs = append(libs, protoLibraryFromRule(r, args.Dir))
+	}
+	return libs
+}
+
+func removeRule(rules []*rule.Rule, r *rule.Rule) []*rule.Rule {
+	for i := range rules {
+		if rules[i] == r {
+			return append(rules[:i:i], rules[i+1:]...)
+		}
+	}
+	return rules
+}
+
+// protoLibraryKinds returns the set of rule kinds in f that denote a
+// proto_library: the plain name, any local alias from a load statement
+// (`load(..., my_proto = "proto_library")`), gazelle's map_kind replacement,
+// and every alias_kind macro wrapping proto_library.
+func protoLibraryKinds(c *config.Config, f *rule.File) map[string]bool {
+	kinds := map[string]bool{protoLibraryKind: true}
+	for _, l := range f.Loads {
+		for _, pair := range l.SymbolPairs() {
+			if pair.From == protoLibraryKind {
+				kinds[pair.To] = true
+			}
+		}
+	}
+	if c != nil {
+		if mk, ok := c.KindMap[protoLibraryKind]; ok {
+			kinds[mk.KindName] = true
+		}
+		for alias, underlying := range c.AliasMap {
+			if underlying == protoLibraryKind {
+				kinds[alias] = true
+			}
+		}
+	}
+	return kinds
+}
+
+// evalStringList statically evaluates a Starlark expression that should
+// yield a list of strings: list literals, `+` concatenation, glob() (expanded
+// against dir) and select() (the union of every branch — discovery wants
+// every file any configuration could compile). Anything else yields nothing.
+func evalStringList(expr bzl.Expr, dir string) []string {
+	switch e := expr.(type) {
+	case nil:
+		return nil
+	case *bzl.ListExpr:
+		var out []string
+		for _, item := range e.List {
+			if s, ok := item.(*bzl.StringExpr); ok {
+				out = append(out, s.Value)
+			}
+		}
+		return out
+	case *bzl.BinaryExpr:
+		if e.Op != "+" {
+			return nil
+		}
+		return append(evalStringList(e.X, dir), evalStringList(e.Y, dir)...)
+	case *bzl.CallExpr:
+		fn, ok := e.X.(*bzl.Ident)
+		if !ok {
+			return nil
+		}
+		switch fn.Name {
+		case "glob":
+			return evalGlob(e, dir)
+		case "select":
+			return evalSelect(e, dir)
+		}
+	}
+	return nil
+}
+
+// evalSelect returns the deduplicated union of every branch of a select().
+func evalSelect(call *bzl.CallExpr, dir string) []string {
+	if len(call.List) == 0 {
+		return nil
+	}
+	dict, ok := call.List[0].(*bzl.DictExpr)
+	if !ok {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var out []string
+	for _, kv := range dict.List {
+		for _, s := range evalStringList(kv.Value, dir) {
+			if !seen[s] {
+				seen[s] = true
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// evalGlob expands glob(include, exclude = [...]) against the files under
+// dir, stopping at subpackages like Bazel does. Results are sorted.
+func evalGlob(call *bzl.CallExpr, dir string) []string {
+	var include, exclude []string
+	for i, arg := range call.List {
+		if assign, ok := arg.(*bzl.AssignExpr); ok {
+			if key, ok := assign.LHS.(*bzl.Ident); ok {
+				switch key.Name {
+				case "include":
+					include = evalStringList(assign.RHS, dir)
+				case "exclude":
+					exclude = evalStringList(assign.RHS, dir)
+				}
+			}
+			continue
+		}
+		if i == 0 {
+			include = evalStringList(arg, dir)
+		}
+	}
+	if len(include) == 0 || dir == "" {
+		return nil
+	}
+
+	var out []string
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if p != dir && (strings.HasPrefix(info.Name(), bazelDirPrefix) || findBuildFile(nil, p) != "") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if matchesAnyGlob(include, rel) && !matchesAnyGlob(exclude, rel) {
+			out = append(out, rel)
+		}
+		return nil
+	})
+	sort.Strings(out)
+	return out
+}
+
+func matchesAnyGlob(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if globMatch(strings.Split(p, "/"), strings.Split(rel, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch matches path segments against pattern segments, where a "**"
+// segment matches zero or more path segments.
+func globMatch(pattern, segs []string) bool {
+	if len(pattern) == 0 {
+		return len(segs) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segs); i++ {
+			if globMatch(pattern[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	if ok, err := path.Match(pattern[0], segs[0]); err != nil || !ok {
+		return false
+	}
+	return globMatch(pattern[1:], segs[1:])
+}
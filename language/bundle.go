@@ -1,71 +1,182 @@
 package language
 
 import (
-	yaml "gopkg.in/yaml.v3"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // LakeConfig represents the lake.yaml configuration structure
 // Based on the LakeConfig message in lake.proto
 type LakeConfig struct {
 	Config struct {
+		// BundleDependencies is the lake-wide default for how a bundle treats
+		// protos it imports from another bundle (see MergedConfig).
+		BundleDependencies string `yaml:"bundle_dependencies" schema:"enum=compile|artifact"`
+		// VersionSource is the lake-wide default for where bundle versions
+		// come from (see VersionSourceLiteral).
+		VersionSource string `yaml:"version_source" schema:"enum=literal|git_tag|stamp"`
+		// ExternalProtos maps import-path prefixes outside the lake to the
+		// targets that provide them; see defaultExternalProtos for the
+		// built-in entries these extend or replace.
+		ExternalProtos   []ExternalProtoProvider `yaml:"external_protos"`
 		LanguageDefaults struct {
 			Java struct {
 				Enabled       bool   `yaml:"enabled"`
 				GroupId       string `yaml:"group_id"`
+				ArtifactId    string `yaml:"artifact_id" schema:"template"` // e.g. "{{.Name}}-proto"; see naming.go
 				SourceVersion string `yaml:"source_version"`
 				TargetVersion string `yaml:"target_version"`
 				FatJar        bool   `yaml:"fat_jar"`
+				// ProtobufVersion and GrpcVersion pin the runtimes the POM
+				// declares (protobuf-java, io.grpc); see runtimes.go.
+				ProtobufVersion string `yaml:"protobuf_version"`
+				GrpcVersion     string `yaml:"grpc_version"`
 			} `yaml:"java"`
 			Python struct {
-				Enabled     bool   `yaml:"enabled"`
-				PackageName string `yaml:"package_name"`
-				Version     string `yaml:"python_version"`
+				Enabled       bool   `yaml:"enabled"`
+				PackagePrefix string `yaml:"package_prefix"`
+				PackageName   string `yaml:"package_name" schema:"template"`
+				Version       string `yaml:"python_version"`
+				// protobuf and grpcio requirements of the wheel.
+				ProtobufVersion string `yaml:"protobuf_version"`
+				GrpcVersion     string `yaml:"grpc_version"`
 			} `yaml:"python"`
 			Javascript struct {
-				Enabled     bool   `yaml:"enabled"`
-				PackageName string `yaml:"package_name"`
-				ProtoLoader bool   `yaml:"proto_loader"`
+				Enabled      bool   `yaml:"enabled"`
+				PackageScope string `yaml:"package_scope"`
+				PackageName  string `yaml:"package_name" schema:"template"`
+				ProtoLoader  bool   `yaml:"proto_loader"`
+				// @bufbuild/protobuf and @connectrpc/connect dependencies of
+				// the package.
+				ProtobufVersion string `yaml:"protobuf_version"`
+				GrpcVersion     string `yaml:"grpc_version"`
 			} `yaml:"javascript"`
+			Go struct {
+				Enabled          bool   `yaml:"enabled"`
+				ModulePath       string `yaml:"module_path"`
+				ImportPathPrefix string `yaml:"import_path_prefix"`
+			} `yaml:"go"`
+			Buf struct {
+				Enabled       bool     `yaml:"enabled"`
+				Module        string   `yaml:"module" schema:"template"` // e.g. "buf.build/example/{{.Name}}"
+				Deps          []string `yaml:"deps"`
+				LocalRegistry string   `yaml:"local_registry"`
+			} `yaml:"buf"`
 		} `yaml:"language_defaults"`
+		// Signing turns on GPG signing of release Maven publishes.
+		Signing SigningConfig `yaml:"signing"`
+		// Versioning is the policy bundle.yaml versions are checked against.
+		Versioning VersioningConfig `yaml:"versioning"`
 	} `yaml:"config"`
+	// Metadata is the lake-wide default publish metadata (see Metadata).
+	Metadata Metadata `yaml:"metadata"`
+}
+
+// SigningConfig is lake.yaml's signing section. Maven Central requires a GPG
+// signature on every file of a release; see signingToolchain for how it
+// reaches maven_publish.
+type SigningConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// InMemoryKeys signs with the armored key in the PGP_SIGNING_KEY env var,
+	// as CI does, instead of the local gpg keyring.
+	InMemoryKeys bool `yaml:"in_memory_keys"`
+}
+
+// ExternalProtoProvider maps the protos imported under Prefix (e.g.
+// "google/type/") to the per-language Bazel targets that satisfy them. See
+// ExternalProtoDeps for how each list is wired.
+type ExternalProtoProvider struct {
+	Prefix         string   `yaml:"prefix" schema:"required"`
+	Java           []string `yaml:"java"`            // java_grpc_library deps (umbrella libraries)
+	ProtoLibraries []string `yaml:"proto_libraries"` // compiled into the Python and JS artifacts
+	Go             []string `yaml:"go"`              // go_proto_library deps
 }
 
 // BundleConfig represents the bundle.yaml configuration structure
 // Based on the new protolake format
 type BundleConfig struct {
 	// Bundle metadata fields
-	Name         string `yaml:"name"`
+	Name         string `yaml:"name" schema:"required"`
 	DisplayName  string `yaml:"display_name"`
 	Description  string `yaml:"description"`
 	BundlePrefix string `yaml:"bundle_prefix"`
 	Version      string `yaml:"version"`
+	// Metadata overrides the lake's and groups' publish metadata.
+	Metadata Metadata `yaml:"metadata"`
 
 	// Config section with language-specific settings
 	Config struct {
-		GenerateDescriptorSet bool `yaml:"generate_descriptor_set"`
-		Languages             struct {
-			Java struct {
-				Enabled    *bool  `yaml:"enabled"` // Use pointer to distinguish between unset and false
-				GroupId    string `yaml:"group_id"`
-				ArtifactId string `yaml:"artifact_id"`
-				FatJar     *bool  `yaml:"fat_jar"`
-			} `yaml:"java"`
-			Python struct {
-				Enabled     *bool  `yaml:"enabled"` // Use pointer to distinguish between unset and false
-				PackageName string `yaml:"package_name"`
-			} `yaml:"python"`
-			Javascript struct {
-				Enabled     *bool  `yaml:"enabled"` // Use pointer to distinguish between unset and false
-				PackageName string `yaml:"package_name"`
-				ProtoLoader *bool  `yaml:"proto_loader"`
-			} `yaml:"javascript"`
-		} `yaml:"languages"`
+		GenerateDescriptorSet bool              `yaml:"generate_descriptor_set"`
+		BundleDependencies    string            `yaml:"bundle_dependencies" schema:"enum=compile|artifact"`
+		DependencyMode        string            `yaml:"dependency_mode" schema:"enum=direct|transitive"` // see MergedConfig.DependencyMode
+		VersionSource         string            `yaml:"version_source" schema:"enum=literal|git_tag|stamp"`
+		Languages             LanguageOverrides `yaml:"languages"`
+		// BreakingCheck, when set, adds the <bundle>_breaking_check test.
+		BreakingCheck *BreakingCheckConfig `yaml:"breaking_check"`
 	} `yaml:"config"`
 }
 
+// BreakingCheckConfig is bundle.yaml's breaking_check section: the
+// descriptor set a bundle's protos are compared against, and the version it
+// was published as.
+type BreakingCheckConfig struct {
+	// Baseline is a descriptor set file in the bundle directory, or the label
+	// of one, e.g. a previously published descriptor fetched by http_file.
+	Baseline        string `yaml:"baseline" schema:"required"`
+	BaselineVersion string `yaml:"baseline_version" schema:"required"`
+}
+
+// LanguageOverrides is the per-language section of bundle.yaml and
+// group.yaml. Every set field overrides the layer below it; Enabled and the
+// other *bool fields distinguish unset (inherit) from an explicit false.
+type LanguageOverrides struct {
+	Java struct {
+		Enabled       *bool  `yaml:"enabled"` // Use pointer to distinguish between unset and false
+		GroupId       string `yaml:"group_id"`
+		ArtifactId    string `yaml:"artifact_id" schema:"template"`
+		FatJar        *bool  `yaml:"fat_jar"`
+		SourceVersion string `yaml:"source_version"`
+		TargetVersion string `yaml:"target_version"`
+	} `yaml:"java"`
+	Python struct {
+		Enabled       *bool  `yaml:"enabled"` // Use pointer to distinguish between unset and false
+		PackageName   string `yaml:"package_name" schema:"template"`
+		PythonVersion string `yaml:"python_version"`
+	} `yaml:"python"`
+	Javascript struct {
+		Enabled     *bool  `yaml:"enabled"` // Use pointer to distinguish between unset and false
+		PackageName string `yaml:"package_name" schema:"template"`
+		ProtoLoader *bool  `yaml:"proto_loader"`
+	} `yaml:"javascript"`
+	Go struct {
+		Enabled          *bool  `yaml:"enabled"` // Use pointer to distinguish between unset and false
+		ModulePath       string `yaml:"module_path"`
+		ImportPathPrefix string `yaml:"import_path_prefix"`
+	} `yaml:"go"`
+	Buf struct {
+		Enabled       *bool    `yaml:"enabled"` // Use pointer to distinguish between unset and false
+		Module        string   `yaml:"module" schema:"template"`
+		Deps          []string `yaml:"deps"`
+		LocalRegistry string   `yaml:"local_registry"`
+	} `yaml:"buf"`
+}
+
+// GroupConfig represents a group.yaml: defaults for every bundle in its
+// directory's subtree, layered between lake.yaml and bundle.yaml. A team
+// owning e.g. payments/ sets its group_id or languages once there instead of
+// in each bundle.yaml.
+type GroupConfig struct {
+	Config struct {
+		BundleDependencies string            `yaml:"bundle_dependencies" schema:"enum=compile|artifact"`
+		DependencyMode     string            `yaml:"dependency_mode" schema:"enum=direct|transitive"`
+		VersionSource      string            `yaml:"version_source" schema:"enum=literal|git_tag|stamp"`
+		Languages          LanguageOverrides `yaml:"languages"`
+	} `yaml:"config"`
+	Metadata Metadata `yaml:"metadata"`
+}
+
 // LoadLakeConfig loads lake.yaml configuration from the given directory
 // Walks up the directory tree to find lake.yaml
 func LoadLakeConfig(startDir string) (*LakeConfig, error) {
@@ -82,7 +193,7 @@ func LoadLakeConfig(startDir string) (*LakeConfig, error) {
 			}
 
 			var config LakeConfig
-			if err := yaml.Unmarshal(data, &config); err != nil {
+			if err := decodeConfig(lakeFile, data, &config); err != nil {
 				return nil, err
 			}
 
@@ -100,6 +211,12 @@ func LoadLakeConfig(startDir string) (*LakeConfig, error) {
 	return nil, nil // No lake.yaml found
 }
 
+// isBundleDir reports whether dir holds a bundle.yaml.
+func isBundleDir(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, bundleYamlFile))
+	return err == nil
+}
+
 // LoadBundleConfig loads bundle.yaml configuration from the given directory
 func LoadBundleConfig(dir string) (*BundleConfig, error) {
 	bundleFile := filepath.Join(dir, bundleYamlFile)
@@ -115,51 +232,128 @@ func LoadBundleConfig(dir string) (*BundleConfig, error) {
 		return nil, err
 	}
 
-	// Parse YAML
+	// Parse YAML strictly: unknown keys, wrong types and a missing name are
+	// errors (see decodeConfig), never a silently skipped bundle.
 	var config BundleConfig
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	if err := decodeConfig(bundleFile, data, &config); err != nil {
 		return nil, err
 	}
 
-	// Validate required fields
-	if config.Name == "" {
-		return nil, nil // Invalid bundle config - no name found
-	}
-
 	return &config, nil
 }
 
+// LoadGroupConfigs loads the group.yaml files between bundleDir and its lake
+// root, outermost first: the order they apply in. bundleDir's own directory
+// and the lake root hold bundle.yaml and lake.yaml, so neither is searched.
+// The walk never leaves repoRoot, so a bundle at the lake root, or a tree
+// without lake.yaml, does not pick up group.yaml files from parent
+// directories outside the repository.
+func LoadGroupConfigs(repoRoot, bundleDir string) ([]*GroupConfig, error) {
+	var groups []*GroupConfig
+	if _, err := os.Stat(filepath.Join(bundleDir, lakeYamlFile)); err == nil {
+		return nil, nil
+	}
+	repoRoot, dir := filepath.Clean(repoRoot), filepath.Clean(bundleDir)
+	if rel, err := filepath.Rel(repoRoot, dir); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, nil
+	}
+	for dir != repoRoot {
+		dir = filepath.Dir(dir)
+		if _, err := os.Stat(filepath.Join(dir, lakeYamlFile)); err == nil {
+			break
+		}
+		groupFile := filepath.Join(dir, groupYamlFile)
+		if data, err := os.ReadFile(groupFile); err == nil {
+			var config GroupConfig
+			if err := decodeConfig(groupFile, data, &config); err != nil {
+				return nil, err
+			}
+			log.Printf("[protolake-gazelle] Found group.yaml at: %s", groupFile)
+			groups = append([]*GroupConfig{&config}, groups...)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
 // MergeConfigurations merges lake defaults with bundle-specific configurations
 // Bundle config takes precedence over lake defaults, including explicit disabling
 func MergeConfigurations(lakeConfig *LakeConfig, bundleConfig *BundleConfig) *MergedConfig {
+	return MergeConfigurationLayers(lakeConfig, nil, bundleConfig)
+}
+
+// MergeConfigurationLayers merges lake defaults, then each group.yaml in
+// groups (outermost first, see LoadGroupConfigs), then the bundle's own
+// config. Each layer overrides only what it sets.
+func MergeConfigurationLayers(lakeConfig *LakeConfig, groups []*GroupConfig, bundleConfig *BundleConfig) *MergedConfig {
 	merged := &MergedConfig{
 		BundleName:            bundleConfig.Name,
 		BundleOwner:           "", // Not used in new format
 		ProtoPackage:          "", // Not used in new format
+		DisplayName:           bundleConfig.DisplayName,
 		Description:           bundleConfig.Description,
 		Version:               bundleConfig.Version,
 		GenerateDescriptorSet: bundleConfig.Config.GenerateDescriptorSet,
+		BreakingCheck:         bundleConfig.Config.BreakingCheck,
+		BundleDependencies:    BundleDependenciesCompile,
+		VersionSource:         VersionSourceLiteral,
+		ExternalProtos:        defaultExternalProtos,
 		JavaConfig:            JavaConfig{},
 		PythonConfig:          PythonConfig{},
 		JavaScriptConfig:      JavaScriptConfig{},
+		GoConfig:              GoConfig{},
+		BufConfig:             BufConfig{},
 	}
 
 	// Start with lake defaults
 	if lakeConfig != nil {
+		if lakeConfig.Config.BundleDependencies != "" {
+			merged.BundleDependencies = lakeConfig.Config.BundleDependencies
+		}
+		if lakeConfig.Config.VersionSource != "" {
+			merged.VersionSource = lakeConfig.Config.VersionSource
+		}
+		merged.ExternalProtos = mergeExternalProtos(defaultExternalProtos, lakeConfig.Config.ExternalProtos)
+		merged.Signing = lakeConfig.Config.Signing
+		merged.Versioning = lakeConfig.Config.Versioning
+		merged.applyMetadata(&lakeConfig.Metadata)
 		merged.JavaConfig = JavaConfig{
-			Enabled:    lakeConfig.Config.LanguageDefaults.Java.Enabled,
-			GroupId:    lakeConfig.Config.LanguageDefaults.Java.GroupId,
-			ArtifactId: "", // Will be set from bundle
-			FatJar:     lakeConfig.Config.LanguageDefaults.Java.FatJar,
+			Enabled:       lakeConfig.Config.LanguageDefaults.Java.Enabled,
+			GroupId:       lakeConfig.Config.LanguageDefaults.Java.GroupId,
+			ArtifactId:    lakeConfig.Config.LanguageDefaults.Java.ArtifactId, // a template, usually
+			FatJar:        lakeConfig.Config.LanguageDefaults.Java.FatJar,
+			SourceVersion: lakeConfig.Config.LanguageDefaults.Java.SourceVersion,
+			TargetVersion: lakeConfig.Config.LanguageDefaults.Java.TargetVersion,
+			// The runtime versions are the lake's: every bundle is built
+			// against the same MODULE.bazel.
+			ProtobufVersion: lakeConfig.Config.LanguageDefaults.Java.ProtobufVersion,
+			GrpcVersion:     lakeConfig.Config.LanguageDefaults.Java.GrpcVersion,
 		}
 		merged.PythonConfig = PythonConfig{
-			Enabled:     lakeConfig.Config.LanguageDefaults.Python.Enabled,
-			PackageName: lakeConfig.Config.LanguageDefaults.Python.PackageName,
+			Enabled:         lakeConfig.Config.LanguageDefaults.Python.Enabled,
+			PackageName:     lakeConfig.Config.LanguageDefaults.Python.PackageName,
+			PythonVersion:   lakeConfig.Config.LanguageDefaults.Python.Version,
+			ProtobufVersion: lakeConfig.Config.LanguageDefaults.Python.ProtobufVersion,
+			GrpcVersion:     lakeConfig.Config.LanguageDefaults.Python.GrpcVersion,
 		}
 		merged.JavaScriptConfig = JavaScriptConfig{
-			Enabled:     lakeConfig.Config.LanguageDefaults.Javascript.Enabled,
-			PackageName: lakeConfig.Config.LanguageDefaults.Javascript.PackageName,
-			ProtoLoader: lakeConfig.Config.LanguageDefaults.Javascript.ProtoLoader,
+			Enabled:         lakeConfig.Config.LanguageDefaults.Javascript.Enabled,
+			PackageName:     lakeConfig.Config.LanguageDefaults.Javascript.PackageName,
+			ProtoLoader:     lakeConfig.Config.LanguageDefaults.Javascript.ProtoLoader,
+			ProtobufVersion: lakeConfig.Config.LanguageDefaults.Javascript.ProtobufVersion,
+			GrpcVersion:     lakeConfig.Config.LanguageDefaults.Javascript.GrpcVersion,
+		}
+		merged.GoConfig = GoConfig{
+			Enabled:          lakeConfig.Config.LanguageDefaults.Go.Enabled,
+			ModulePath:       lakeConfig.Config.LanguageDefaults.Go.ModulePath,
+			ImportPathPrefix: lakeConfig.Config.LanguageDefaults.Go.ImportPathPrefix,
+		}
+		merged.BufConfig = BufConfig{
+			Enabled:       lakeConfig.Config.LanguageDefaults.Buf.Enabled,
+			Module:        lakeConfig.Config.LanguageDefaults.Buf.Module,
+			Deps:          lakeConfig.Config.LanguageDefaults.Buf.Deps,
+			LocalRegistry: lakeConfig.Config.LanguageDefaults.Buf.LocalRegistry,
 		}
 
 		// Log lake defaults for debugging
@@ -170,41 +364,50 @@ func MergeConfigurations(lakeConfig *LakeConfig, bundleConfig *BundleConfig) *Me
 		log.Printf("Warning: No lake configuration found")
 	}
 
-	// Override with bundle-specific config - now properly handles explicit enabling/disabling
-	// Java configuration
-	if bundleConfig.Config.Languages.Java.Enabled != nil {
-		// Explicitly set in bundle config (either true or false)
-		merged.JavaConfig.Enabled = *bundleConfig.Config.Languages.Java.Enabled
+	// Override with group and bundle config, outermost first - explicit
+	// enabling/disabling included
+	for _, group := range groups {
+		if group.Config.BundleDependencies != "" {
+			merged.BundleDependencies = group.Config.BundleDependencies
+		}
+		if group.Config.DependencyMode != "" {
+			merged.DependencyMode = group.Config.DependencyMode
+		}
+		if group.Config.VersionSource != "" {
+			merged.VersionSource = group.Config.VersionSource
+		}
+		merged.applyLanguageOverrides(&group.Config.Languages)
+		merged.applyMetadata(&group.Metadata)
 	}
-	if bundleConfig.Config.Languages.Java.GroupId != "" {
-		merged.JavaConfig.GroupId = bundleConfig.Config.Languages.Java.GroupId
+	if bundleConfig.Config.BundleDependencies != "" {
+		merged.BundleDependencies = bundleConfig.Config.BundleDependencies
 	}
-	if bundleConfig.Config.Languages.Java.ArtifactId != "" {
-		merged.JavaConfig.ArtifactId = bundleConfig.Config.Languages.Java.ArtifactId
+	if bundleConfig.Config.DependencyMode != "" {
+		merged.DependencyMode = bundleConfig.Config.DependencyMode
 	}
-	if bundleConfig.Config.Languages.Java.FatJar != nil {
-		merged.JavaConfig.FatJar = *bundleConfig.Config.Languages.Java.FatJar
+	if bundleConfig.Config.VersionSource != "" {
+		merged.VersionSource = bundleConfig.Config.VersionSource
 	}
+	merged.applyLanguageOverrides(&bundleConfig.Config.Languages)
+	merged.applyMetadata(&bundleConfig.Metadata)
 
-	// Python configuration
-	if bundleConfig.Config.Languages.Python.Enabled != nil {
-		// Explicitly set in bundle config (either true or false)
-		merged.PythonConfig.Enabled = *bundleConfig.Config.Languages.Python.Enabled
+	// javac can't target a release below the source level, and -target alone
+	// defaults the source to the JDK's own version; a lone setting applies to
+	// both.
+	if merged.JavaConfig.TargetVersion == "" {
+		merged.JavaConfig.TargetVersion = merged.JavaConfig.SourceVersion
 	}
-	if bundleConfig.Config.Languages.Python.PackageName != "" {
-		merged.PythonConfig.PackageName = bundleConfig.Config.Languages.Python.PackageName
+	if merged.JavaConfig.SourceVersion == "" {
+		merged.JavaConfig.SourceVersion = merged.JavaConfig.TargetVersion
 	}
 
-	// JavaScript configuration
-	if bundleConfig.Config.Languages.Javascript.Enabled != nil {
-		// Explicitly set in bundle config (either true or false)
-		merged.JavaScriptConfig.Enabled = *bundleConfig.Config.Languages.Javascript.Enabled
-	}
-	if bundleConfig.Config.Languages.Javascript.PackageName != "" {
-		merged.JavaScriptConfig.PackageName = bundleConfig.Config.Languages.Javascript.PackageName
-	}
-	if bundleConfig.Config.Languages.Javascript.ProtoLoader != nil {
-		merged.JavaScriptConfig.ProtoLoader = *bundleConfig.Config.Languages.Javascript.ProtoLoader
+	// Coordinates left to lake.yaml templates are derived from the bundle name.
+	expandCoordinates(merged, lakeConfig)
+
+	// The import path prefix defaults to the module root: a bundle whose
+	// generated packages sit directly under the module needs only module_path.
+	if merged.GoConfig.ImportPathPrefix == "" {
+		merged.GoConfig.ImportPathPrefix = merged.GoConfig.ModulePath
 	}
 
 	// Log final merged configuration for debugging
@@ -214,21 +417,144 @@ func MergeConfigurations(lakeConfig *LakeConfig, bundleConfig *BundleConfig) *Me
 		merged.BundleName, merged.PythonConfig.Enabled, merged.PythonConfig.PackageName)
 	log.Printf("Merged config for bundle %s - JavaScript enabled: %v, PackageName: %s",
 		merged.BundleName, merged.JavaScriptConfig.Enabled, merged.JavaScriptConfig.PackageName)
+	log.Printf("Merged config for bundle %s - Go enabled: %v, ModulePath: %s",
+		merged.BundleName, merged.GoConfig.Enabled, merged.GoConfig.ModulePath)
+	log.Printf("Merged config for bundle %s - Buf enabled: %v, Module: %s",
+		merged.BundleName, merged.BufConfig.Enabled, merged.BufConfig.Module)
 
 	return merged
 }
 
+// applyLanguageOverrides applies one group.yaml or bundle.yaml languages
+// section to m: fields the layer sets win, unset ones inherit.
+func (m *MergedConfig) applyLanguageOverrides(o *LanguageOverrides) {
+	// Java configuration
+	if o.Java.Enabled != nil {
+		// Explicitly set in this layer (either true or false)
+		m.JavaConfig.Enabled = *o.Java.Enabled
+	}
+	if o.Java.GroupId != "" {
+		m.JavaConfig.GroupId = o.Java.GroupId
+	}
+	if o.Java.ArtifactId != "" {
+		m.JavaConfig.ArtifactId = o.Java.ArtifactId
+	}
+	if o.Java.FatJar != nil {
+		m.JavaConfig.FatJar = *o.Java.FatJar
+	}
+	if o.Java.SourceVersion != "" {
+		m.JavaConfig.SourceVersion = o.Java.SourceVersion
+	}
+	if o.Java.TargetVersion != "" {
+		m.JavaConfig.TargetVersion = o.Java.TargetVersion
+	}
+
+	// Python configuration
+	if o.Python.Enabled != nil {
+		// Explicitly set in this layer (either true or false)
+		m.PythonConfig.Enabled = *o.Python.Enabled
+	}
+	if o.Python.PackageName != "" {
+		m.PythonConfig.PackageName = o.Python.PackageName
+	}
+	if o.Python.PythonVersion != "" {
+		m.PythonConfig.PythonVersion = o.Python.PythonVersion
+	}
+
+	// JavaScript configuration
+	if o.Javascript.Enabled != nil {
+		// Explicitly set in this layer (either true or false)
+		m.JavaScriptConfig.Enabled = *o.Javascript.Enabled
+	}
+	if o.Javascript.PackageName != "" {
+		m.JavaScriptConfig.PackageName = o.Javascript.PackageName
+	}
+	if o.Javascript.ProtoLoader != nil {
+		m.JavaScriptConfig.ProtoLoader = *o.Javascript.ProtoLoader
+	}
+
+	// Go configuration
+	if o.Go.Enabled != nil {
+		// Explicitly set in this layer (either true or false)
+		m.GoConfig.Enabled = *o.Go.Enabled
+	}
+	if o.Go.ModulePath != "" {
+		m.GoConfig.ModulePath = o.Go.ModulePath
+	}
+	if o.Go.ImportPathPrefix != "" {
+		m.GoConfig.ImportPathPrefix = o.Go.ImportPathPrefix
+	}
+
+	// Buf configuration
+	if o.Buf.Enabled != nil {
+		// Explicitly set in this layer (either true or false)
+		m.BufConfig.Enabled = *o.Buf.Enabled
+	}
+	if o.Buf.Module != "" {
+		m.BufConfig.Module = o.Buf.Module
+	}
+	if len(o.Buf.Deps) > 0 {
+		m.BufConfig.Deps = o.Buf.Deps
+	}
+	if o.Buf.LocalRegistry != "" {
+		m.BufConfig.LocalRegistry = o.Buf.LocalRegistry
+	}
+}
+
+// Values of MergedConfig.BundleDependencies.
+const (
+	BundleDependenciesCompile  = "compile"
+	BundleDependenciesArtifact = "artifact"
+)
+
+// Values of MergedConfig.DependencyMode.
+const (
+	DependencyModeDirect     = "direct"
+	DependencyModeTransitive = "transitive"
+)
+
 // MergedConfig represents the final configuration after merging lake and bundle configs
 type MergedConfig struct {
 	BundleName            string
 	BundleOwner           string
 	ProtoPackage          string
+	DisplayName           string
 	Description           string
 	Version               string
 	GenerateDescriptorSet bool
-	JavaConfig            JavaConfig
-	PythonConfig          PythonConfig
-	JavaScriptConfig      JavaScriptConfig
+	// BreakingCheck is bundle.yaml's breaking_check section, nil without one.
+	BreakingCheck *BreakingCheckConfig
+	// BundleDependencies selects how protos imported from another bundle are
+	// handled: "compile" (default) compiles them into this bundle's artifacts;
+	// "artifact" leaves them out and declares the other bundle's published
+	// artifact as a dependency instead (POM <dependency>, wheel requirement,
+	// package.json dependency), so consumers don't get the same classes or
+	// modules from two artifacts.
+	BundleDependencies string
+	// DependencyMode selects which imported protos a bundle compiles in:
+	// "direct" only those its own protos import, "transitive" everything they
+	// import in turn. Unset, imports are followed through protos no bundle
+	// owns and stop at another bundle's protos.
+	DependencyMode string
+	// VersionSource is where the version comes from: "literal" (the default)
+	// is Version; "git_tag" and "stamp" are read at build time, and Version
+	// is empty (see VersionSourceLiteral).
+	VersionSource string
+	// ExternalProtos is the lake's external import provider table: the
+	// built-in defaults overlaid with lake.yaml's external_protos.
+	ExternalProtos   []ExternalProtoProvider
+	JavaConfig       JavaConfig
+	PythonConfig     PythonConfig
+	JavaScriptConfig JavaScriptConfig
+	GoConfig         GoConfig
+	BufConfig        BufConfig
+	// Signing is lake.yaml's signing section; bundles can't override it.
+	Signing SigningConfig
+	// Versioning is lake.yaml's versioning section, which neither can they.
+	Versioning VersioningConfig
+	// Metadata is the publish metadata passed to the pom genrule and the
+	// pypi and npm publishers (see metadataFlags).
+	Metadata Metadata
 }
 
 type JavaConfig struct {
@@ -236,15 +562,56 @@ type JavaConfig struct {
 	GroupId    string
 	ArtifactId string
 	FatJar     bool
+	// SourceVersion and TargetVersion are the Java language and bytecode
+	// levels ("11", "1.8"); both empty leaves them to the toolchain.
+	SourceVersion string
+	TargetVersion string
+	// ProtobufVersion and GrpcVersion are the lake's pinned runtime
+	// versions; empty leaves them to the pom genrule's environment.
+	ProtobufVersion string
+	GrpcVersion     string
 }
 
 type PythonConfig struct {
 	Enabled     bool
 	PackageName string
+	// PythonVersion is the wheel's Requires-Python specifier (">=3.9"); a
+	// bare version means that version or later.
+	PythonVersion string
+	// ProtobufVersion and GrpcVersion are the lake's pinned protobuf and
+	// grpcio versions; empty declares no requirement.
+	ProtobufVersion string
+	GrpcVersion     string
 }
 
 type JavaScriptConfig struct {
 	Enabled     bool
 	PackageName string
 	ProtoLoader bool
+	// ProtobufVersion and GrpcVersion are the lake's pinned
+	// @bufbuild/protobuf and @connectrpc/connect versions.
+	ProtobufVersion string
+	GrpcVersion     string
+}
+
+type GoConfig struct {
+	Enabled          bool
+	ModulePath       string
+	ImportPathPrefix string
+}
+
+// BufConfig is a bundle's Buf Schema Registry module. Unlike the other
+// languages nothing is compiled: the bundle's protos are pushed as source,
+// with a generated buf.yaml.
+type BufConfig struct {
+	Enabled bool
+	// Module is the BSR module name, "buf.build/<owner>/<repository>".
+	Module string
+	// Deps are the BSR modules the bundle's protos import from, written to
+	// buf.yaml's deps.
+	Deps []string
+	// LocalRegistry, when set, is a directory (relative to the workspace
+	// root) the publisher copies the module into instead of pushing it: a
+	// stand-in registry for tests.
+	LocalRegistry string
 }
@@ -0,0 +1,466 @@
+package language
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// Languages a compile rule builds, as named in bundle.yaml.
+const (
+	langJava       = "java"
+	langPython     = "python"
+	langJavaScript = "javascript"
+	langGo         = "go"
+)
+
+// compileKindLanguages maps the rules whose `protos` compile the bundle — and
+// so also need the protos the bundle imports from elsewhere — to the language
+// whose artifact they build.
+var compileKindLanguages = map[string]string{
+	"java_grpc_library":   langJava,
+	"python_grpc_library": langPython,
+	"es_proto_compile":    langJavaScript,
+}
+
+// resolvedKinds are the generated kinds resolveBundleRule updates: the
+// compile rules plus the pom genrules and publisher py_binaries.
+var resolvedKinds = map[string]bool{
+	"java_grpc_library":   true,
+	"python_grpc_library": true,
+	"es_proto_compile":    true,
+	"genrule":             true,
+	"py_binary":           true,
+}
+
+// bundleImports is the Resolve payload GenerateRules attaches to the rules of
+// a bundle that resolveBundleRule updates: the proto imports of the bundle's
+// sources and the merged config they resolve under. A bundle's rules share
+// one value, so its imports are resolved once however many rules consume
+// them.
+type bundleImports struct {
+	config  *MergedConfig
+	imports []string
+
+	resolved *resolvedImports
+	err      error
+}
+
+// resolvedImports is a bundle's imports resolved to proto_library targets.
+type resolvedImports struct {
+	// protos are compiled into the bundle in every language: the bundle's
+	// own protos, protos no bundle owns, and in compile mode everything.
+	protos []label.Label
+
+	// bundles holds, in artifact mode, the other bundles the imports resolve
+	// into, by bundle package, with the targets imported from each. Whether
+	// those targets are compiled in or declared as an artifact dependency is
+	// decided per language (see forLanguage).
+	bundles       map[string]*MergedConfig
+	bundleTargets map[string][]label.Label
+
+	// dependsOn holds, in either mode, every other bundle the imports reach:
+	// the bundles a lake release publishes first (see publishOrder).
+	dependsOn map[string]bool
+}
+
+// Import walk states (see resolveBundleImports).
+const (
+	importUnvisited = iota
+	importVisiting
+	importVisited
+)
+
+// resolveBundleImports resolves bi's imports on first use and partitions them
+// by owning bundle. Imports are followed depth-first through the files they
+// name, as far as the bundle's dependency mode allows (see followImports): a
+// codegen rule only emits code for the protos it lists, so a proto two hops
+// away that nobody lists leaves a dangling import in the generated code.
+//
+// The error reports imports the bundle can't depend on as resolved: an
+// artifact dependency on a bundle without a literal version, or a nested
+// bundle's protos compiled into a bundle publishing them too. Those imports
+// still count in dependsOn, so publishOrder can order the bundle regardless.
+func (pe *protolakeExtension) resolveBundleImports(c *config.Config, ix *resolve.RuleIndex, bi *bundleImports, from label.Label) (*resolvedImports, error) {
+	if bi.resolved != nil {
+		return bi.resolved, bi.err
+	}
+	res := &resolvedImports{
+		bundles:       make(map[string]*MergedConfig),
+		bundleTargets: make(map[string][]label.Label),
+		dependsOn:     make(map[string]bool),
+	}
+	artifactMode := bi.config.BundleDependencies == BundleDependenciesArtifact
+	state := make(map[string]int)
+	// nested holds the targets of bundles below this one compiled into it,
+	// by owning bundle (see publishedTwice).
+	nested := make(map[string][]label.Label)
+	var errs []string
+
+	var visit func(imp string, stack []string)
+	visit = func(imp string, stack []string) {
+		switch state[imp] {
+		case importVisiting:
+			// protoc rejects cycles; report it here rather than looping.
+			log.Printf("Warning: import cycle in dependencies of bundle %s: %s",
+				bi.config.BundleName, strings.Join(append(stack, imp), " -> "))
+			return
+		case importVisited:
+			return
+		}
+		state[imp] = importVisiting
+		defer func() { state[imp] = importVisited }()
+
+		l, ok := pe.resolveProtoImport(c, ix, imp)
+		if !ok {
+			log.Printf("Warning: Could not resolve import %s for %s", imp, from)
+			return
+		}
+		inRepo := l.Repo == "" || l.Repo == from.Repo
+		owner, owned := "", false
+		if inRepo {
+			owner, owned = pe.bundleOwner(c, l.Pkg)
+		}
+		if owned && owner == from.Pkg {
+			// The bundle's own protos: their imports are already in bi.imports.
+			res.protos = append(res.protos, l)
+			return
+		}
+		if owned {
+			res.dependsOn[owner] = true
+		}
+		if artifactMode && owned {
+			cfg := pe.bundleConfig(c, owner)
+			switch {
+			case cfg == nil || cfg.Version == "" && !versionFromBuild(cfg):
+				errs = append(errs, fmt.Sprintf("bundle %q imports %s from the bundle at %s, whose configuration "+
+					"could not be loaded, so it can't be declared as an artifact dependency. Fix that bundle's bundle.yaml.",
+					bi.config.BundleName, imp, owner))
+				return
+			case versionFromBuild(cfg):
+				// A dependency is declared at the version gazelle sees, and
+				// the other bundle's is only known once built. Compiling it
+				// in instead would publish a second copy of its classes.
+				errs = append(errs, fmt.Sprintf("bundle %q imports %s from bundle %q at %s, which takes its "+
+					"version from %s at build time, so it can't be declared as an artifact dependency. "+
+					"Give %q a literal version, or set bundle_dependencies: compile in %q.",
+					bi.config.BundleName, imp, cfg.BundleName, owner, versionStatusKey(cfg), cfg.BundleName, bi.config.BundleName))
+				return
+			}
+			// The other bundle's artifact carries its own closure.
+			res.bundles[owner] = cfg
+			res.bundleTargets[owner] = append(res.bundleTargets[owner], l)
+			return
+		}
+		res.protos = append(res.protos, l)
+		if owned && isSubpackage(owner, from.Pkg) {
+			nested[owner] = append(nested[owner], l)
+		}
+
+		if !inRepo || !followImports(bi.config.DependencyMode, owned) {
+			return
+		}
+		if file := pe.protoFileFor(c, imp); file != "" {
+			for _, next := range protoFileImports(filepath.Join(c.RepoRoot, file), bi.config.ExternalProtos) {
+				visit(next, append(stack, imp))
+			}
+		}
+	}
+	for _, imp := range bi.imports {
+		visit(imp, nil)
+	}
+	if err := pe.publishedTwice(c, bi.config, nested); err != nil {
+		errs = append(errs, err.Error())
+	}
+	bi.resolved = res
+	if len(errs) > 0 {
+		bi.err = errors.New(strings.Join(errs, "\n"))
+	}
+	return res, bi.err
+}
+
+// isSubpackage reports whether pkg lies below parent, the root package "".
+func isSubpackage(pkg, parent string) bool {
+	return pkg != parent && (parent == "" || strings.HasPrefix(pkg, parent+"/"))
+}
+
+// publishedTwice returns an error when config's bundle would compile the
+// protos of a bundle nested below it into an artifact of a language the
+// nested bundle publishes too: consumers of both would get the same classes,
+// modules or packages twice, under two coordinates. nested holds the targets
+// compiled in, by owning bundle. Go never compiles them in (see
+// goModuleFor), and an owner not publishing a language leaves its protos
+// for the importers to compile.
+func (pe *protolakeExtension) publishedTwice(c *config.Config, config *MergedConfig, nested map[string][]label.Label) error {
+	var conflicts []string
+	for _, owner := range sortedKeys(nested) {
+		cfg := pe.bundleConfig(c, owner)
+		if cfg == nil {
+			continue
+		}
+		var langs []string
+		for _, lang := range []string{langJava, langPython, langJavaScript} {
+			if languageEnabled(config, lang) && languageEnabled(cfg, lang) {
+				langs = append(langs, lang)
+			}
+		}
+		if len(langs) == 0 {
+			continue
+		}
+		seen := make(map[label.Label]bool)
+		for _, l := range nested[owner] {
+			if !seen[l] {
+				seen[l] = true
+				conflicts = append(conflicts, fmt.Sprintf("  %s, published by bundle %q at //%s for %s",
+					absoluteLabel(l), cfg.BundleName, owner, strings.Join(langs, ", ")))
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("bundle %q compiles in protos a bundle nested below it already publishes in the same language, "+
+			"so they would be published twice:\n%s\nSet bundle_dependencies: artifact in %q to depend on those bundles' artifacts instead.",
+			config.BundleName, strings.Join(conflicts, "\n"), config.BundleName)
+	}
+	return nil
+}
+
+// followImports reports whether a bundle in dependency mode mode compiles in
+// the imports of a proto it compiles in from elsewhere; owned reports whether
+// another bundle owns that proto.
+func followImports(mode string, owned bool) bool {
+	switch mode {
+	case DependencyModeDirect:
+		return false
+	case DependencyModeTransitive:
+		return true
+	}
+	return !owned
+}
+
+// bundleDependency is another bundle a bundle depends on as a published
+// artifact.
+type bundleDependency struct {
+	pkg    string
+	config *MergedConfig
+}
+
+// forLanguage splits the resolved imports for one language: the targets to
+// compile in, and the other bundles to depend on as published artifacts. A
+// bundle that doesn't publish lang has no artifact to depend on, so its
+// targets are compiled in as in compile mode. Go lays out its packages at
+// generation time instead (see goModuleFor).
+func (res *resolvedImports) forLanguage(lang string) ([]label.Label, []bundleDependency) {
+	protos := append([]label.Label{}, res.protos...)
+	var deps []bundleDependency
+	for _, owner := range sortedKeys(res.bundles) {
+		cfg := res.bundles[owner]
+		if languageEnabled(cfg, lang) {
+			deps = append(deps, bundleDependency{pkg: owner, config: cfg})
+		} else {
+			protos = append(protos, res.bundleTargets[owner]...)
+		}
+	}
+	return protos, deps
+}
+
+func languageEnabled(cfg *MergedConfig, lang string) bool {
+	switch lang {
+	case langJava:
+		return cfg.JavaConfig.Enabled
+	case langPython:
+		return cfg.PythonConfig.Enabled
+	case langJavaScript:
+		return cfg.JavaScriptConfig.Enabled
+	case langGo:
+		return cfg.GoConfig.Enabled
+	}
+	return false
+}
+
+// mavenDependencies returns deps as `group:artifact:version` coordinates, the
+// version qualified with suffix (see pomCommand).
+func mavenDependencies(deps []bundleDependency, suffix string) []string {
+	coords := make([]string, 0, len(deps))
+	for _, d := range deps {
+		coords = append(coords, fmt.Sprintf("%s:%s:%s%s",
+			d.config.JavaConfig.GroupId, d.config.JavaConfig.ArtifactId, d.config.Version, suffix))
+	}
+	return coords
+}
+
+// bundleOwner returns the package of the bundle that owns pkg: the nearest
+// directory at or above it holding a bundle.yaml.
+func (pe *protolakeExtension) bundleOwner(c *config.Config, pkg string) (string, bool) {
+	for p := pkg; ; p = path.Dir(p) {
+		if p == "." {
+			p = ""
+		}
+		if _, ok := pe.bundleConfigs[p]; ok {
+			return p, true
+		}
+		if _, err := os.Stat(filepath.Join(c.RepoRoot, p, bundleYamlFile)); err == nil {
+			return p, true
+		}
+		if p == "" {
+			return "", false
+		}
+	}
+}
+
+// bundleConfig returns the merged configuration of the bundle at pkg: the one
+// GenerateRules recorded this pass, otherwise loaded from disk (a bundle
+// outside a partial run). Returns nil when it can't be loaded.
+func (pe *protolakeExtension) bundleConfig(c *config.Config, pkg string) *MergedConfig {
+	if cfg, ok := pe.bundleConfigs[pkg]; ok {
+		return cfg
+	}
+	dir := filepath.Join(c.RepoRoot, pkg)
+	var cfg *MergedConfig
+	lakeConfig, err := LoadLakeConfig(dir)
+	if err != nil {
+		log.Printf("Failed to load lake configuration for %s: %v", dir, err)
+	}
+	bundleConfig, err := LoadBundleConfig(dir)
+	if err != nil {
+		log.Printf("Failed to load bundle configuration for %s: %v", dir, err)
+	}
+	groupConfigs, err := LoadGroupConfigs(c.RepoRoot, dir)
+	if err != nil {
+		log.Printf("Failed to load group configuration for %s: %v", dir, err)
+	}
+	if lakeConfig != nil && bundleConfig != nil {
+		cfg = MergeConfigurationLayers(lakeConfig, groupConfigs, bundleConfig)
+	}
+	pe.bundleConfigs[pkg] = cfg
+	return cfg
+}
+
+// resolveBundleRule applies bi's resolved imports to r, one of the bundle's
+// generated rules. Compile rules get the targets they compile in; in artifact
+// mode the rules that describe a published artifact (the pom genrules, the
+// pypi and npm publishers) get the other bundles' coordinates, and the Java
+// compile rule their compiled classes.
+func (pe *protolakeExtension) resolveBundleRule(c *config.Config, ix *resolve.RuleIndex, r *rule.Rule, bi *bundleImports, from label.Label) {
+	res, err := pe.resolveBundleImports(c, ix, bi, from)
+	if err != nil {
+		log.Fatalf("[protolake-gazelle] %v", err)
+	}
+	name := bi.config.BundleName
+	pypiPublisher := fmt.Sprintf("publish_%s_to_pypi", name)
+	npmPublisher := fmt.Sprintf("publish_%s_to_npm", name)
+
+	if lang, ok := compileKindLanguages[r.Kind()]; ok {
+		protos, deps := res.forLanguage(lang)
+		appendLabels(r, "protos", protos, from)
+		if lang == langJava && len(deps) > 0 {
+			var javaDeps []label.Label
+			for _, d := range deps {
+				javaDeps = append(javaDeps, label.New("", d.pkg, d.config.BundleName+"_java_grpc"))
+			}
+			appendLabels(r, "deps", javaDeps, from)
+		}
+		return
+	}
+
+	switch {
+	case r.Kind() == "genrule" && r.Name() == name+"_pom":
+		if _, deps := res.forLanguage(langJava); len(deps) > 0 {
+			coords := mavenDependencies(deps, "")
+			log.Printf("Declared %d bundle dependencies for %s: %v", len(coords), from, coords)
+			r.SetAttr("cmd", pomCommand(bi.config, "", coords))
+		}
+	case r.Kind() == "genrule" && r.Name() == name+"_pom_local":
+		// The local twin depends on the other bundles' local installs.
+		if _, deps := res.forLanguage(langJava); len(deps) > 0 {
+			r.SetAttr("cmd", pomCommand(bi.config, publishLocal, mavenDependencies(deps, localVersionSuffix)))
+		}
+	case r.Kind() == "genrule" && r.Name() == name+"_pom_snapshot":
+		// A snapshot depends on the other bundles' releases: which of their
+		// snapshots exist is only known when CI publishes.
+		if _, deps := res.forLanguage(langJava); len(deps) > 0 {
+			r.SetAttr("cmd", pomCommand(bi.config, publishSnapshot, mavenDependencies(deps, "")))
+		}
+	case r.Kind() == "py_binary" && isPublisher(r.Name(), pypiPublisher):
+		// The local twin requires the other bundles' local installs; the
+		// snapshot twin, like the snapshot POM, their releases.
+		suffix := ""
+		if r.Name() == pypiPublisher+"_"+publishLocal {
+			suffix = pypiLocalVersionSuffix
+		}
+		_, deps := res.forLanguage(langPython)
+		var args []string
+		for _, d := range deps {
+			args = append(args, fmt.Sprintf("--requirement=%s==%s%s", d.config.PythonConfig.PackageName, d.config.Version, suffix))
+		}
+		appendArgs(r, args, from)
+	case r.Kind() == "py_binary" && isPublisher(r.Name(), npmPublisher):
+		suffix := ""
+		if r.Name() == npmPublisher+"_"+publishLocal {
+			suffix = localVersionSuffix
+		}
+		_, deps := res.forLanguage(langJavaScript)
+		var args []string
+		for _, d := range deps {
+			args = append(args, fmt.Sprintf("--dependency=%s@%s%s", d.config.JavaScriptConfig.PackageName, d.config.Version, suffix))
+		}
+		appendArgs(r, args, from)
+	}
+}
+
+// isPublisher reports whether name is publisher or one of its local and
+// snapshot twins.
+func isPublisher(name, publisher string) bool {
+	return name == publisher || name == publisher+"_"+publishLocal || name == publisher+"_"+publishSnapshot
+}
+
+// appendLabels appends labels to r's string-list attr, formatted relative to
+// from and skipping those already present.
+func appendLabels(r *rule.Rule, attr string, labels []label.Label, from label.Label) {
+	existing := r.AttrStrings(attr)
+	seen := make(map[string]bool, len(existing))
+	for _, e := range existing {
+		seen[e] = true
+	}
+	var added []string
+	for _, l := range labels {
+		dep := relativeLabel(l, from)
+		if !seen[dep] {
+			seen[dep] = true
+			added = append(added, dep)
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+	sort.Strings(added)
+	log.Printf("Resolved %d cross-bundle %s for %s: %v", len(added), attr, from, added)
+	r.SetAttr(attr, rule.PlatformStrings{Generic: append(existing, added...)})
+}
+
+// appendArgs appends publisher args declaring artifact dependencies.
+func appendArgs(r *rule.Rule, args []string, from label.Label) {
+	if len(args) == 0 {
+		return
+	}
+	log.Printf("Declared %d bundle dependencies for %s: %v", len(args), from, args)
+	r.SetAttr("args", append(r.AttrStrings("args"), args...))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
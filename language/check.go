@@ -0,0 +1,298 @@
+package language
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/buildtools/tables"
+
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// checkFlag turns on check mode: instead of rewriting stale bundle BUILD
+// files, gazelle fails with a per-bundle diff of what regeneration would
+// change. A bundle.yaml edit that wasn't followed by a gazelle run is then
+// caught in CI, not at publish time by the pom genrule's --expected-version.
+const checkFlag = "protolake_check"
+
+// bundleCheck is what check mode needs to diff one bundle's BUILD file: the
+// rules it held before this pass, and the names of the rules this extension
+// generated or emptied in it. The lake root's publish_all targets are
+// checked the same way, with no bundle name.
+type bundleCheck struct {
+	bundle string
+	rel    string
+	file   *rule.File // merged in place by gazelle; nil when there was none
+	before map[string]ruleSnapshot
+	names  []string
+}
+
+// ruleSnapshot is a rule's kind and attrs as written in the BUILD file,
+// captured before gazelle merges the generated rules into it. The merge edits
+// the existing expressions in place, so the attrs are kept as values.
+type ruleSnapshot struct {
+	kind  string
+	attrs map[string]attrValue
+}
+
+func snapshotRule(r *rule.Rule) ruleSnapshot {
+	s := ruleSnapshot{kind: r.Kind(), attrs: make(map[string]attrValue)}
+	for _, key := range r.AttrKeys() {
+		if key != "name" {
+			s.attrs[key] = newAttrValue(r.Attr(key))
+		}
+	}
+	return s
+}
+
+// attrValue is an attr's value rendered on one line, plus its elements when
+// it is a list of string literals.
+type attrValue struct {
+	text   string
+	list   []string
+	isList bool
+}
+
+func newAttrValue(e bzl.Expr) attrValue {
+	v := attrValue{text: formatExpr(e)}
+	v.list, v.isList = stringList(e)
+	return v
+}
+
+// recordBundleCheck snapshots the bundle's BUILD file for check mode before
+// gazelle merges gen and empty into it.
+func (pe *protolakeExtension) recordBundleCheck(args language.GenerateArgs, bundle string, gen, empty []*rule.Rule) {
+	bc := &bundleCheck{
+		bundle: bundle,
+		rel:    args.Rel,
+		file:   args.File,
+		before: make(map[string]ruleSnapshot),
+	}
+	if args.File != nil {
+		for _, r := range args.File.Rules {
+			bc.before[r.Name()] = snapshotRule(r)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, rules := range [][]*rule.Rule{gen, empty} {
+		for _, r := range rules {
+			if name := r.Name(); name != "" && !seen[name] {
+				seen[name] = true
+				bc.names = append(bc.names, name)
+			}
+		}
+	}
+	pe.checks = append(pe.checks, bc)
+}
+
+// staleBundles returns the check mode report: for each bundle whose BUILD
+// file regeneration would change, a header line followed by one indented line
+// per changed rule or attr. It must run once gazelle has merged and resolved
+// every rule, when each bundle's file holds what gazelle is about to write.
+func (pe *protolakeExtension) staleBundles() []string {
+	var report []string
+	for _, bc := range pe.checks {
+		var diffs []string
+		if bc.file == nil {
+			diffs = append(diffs, "BUILD file would be created")
+		} else {
+			after := make(map[string]*rule.Rule)
+			for _, r := range bc.file.Rules {
+				after[r.Name()] = r
+			}
+			for _, name := range bc.names {
+				diffs = append(diffs, diffRule(name, bc.before, after)...)
+			}
+		}
+		if len(diffs) == 0 {
+			continue
+		}
+		path := filepath.ToSlash(filepath.Join(bc.rel, buildBazelFile))
+		if bc.file != nil && bc.file.Path != "" {
+			path = filepath.ToSlash(filepath.Join(bc.rel, filepath.Base(bc.file.Path)))
+		}
+		header := fmt.Sprintf("bundle %s (%s):", bc.bundle, path)
+		if bc.bundle == "" {
+			header = fmt.Sprintf("lake publish targets (%s):", path)
+		}
+		report = append(report, header)
+		for _, d := range diffs {
+			report = append(report, "  "+d)
+		}
+	}
+	return report
+}
+
+// diffRule describes how rule name changes between before and after.
+func diffRule(name string, before map[string]ruleSnapshot, after map[string]*rule.Rule) []string {
+	old, existed := before[name]
+	r, exists := after[name]
+	switch {
+	case !existed && !exists:
+		return nil
+	case !existed:
+		return []string{fmt.Sprintf("%s: missing, would be added", name)}
+	case !exists:
+		return []string{fmt.Sprintf("%s: stale, would be deleted", name)}
+	}
+
+	var diffs []string
+	if old.kind != r.Kind() {
+		diffs = append(diffs, fmt.Sprintf("%s: kind %s -> %s", name, old.kind, r.Kind()))
+	}
+	keys := make(map[string]bool)
+	for key := range old.attrs {
+		keys[key] = true
+	}
+	for _, key := range r.AttrKeys() {
+		if key != "name" {
+			keys[key] = true
+		}
+	}
+	for _, key := range sortedSet(keys) {
+		var oldValue, newValue *attrValue
+		if v, ok := old.attrs[key]; ok {
+			oldValue = &v
+		}
+		if e := r.Attr(key); e != nil {
+			v := newAttrValue(e)
+			newValue = &v
+		}
+		if d := diffAttr(r.Kind(), key, oldValue, newValue); d != "" {
+			diffs = append(diffs, fmt.Sprintf("%s: %s: %s", name, key, d))
+		}
+	}
+	return diffs
+}
+
+// diffAttr describes the change of attr key from old to new, "" if none.
+// String lists are compared by element — in any order when buildifier sorts
+// the attr on write, so a merge that only reorders isn't reported.
+func diffAttr(kind, key string, old, new *attrValue) string {
+	switch {
+	case old == nil && new == nil:
+		return ""
+	case old == nil:
+		return "added " + new.text
+	case new == nil:
+		return "removed (was " + old.text + ")"
+	case !old.isList || !new.isList:
+		if old.text == new.text {
+			return ""
+		}
+		return old.text + " -> " + new.text
+	}
+
+	oldList, newList := old.list, new.list
+	sortable := tables.IsSortableListArg[key] && !tables.SortableDenylist[kind+"."+key]
+	if sortable {
+		oldList = sortedCopy(oldList)
+		newList = sortedCopy(newList)
+	}
+	if slices.Equal(oldList, newList) {
+		return ""
+	}
+	var changes []string
+	inOld, inNew := counts(oldList), counts(newList)
+	for _, s := range oldList {
+		if inNew[s] == 0 {
+			changes = append(changes, "-"+s)
+		}
+	}
+	for _, s := range newList {
+		if inOld[s] == 0 {
+			changes = append(changes, "+"+s)
+		}
+	}
+	if len(changes) == 0 {
+		return "reordered"
+	}
+	return strings.Join(changes, ", ")
+}
+
+// stringList returns the values of e when it is a list of string literals.
+func stringList(e bzl.Expr) ([]string, bool) {
+	list, ok := e.(*bzl.ListExpr)
+	if !ok {
+		return nil, false
+	}
+	values := make([]string, 0, len(list.List))
+	for _, item := range list.List {
+		s, ok := item.(*bzl.StringExpr)
+		if !ok {
+			return nil, false
+		}
+		values = append(values, s.Value)
+	}
+	return values, true
+}
+
+func sortedCopy(list []string) []string {
+	out := slices.Clone(list)
+	sort.Strings(out)
+	return out
+}
+
+func sortedSet(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func counts(list []string) map[string]int {
+	m := make(map[string]int)
+	for _, s := range list {
+		m[s]++
+	}
+	return m
+}
+
+// lineBreaks matches the line breaks, indentation and trailing commas of
+// buildifier's multi-line expressions; unwrap tidies up after replacing them.
+var (
+	lineBreaks = regexp.MustCompile(`,?\s*\n\s*`)
+	unwrap     = strings.NewReplacer("[, ", "[", ", ]", "]", "{, ", "{", ", }", "}", "(, ", "(", ", )", ")")
+)
+
+// formatExpr renders e on one line for the report.
+func formatExpr(e bzl.Expr) string {
+	return unwrap.Replace(lineBreaks.ReplaceAllString(bzl.FormatString(e), ", "))
+}
+
+// Before is part of language.LifecycleManager; check mode needs no setup.
+func (pe *protolakeExtension) Before(ctx context.Context) {}
+
+// DoneGeneratingRules is part of language.LifecycleManager.
+func (pe *protolakeExtension) DoneGeneratingRules() {}
+
+// AfterResolvingDeps writes the graph export (see graphFlag) and fails a
+// check mode run whose bundle BUILD files are out of date. Gazelle calls it
+// once every rule is merged and resolved but before any file is written, so
+// a failing check leaves the tree untouched.
+func (pe *protolakeExtension) AfterResolvingDeps(ctx context.Context) {
+	if pe.graph != "" {
+		if err := pe.writeGraph(pe.graph); err != nil {
+			log.Fatalf("[protolake-gazelle] failed to write the bundle graph: %v", err)
+		}
+	}
+	if !pe.check {
+		return
+	}
+	report := pe.staleBundles()
+	if len(report) > 0 {
+		log.Fatalf("[protolake-gazelle] BUILD files are out of date; run gazelle to regenerate them:\n  %s",
+			strings.Join(report, "\n  "))
+	}
+	log.Printf("[protolake-gazelle] %d bundle BUILD files are up to date", len(pe.checks))
+}
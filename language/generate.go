@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
@@ -21,8 +23,10 @@ var importPattern = regexp.MustCompile(`import\s+"([^"]+)"`)
 // `--bundle-yaml` on the pom genrules and py_binary publishers). The one
 // intentional analysis-time version literal is the maven_publish
 // `coordinates` string, guarded by `--expected-version` on the pom genrules
-// — see generateJavaBundleRules.
-func generateBundleRules(config *MergedConfig, protoTargets []string, rel string, c *config.Config) []*rule.Rule {
+// — see generateJavaBundleRules. A bundle whose version_source isn't literal
+// has no version at gazelle time: everything reads the <bundle>_version file
+// instead, and Java publishes through a py_binary (see mavenPublishRule).
+func generateBundleRules(config *MergedConfig, protoTargets []string, rel string, c *config.Config, goMod *goModule) []*rule.Rule {
 	var rules []*rule.Rule
 	bundleName := config.BundleName
 
@@ -33,11 +37,33 @@ func generateBundleRules(config *MergedConfig, protoTargets []string, rel string
 	// version literal is only baked into maven_publish coordinates (everything
 	// else resolves from bundle.yaml at build time), but this check stays as
 	// gazelle-time validation of bundle.yaml.
-	if config.Version == "" {
-		log.Fatalf("[protolake-gazelle] bundle %q at %s is missing required field "+
-			"`version` in bundle.yaml. Set an explicit version (e.g. `version: \"1.0.0\"`); "+
-			"omitting it would publish under a fallback that drifts silently.",
-			bundleName, rel)
+	//
+	// With a git_tag or stamp version_source the check turns around: a
+	// `version` left in bundle.yaml would be ignored, and someone bumping it
+	// would wonder why nothing changed.
+	if versionFromBuild(config) {
+		if config.Version != "" {
+			log.Fatalf("[protolake-gazelle] bundle %q at %s sets `version` in bundle.yaml, but its "+
+				"version_source is %s, which takes the version from %s at build time. Remove "+
+				"`version`, or set `version_source: literal`.",
+				bundleName, rel, config.VersionSource, versionStatusKey(config))
+		}
+		log.Printf("Bundle %s version comes from %s at build time", bundleName, versionStatusKey(config))
+	} else {
+		if config.Version == "" {
+			log.Fatalf("[protolake-gazelle] bundle %q at %s is missing required field "+
+				"`version` in bundle.yaml. Set an explicit version (e.g. `version: \"1.0.0\"`); "+
+				"omitting it would publish under a fallback that drifts silently.",
+				bundleName, rel)
+		}
+		// The version must also be one every enabled publisher accepts, within
+		// the lake's versioning policy: maven_publish would bake a "1.0" into its
+		// coordinates, and PyPI or npm only reject it at publish time.
+		if err := versionError(config); err != nil {
+			log.Fatalf("[protolake-gazelle] bundle %q at %s has an unpublishable `version` in bundle.yaml: %v",
+				bundleName, rel, err)
+		}
+		log.Printf("Bundle %s version %s publishes as: %s", bundleName, config.Version, publishedVersions(config))
 	}
 
 	// Create aggregated proto_library rule (for reference and compatibility)
@@ -46,18 +72,20 @@ func generateBundleRules(config *MergedConfig, protoTargets []string, rel string
 	allProtosRule.SetAttr("visibility", []string{"//visibility:public"})
 	rules = append(rules, allProtosRule)
 
-	// Collect bundle-specific transitive dependencies for the gRPC libraries
-	bundleDir := filepath.Join(c.RepoRoot, rel)
-	allProtoTargets := collectBundleTransitiveDependencies(c, bundleDir, protoTargets)
+	if versionFromBuild(config) {
+		rules = append(rules, generateVersionRule(config, bundleName))
+	}
 
-	log.Printf("Bundle %s has %d direct proto targets and %d total with transitive deps",
-		bundleName, len(protoTargets), len(allProtoTargets))
+	// The gRPC/compile rules start from the bundle's own proto targets;
+	// cross-bundle imports are appended at resolve time (see
+	// protolakeExtension.Resolve).
+	bundleDir := filepath.Join(c.RepoRoot, rel)
 
 	// Detect external proto imports and derive per-language Bazel targets. Java
 	// depends on a pre-compiled umbrella library (googleapis-java); Python and JS
 	// have no such umbrella today, so they compile the external proto_library
 	// targets directly alongside the bundle's own protos.
-	externalDeps := detectExternalProtoImports(bundleDir)
+	externalDeps := detectExternalProtoImports(bundleDir, config.ExternalProtos)
 
 	// Generate Java bundle if enabled
 	log.Printf("Checking Java bundle generation - Enabled: %v, GroupId: '%s', ArtifactId: '%s'",
@@ -66,7 +94,8 @@ func generateBundleRules(config *MergedConfig, protoTargets []string, rel string
 		requireCoordinates(bundleName, rel, "java",
 			[2]string{"group_id", config.JavaConfig.GroupId},
 			[2]string{"artifact_id", config.JavaConfig.ArtifactId})
-		rules = append(rules, generateJavaBundleRules(config, bundleName, allProtoTargets, externalDeps.Java)...)
+		requireJavaVersions(bundleName, rel, config.JavaConfig)
+		rules = append(rules, generateJavaBundleRules(config, bundleName, protoTargets, externalDeps.Java)...)
 	} else {
 		log.Printf("Skipping Java bundle generation for %s (disabled)", bundleName)
 	}
@@ -77,7 +106,7 @@ func generateBundleRules(config *MergedConfig, protoTargets []string, rel string
 	if config.PythonConfig.Enabled {
 		requireCoordinates(bundleName, rel, "python",
 			[2]string{"package_name", config.PythonConfig.PackageName})
-		rules = append(rules, generatePythonBundleRules(config, bundleName, allProtoTargets, externalDeps.ProtoLibraries)...)
+		rules = append(rules, generatePythonBundleRules(config, bundleName, protoTargets, externalDeps.ProtoLibraries)...)
 	} else {
 		log.Printf("Skipping Python bundle generation for %s (disabled)", bundleName)
 	}
@@ -88,20 +117,46 @@ func generateBundleRules(config *MergedConfig, protoTargets []string, rel string
 	if config.JavaScriptConfig.Enabled {
 		requireCoordinates(bundleName, rel, "javascript",
 			[2]string{"package_name", config.JavaScriptConfig.PackageName})
-		rules = append(rules, generateJavaScriptBundleRules(config, bundleName, allProtoTargets, externalDeps.ProtoLibraries)...)
+		rules = append(rules, generateJavaScriptBundleRules(config, bundleName, protoTargets, externalDeps.ProtoLibraries)...)
 	} else {
 		log.Printf("Skipping JavaScript bundle generation for %s (disabled)", bundleName)
 	}
 
-	// Generate descriptor set if enabled
-	if config.GenerateDescriptorSet {
+	// Generate Go bundle if enabled
+	log.Printf("Checking Go bundle generation - Enabled: %v, ModulePath: '%s'",
+		config.GoConfig.Enabled, config.GoConfig.ModulePath)
+	if config.GoConfig.Enabled {
+		requireCoordinates(bundleName, rel, "go",
+			[2]string{"module_path", config.GoConfig.ModulePath})
+		rules = append(rules, generateGoBundleRules(config, bundleName, goMod)...)
+	} else {
+		log.Printf("Skipping Go bundle generation for %s (disabled)", bundleName)
+	}
+
+	// Generate Buf Schema Registry module if enabled
+	log.Printf("Checking Buf bundle generation - Enabled: %v, Module: '%s'",
+		config.BufConfig.Enabled, config.BufConfig.Module)
+	if config.BufConfig.Enabled {
+		requireCoordinates(bundleName, rel, "buf",
+			[2]string{"module", config.BufConfig.Module})
+		rules = append(rules, generateBufBundleRules(config, bundleName)...)
+	} else {
+		log.Printf("Skipping Buf bundle generation for %s (disabled)", bundleName)
+	}
+
+	// Generate descriptor set if enabled; the breaking check compares one
+	// against its baseline, so it needs one too
+	if config.GenerateDescriptorSet || config.BreakingCheck != nil {
 		rules = append(rules, generateDescriptorSetRules(config, bundleName, protoTargets)...)
 	}
+	if config.BreakingCheck != nil {
+		rules = append(rules, generateBreakingCheckRule(config, bundleName))
+	}
 
 	// Generate proto-loader bundle if enabled (requireCoordinates above
 	// guarantees a non-empty package name whenever JS is enabled)
 	if config.JavaScriptConfig.Enabled && config.JavaScriptConfig.ProtoLoader {
-		rules = append(rules, generateProtoLoaderBundleRules(config, bundleName, allProtoTargets)...)
+		rules = append(rules, generateProtoLoaderBundleRules(config, bundleName, protoTargets)...)
 	}
 
 	// Create a build test to verify all bundles
@@ -115,6 +170,12 @@ func generateBundleRules(config *MergedConfig, protoTargets []string, rel string
 	if config.JavaScriptConfig.Enabled {
 		testTargets = append(testTargets, fmt.Sprintf(":%s_js_bundle", bundleName))
 	}
+	if config.GoConfig.Enabled {
+		testTargets = append(testTargets, fmt.Sprintf(":%s_go_bundle", bundleName))
+	}
+	if config.BufConfig.Enabled {
+		testTargets = append(testTargets, fmt.Sprintf(":%s_buf_bundle", bundleName))
+	}
 
 	if len(testTargets) > 0 {
 		buildTestRule := rule.NewRule("build_validation", "all")
@@ -125,14 +186,52 @@ func generateBundleRules(config *MergedConfig, protoTargets []string, rel string
 	return rules
 }
 
+// generateVersionRule creates the <bundle>_version genrule of a bundle whose
+// version comes from the build (see versionFromBuild). `stamp = 1` gives it
+// bazel-out/stable-status.txt; it writes the bare version to
+// <bundle>.version, which the pom genrules, bundle rules and publishers read
+// in place of bundle.yaml.
+func generateVersionRule(config *MergedConfig, bundleName string) *rule.Rule {
+	r := rule.NewRule("genrule", fmt.Sprintf("%s_version", bundleName))
+	r.SetAttr("outs", rule.PlatformStrings{Generic: []string{fmt.Sprintf("%s.version", bundleName)}})
+	r.SetAttr("cmd", versionCommand(config))
+	r.SetAttr("stamp", 1)
+	r.SetAttr("visibility", []string{"//visibility:public"})
+	return r
+}
+
+// setBundleVersion points bundle rule r at where its bundler reads the
+// version: bundle.yaml through `bundle_yaml`, or the <bundle>_version file
+// through `version_file`.
+func setBundleVersion(r *rule.Rule, config *MergedConfig) {
+	if versionFromBuild(config) {
+		r.SetAttr("version_file", fmt.Sprintf(":%s_version", config.BundleName))
+	} else {
+		r.SetAttr("bundle_yaml", ":bundle.yaml")
+	}
+}
+
+// versionInput returns the `data` entry and flag a publisher or test reads
+// config's version through: bundle.yaml and `--bundle-yaml`, or the
+// <bundle>_version file and `--version-file`.
+func versionInput(config *MergedConfig) (src, flag string) {
+	if versionFromBuild(config) {
+		src = fmt.Sprintf(":%s_version", config.BundleName)
+		return src, fmt.Sprintf("--version-file=$(location %s)", src)
+	}
+	return "bundle.yaml", "--bundle-yaml=$(location bundle.yaml)"
+}
+
 // requireCoordinates fail-fasts when a language is enabled but a publish
 // coordinate (group_id/artifact_id/package_name) is empty in the merged
-// lake.yaml+bundle.yaml config. Generation needs the coordinates while the
-// disabled-language cleanup keys on Enabled alone, so an enabled-but-
-// coordinate-less language would get neither generation nor cleanup — stale
-// old-form rules survive and fail the bazel loading phase with a confusing
-// error. Mirrors the missing-version fatal in generateBundleRules. Each field
-// is a {name, value} pair; order determines the message order.
+// lake.yaml+bundle.yaml config — neither set explicitly nor derived from a
+// lake.yaml template (see expandCoordinates). Generation needs the
+// coordinates while the disabled-language cleanup keys on Enabled alone, so
+// an enabled-but-coordinate-less language would get neither generation nor
+// cleanup — stale old-form rules survive and fail the bazel loading phase
+// with a confusing error. Mirrors the missing-version fatal in
+// generateBundleRules. Each field is a {name, value} pair; order determines
+// the message order.
 func requireCoordinates(bundleName, rel, language string, fields ...[2]string) {
 	var missing []string
 	for _, f := range fields {
@@ -146,11 +245,75 @@ func requireCoordinates(bundleName, rel, language string, fields ...[2]string) {
 	log.Fatalf("[protolake-gazelle] bundle %q at %s enables %s but the merged "+
 		"lake.yaml/bundle.yaml config leaves %s empty. An enabled language without "+
 		"coordinates gets neither generated rules nor cleanup, leaving stale rules "+
-		"to break the bazel loading phase. Set the field(s) or disable the language "+
-		"explicitly (`enabled: false`).",
+		"to break the bazel loading phase. Set the field(s) in bundle.yaml, derive them "+
+		"from a lake.yaml template (artifact_id, package_name, package_prefix or "+
+		"package_scope), or disable the language explicitly (`enabled: false`).",
 		bundleName, rel, language, strings.Join(missing, ", "))
 }
 
+// requireJavaVersions fail-fasts on a Java source/target version javac would
+// reject: not a release number, or a target below the source level. Caught
+// here, it names the bundle; caught by javac, it fails every Java build in
+// the lake with an opaque toolchain error.
+func requireJavaVersions(bundleName, rel string, java JavaConfig) {
+	source, target := javaRelease(java.SourceVersion), javaRelease(java.TargetVersion)
+	for _, v := range [][2]string{{"source_version", java.SourceVersion}, {"target_version", java.TargetVersion}} {
+		if v[1] != "" && javaRelease(v[1]) == "" {
+			log.Fatalf("[protolake-gazelle] bundle %q at %s sets java %s to %q, which is "+
+				"not a Java release (e.g. \"11\", \"17\", \"1.8\").", bundleName, rel, v[0], v[1])
+		}
+	}
+	if source != "" && target != "" {
+		s, _ := strconv.Atoi(source)
+		t, _ := strconv.Atoi(target)
+		if t < s {
+			log.Fatalf("[protolake-gazelle] bundle %q at %s sets java target_version %s below "+
+				"source_version %s; javac cannot compile Java %s source to an older bytecode level.",
+				bundleName, rel, java.TargetVersion, java.SourceVersion, java.SourceVersion)
+		}
+	}
+}
+
+// javaReleasePattern matches a Java version as lake.yaml may spell it: a
+// feature release ("11"), or a pre-9 release in its 1.x form ("1.8").
+var javaReleasePattern = regexp.MustCompile(`^(?:1\.)?([0-9]+)$`)
+
+// javaRelease returns the feature release number of Java version v ("1.8" ->
+// "8"), the form javac's --release and Maven's maven.compiler.release take,
+// or "" when v isn't a Java version.
+func javaRelease(v string) string {
+	if m := javaReleasePattern.FindStringSubmatch(v); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// javacOpts returns the javacopts pinning config's Java source and target
+// levels: `--release N` when they agree, which also checks the code against
+// release N's API, and `-source`/`-target` otherwise. nil leaves the levels
+// to the Java toolchain.
+func javacOpts(java JavaConfig) []string {
+	source, target := javaRelease(java.SourceVersion), javaRelease(java.TargetVersion)
+	switch {
+	case source == "":
+		return nil
+	case source == target:
+		return []string{"--release", source}
+	}
+	return []string{"-source", source, "-target", target}
+}
+
+// requiresPython returns the wheel Requires-Python specifier for a
+// python_version setting: a bare version ("3.9") means that version or
+// later, anything else is already a specifier (">=3.8,<4").
+func requiresPython(v string) string {
+	v = strings.TrimSpace(v)
+	if v != "" && v[0] >= '0' && v[0] <= '9' {
+		return ">=" + v
+	}
+	return v
+}
+
 // generateJavaBundleRules creates Java bundle rules with maven_publish from rules_jvm_external.
 //
 // The publish target is no longer a genrule wrapping a python publisher — it's a
@@ -159,22 +322,28 @@ func requireCoordinates(bundleName, rel, language string, fields ...[2]string) {
 // BUILD time: the bundle rule reads it via the `bundle_yaml` attr and the pom
 // genrule via `--bundle-yaml`. The maven_publish `coordinates` string is the one
 // intentional analysis-time version literal — see the comment on that rule.
-func generateJavaBundleRules(config *MergedConfig, bundleName string, allProtoTargets []string, externalJavaDeps []string) []*rule.Rule {
+func generateJavaBundleRules(config *MergedConfig, bundleName string, protoTargets []string, externalJavaDeps []string) []*rule.Rule {
 	var rules []*rule.Rule
 
 	// Java gRPC library (includes both proto messages and gRPC stubs)
 	javaGrpcRule := rule.NewRule("java_grpc_library", fmt.Sprintf("%s_java_grpc", bundleName))
-	javaGrpcRule.SetAttr("protos", rule.PlatformStrings{Generic: allProtoTargets})
+	javaGrpcRule.SetAttr("protos", rule.PlatformStrings{Generic: protoTargets})
 	if len(externalJavaDeps) > 0 {
 		javaGrpcRule.SetAttr("deps", externalJavaDeps)
 		log.Printf("Added %d external Java deps to %s_java_grpc: %v", len(externalJavaDeps), bundleName, externalJavaDeps)
 	}
+	// The bundle JAR ships the classes compiled here, so this is where the
+	// lake's source/target versions pin the bytecode level.
+	if opts := javacOpts(config.JavaConfig); opts != nil {
+		javaGrpcRule.SetAttr("javacopts", opts)
+	}
 	javaGrpcRule.SetAttr("visibility", []string{"//visibility:public"})
 	rules = append(rules, javaGrpcRule)
 
 	// Version literal from bundle.yaml — used ONLY for the maven_publish
 	// coordinates below. generateBundleRules already fail-fasts if
-	// config.Version is empty, so no fallback needed here.
+	// config.Version is empty for a literal version_source, and the other
+	// sources publish through mavenPublishRule, so no fallback needed here.
 	version := config.Version
 
 	// Java bundle rule. Coordinates come from configuration; the JAR's
@@ -188,7 +357,7 @@ func generateJavaBundleRules(config *MergedConfig, bundleName string, allProtoTa
 	javaBundleRule.SetAttr("java_grpc_deps", rule.PlatformStrings{Generic: []string{fmt.Sprintf(":%s_java_grpc", bundleName)}})
 	javaBundleRule.SetAttr("group_id", config.JavaConfig.GroupId)
 	javaBundleRule.SetAttr("artifact_id", config.JavaConfig.ArtifactId)
-	javaBundleRule.SetAttr("bundle_yaml", ":bundle.yaml")
+	setBundleVersion(javaBundleRule, config)
 	if config.JavaConfig.FatJar {
 		javaBundleRule.SetAttr("fat_jar", true)
 	}
@@ -201,6 +370,29 @@ func generateJavaBundleRules(config *MergedConfig, bundleName string, allProtoTa
 	javaBundleRule.SetAttr("visibility", []string{"//visibility:public"})
 	rules = append(rules, javaBundleRule)
 
+	// Maven Central rejects a release without -sources and -javadoc JARs next
+	// to the main one. The sources JAR carries the Java generated from the
+	// bundle's protos, read from the source JAR java_library builds next to
+	// the compiled one (lib<name>-src.jar) so each file keeps its package
+	// path; javadoc runs over the same generated code. Both ride along as
+	// classifier_artifacts on the maven_publish rules below.
+	javaSourcesRule := rule.NewRule("java_source_bundle", fmt.Sprintf("%s_java_sources", bundleName))
+	javaSourcesRule.SetAttr("srcjars", []string{fmt.Sprintf(":lib%s_java_grpc-src.jar", bundleName)})
+	javaSourcesRule.SetAttr("visibility", []string{"//visibility:public"})
+	rules = append(rules, javaSourcesRule)
+
+	// protoc's generated code isn't written for doclint; a warning on it would
+	// fail the javadoc JAR and with it the release.
+	javadocRule := rule.NewRule("javadoc", fmt.Sprintf("%s_javadoc", bundleName))
+	javadocRule.SetAttr("deps", []string{fmt.Sprintf(":%s_java_grpc", bundleName)})
+	javadocRule.SetAttr("javadocopts", []string{"-Xdoclint:none"})
+	javadocRule.SetAttr("visibility", []string{"//visibility:public"})
+	rules = append(rules, javadocRule)
+	classifierArtifacts := map[string]string{
+		fmt.Sprintf(":%s_java_sources", bundleName): "sources",
+		fmt.Sprintf(":%s_javadoc", bundleName):      "javadoc",
+	}
+
 	// POM generator genrule — pom_generator writes POM XML to its --out path. No
 	// upload happens here; that's maven_publish's job. The version is read from
 	// bundle.yaml at build time (`--bundle-yaml`), so a version bump takes effect
@@ -208,21 +400,13 @@ func generateJavaBundleRules(config *MergedConfig, bundleName string, allProtoTa
 	// gazelle run saw (the same literal as the maven_publish coordinates below):
 	// if bundle.yaml is edited without a gazelle pass, pom_generator fails
 	// instead of uploading an artifact whose GAV coordinate disagrees with its
-	// POM. Space-separated on purpose — versions can't start with '-'.
+	// POM. Space-separated on purpose — versions can't start with '-'. With a
+	// version from the build there is no literal to guard; see pomCommand.
+	versionSrc, _ := versionInput(config)
 	pomRule := rule.NewRule("genrule", fmt.Sprintf("%s_pom", bundleName))
-	pomRule.SetAttr("srcs", rule.PlatformStrings{Generic: []string{"bundle.yaml"}})
+	pomRule.SetAttr("srcs", rule.PlatformStrings{Generic: []string{versionSrc}})
 	pomRule.SetAttr("outs", rule.PlatformStrings{Generic: []string{fmt.Sprintf("%s.pom.xml", bundleName)}})
-	pomCmd := fmt.Sprintf(
-		"$(location //tools:pom_generator) "+
-			"--group-id %s "+
-			"--artifact-id %s "+
-			"--bundle-yaml $(location bundle.yaml) "+
-			"--expected-version %s "+
-			"--protobuf-version $${PROTOBUF_JAVA_VERSION:-4.33.5} "+
-			"--grpc-version $${GRPC_VERSION:-1.78.0} "+
-			"--out $@",
-		config.JavaConfig.GroupId, config.JavaConfig.ArtifactId, version)
-	pomRule.SetAttr("cmd", pomCmd)
+	pomRule.SetAttr("cmd", pomCommand(config, "", nil))
 	pomRule.SetAttr("tools", rule.PlatformStrings{Generic: []string{"//tools:pom_generator"}})
 	pomRule.SetAttr("visibility", []string{"//visibility:public"})
 	rules = append(rules, pomRule)
@@ -241,13 +425,24 @@ func generateJavaBundleRules(config *MergedConfig, bundleName string, allProtoTa
 	// There is no runtime/stamping placeholder in 6.10. Gazelle runs before
 	// every protolake build, and `coordinates` is mergeable, so the literal
 	// stays in sync with bundle.yaml.
-	publishMavenRule := rule.NewRule("maven_publish", fmt.Sprintf("publish_%s_to_maven", bundleName))
-	publishMavenRule.SetAttr("coordinates",
-		fmt.Sprintf("%s:%s:%s", config.JavaConfig.GroupId, config.JavaConfig.ArtifactId, version))
-	publishMavenRule.SetAttr("pom", fmt.Sprintf(":%s_pom", bundleName))
-	publishMavenRule.SetAttr("artifact", fmt.Sprintf(":%s_java_bundle", bundleName))
-	publishMavenRule.SetAttr("visibility", []string{"//visibility:public"})
-	rules = append(rules, publishMavenRule)
+	if versionFromBuild(config) {
+		rules = append(rules, mavenPublishRule(config, bundleName, publishRelease))
+	} else {
+		publishMavenRule := rule.NewRule("maven_publish", fmt.Sprintf("publish_%s_to_maven", bundleName))
+		publishMavenRule.SetAttr("coordinates",
+			fmt.Sprintf("%s:%s:%s", config.JavaConfig.GroupId, config.JavaConfig.ArtifactId, version))
+		publishMavenRule.SetAttr("pom", fmt.Sprintf(":%s_pom", bundleName))
+		publishMavenRule.SetAttr("artifact", fmt.Sprintf(":%s_java_bundle", bundleName))
+		publishMavenRule.SetAttr("classifier_artifacts", classifierArtifacts)
+		// maven_publish signs every uploaded file when its gpg_sign make variable
+		// is "true"; the toolchain named by lake.yaml's signing section supplies
+		// it (see signingToolchain).
+		if toolchain := signingToolchain(config.Signing); toolchain != "" {
+			publishMavenRule.SetAttr("toolchains", []string{toolchain})
+		}
+		publishMavenRule.SetAttr("visibility", []string{"//visibility:public"})
+		rules = append(rules, publishMavenRule)
+	}
 
 	// Convenience alias for publishing
 	publishMavenAlias := rule.NewRule("alias", "publish_to_maven")
@@ -262,43 +457,223 @@ func generateJavaBundleRules(config *MergedConfig, bundleName string, allProtoTa
 	// under test pin the qualifier explicitly. The orchestrator runs this
 	// target instead of the plain one when MAVEN_REPO is not an http(s)
 	// registry (protolake BazelBuildRunner).
-	localVersion := version + "-local"
-	pomLocalRule := rule.NewRule("genrule", fmt.Sprintf("%s_pom_local", bundleName))
-	pomLocalRule.SetAttr("srcs", rule.PlatformStrings{Generic: []string{"bundle.yaml"}})
-	pomLocalRule.SetAttr("outs", rule.PlatformStrings{Generic: []string{fmt.Sprintf("%s.pom_local.xml", bundleName)}})
-	// `--version-suffix=-local` (equals form — argparse rejects a space-separated
-	// value starting with `-`) appends the qualifier to the version pom_generator
-	// reads from bundle.yaml, keeping the POM's <version> aligned with the
-	// -local maven_publish coordinates below. `--expected-version` carries the
-	// RAW bundle.yaml version (no -local suffix): pom_generator runs the
-	// stale-BUILD check on the pre-suffix version, then applies the suffix.
-	pomLocalCmd := fmt.Sprintf(
-		"$(location //tools:pom_generator) "+
-			"--group-id %s "+
-			"--artifact-id %s "+
-			"--bundle-yaml $(location bundle.yaml) "+
-			"--expected-version %s "+
-			"--version-suffix=-local "+
-			"--protobuf-version $${PROTOBUF_JAVA_VERSION:-4.33.5} "+
-			"--grpc-version $${GRPC_VERSION:-1.78.0} "+
-			"--out $@",
-		config.JavaConfig.GroupId, config.JavaConfig.ArtifactId, version)
-	pomLocalRule.SetAttr("cmd", pomLocalCmd)
-	pomLocalRule.SetAttr("tools", rule.PlatformStrings{Generic: []string{"//tools:pom_generator"}})
-	pomLocalRule.SetAttr("visibility", []string{"//visibility:public"})
-	rules = append(rules, pomLocalRule)
-
-	publishMavenLocalRule := rule.NewRule("maven_publish", fmt.Sprintf("publish_%s_to_maven_local", bundleName))
-	publishMavenLocalRule.SetAttr("coordinates",
-		fmt.Sprintf("%s:%s:%s", config.JavaConfig.GroupId, config.JavaConfig.ArtifactId, localVersion))
-	publishMavenLocalRule.SetAttr("pom", fmt.Sprintf(":%s_pom_local", bundleName))
-	publishMavenLocalRule.SetAttr("artifact", fmt.Sprintf(":%s_java_bundle", bundleName))
-	publishMavenLocalRule.SetAttr("visibility", []string{"//visibility:public"})
-	rules = append(rules, publishMavenLocalRule)
+	rules = append(rules, javaPublishTwinRules(config, bundleName, publishLocal, classifierArtifacts)...)
+
+	// Snapshot twin at `<next version>-SNAPSHOT`, for CI to publish
+	// unreleased schema changes to a snapshot repository (MAVEN_REPO)
+	// between releases. It is a snapshot of the next version (see
+	// semver.nextVersion), since 1.2.0-SNAPSHOT sorts below 1.2.0 itself.
+	// The repository numbers each upload itself, so unlike the pypi and npm
+	// snapshot publishers this one needs no counter.
+	rules = append(rules, javaPublishTwinRules(config, bundleName, publishSnapshot, classifierArtifacts)...)
 
 	return rules
 }
 
+// Publish target variants: the release target, and the twins publishing the
+// same artifact at a qualified version, named
+// publish_<bundle>_to_<registry>_<variant>.
+const (
+	publishRelease  = ""
+	publishLocal    = "local"
+	publishSnapshot = "snapshot"
+)
+
+// mavenVersionSuffixes are the version qualifiers of the Maven twins.
+var mavenVersionSuffixes = map[string]string{
+	publishLocal:    localVersionSuffix,
+	publishSnapshot: "-" + snapshotPrerelease,
+}
+
+// javaPublishTwinRules returns the pom genrule and Maven publish target of
+// a twin (publishLocal or publishSnapshot) of the release pair: the same
+// artifacts at the version plus the twin's qualifier. Twins aren't signed:
+// neither reaches Central, and signing a local install would need the
+// release key on every developer machine.
+func javaPublishTwinRules(config *MergedConfig, bundleName, variant string, classifierArtifacts map[string]string) []*rule.Rule {
+	versionSrc, _ := versionInput(config)
+	pomRule := rule.NewRule("genrule", fmt.Sprintf("%s_pom_%s", bundleName, variant))
+	pomRule.SetAttr("srcs", rule.PlatformStrings{Generic: []string{versionSrc}})
+	pomRule.SetAttr("outs", rule.PlatformStrings{Generic: []string{fmt.Sprintf("%s.pom_%s.xml", bundleName, variant)}})
+	// See pomCommand for the suffix handling.
+	pomRule.SetAttr("cmd", pomCommand(config, variant, nil))
+	pomRule.SetAttr("tools", rule.PlatformStrings{Generic: []string{"//tools:pom_generator"}})
+	pomRule.SetAttr("visibility", []string{"//visibility:public"})
+
+	if versionFromBuild(config) {
+		return []*rule.Rule{pomRule, mavenPublishRule(config, bundleName, variant)}
+	}
+	publishRule := rule.NewRule("maven_publish", fmt.Sprintf("publish_%s_to_maven_%s", bundleName, variant))
+	publishRule.SetAttr("coordinates",
+		fmt.Sprintf("%s:%s:%s", config.JavaConfig.GroupId, config.JavaConfig.ArtifactId, twinVersion(config, variant)))
+	publishRule.SetAttr("pom", fmt.Sprintf(":%s_pom_%s", bundleName, variant))
+	publishRule.SetAttr("artifact", fmt.Sprintf(":%s_java_bundle", bundleName))
+	publishRule.SetAttr("classifier_artifacts", classifierArtifacts)
+	publishRule.SetAttr("visibility", []string{"//visibility:public"})
+	return []*rule.Rule{pomRule, publishRule}
+}
+
+// twinVersion returns the Maven version the twin variant of config's bundle
+// publishes at: the version with the local qualifier, or the next version
+// with the snapshot one.
+func twinVersion(config *MergedConfig, variant string) string {
+	version := config.Version
+	if variant == publishSnapshot {
+		version = nextVersion(config)
+	}
+	return version + mavenVersionSuffixes[variant]
+}
+
+// nextVersion returns the version config's snapshot twins lead up to (see
+// semver.nextVersion), for a literal version.
+func nextVersion(config *MergedConfig) string {
+	v, err := parseSemver(config.Version)
+	if err != nil {
+		return config.Version
+	}
+	return v.nextVersion(snapshotBase(config))
+}
+
+// snapshotBaseFlag passes a bundle whose version comes from the build the
+// snapshot_base its snapshot publishers apply to that version once read,
+// as semver.nextVersion does for a literal one.
+func snapshotBaseFlag(config *MergedConfig) string {
+	return "--snapshot-base=" + snapshotBase(config)
+}
+
+// mavenPublishRule returns the Maven publish py_binary of a bundle whose
+// version comes from the build. maven_publish can't publish it: its
+// coordinates are an analysis-time literal (see generateJavaBundleRules).
+// The publisher instead takes the coordinates from the POM, whose version
+// the pom genrule read from the <bundle>_version file, and uploads the JAR,
+// sources and javadoc JARs and POM to MAVEN_REPO like maven_publish does.
+// variant is publishRelease or a twin, which publishes its own POM and,
+// like the maven_publish twins, is never signed.
+func mavenPublishRule(config *MergedConfig, bundleName, variant string) *rule.Rule {
+	name := fmt.Sprintf("publish_%s_to_maven", bundleName)
+	pom := fmt.Sprintf(":%s_pom", bundleName)
+	if variant != publishRelease {
+		name += "_" + variant
+		pom += "_" + variant
+	}
+	jar := fmt.Sprintf(":%s_java_bundle", bundleName)
+	sources := fmt.Sprintf(":%s_java_sources", bundleName)
+	javadoc := fmt.Sprintf(":%s_javadoc", bundleName)
+	args := []string{
+		fmt.Sprintf("$(location %s)", jar),
+		fmt.Sprintf("--pom=$(location %s)", pom),
+		fmt.Sprintf("--classifier-artifact=sources=$(location %s)", sources),
+		fmt.Sprintf("--classifier-artifact=javadoc=$(location %s)", javadoc),
+	}
+	// What the signing toolchains turn on in maven_publish (see
+	// signingToolchain), as flags.
+	if variant == publishRelease && config.Signing.Enabled {
+		args = append(args, "--gpg-sign")
+		if config.Signing.InMemoryKeys {
+			args = append(args, "--in-memory-keys")
+		}
+	}
+	r := rule.NewRule("py_binary", name)
+	r.SetAttr("srcs", []string{"//tools:publish/maven_publisher_generated.py"})
+	r.SetAttr("main", "publish/maven_publisher_generated.py")
+	r.SetAttr("data", []string{jar, pom, sources, javadoc})
+	r.SetAttr("args", args)
+	r.SetAttr("deps", []string{"//tools:publisher_utils"})
+	r.SetAttr("visibility", []string{"//visibility:public"})
+	return r
+}
+
+// signingToolchain returns the label of the toolchain that turns on GPG
+// signing in maven_publish for signing, or "" when signing is off. Both
+// toolchains set the gpg_sign make variable; the in-memory one also sets
+// use_in_memory_pgp_keys, so the key and passphrase come from the
+// PGP_SIGNING_KEY and PGP_SIGNING_PWD env vars instead of a local keyring.
+func signingToolchain(signing SigningConfig) string {
+	switch {
+	case !signing.Enabled:
+		return ""
+	case signing.InMemoryKeys:
+		return "//tools:maven_gpg_signing_in_memory"
+	}
+	return "//tools:maven_gpg_signing"
+}
+
+// localVersionSuffix qualifies the version of local-publish twins (see
+// generateJavaBundleRules).
+const localVersionSuffix = "-local"
+
+// pomCommand builds the pom genrule cmd for config's bundle. variant is
+// publishRelease for the release POM, else the twin's (see
+// javaPublishTwinRules). deps are
+// `group:artifact:version` coordinates of other bundles this bundle declares
+// as dependencies instead of compiling their protos in (see
+// BundleDependenciesArtifact); Resolve rebuilds the cmd with them once imports
+// are resolved.
+//
+// `--version-suffix=-local` (equals form — argparse rejects a space-separated
+// value starting with `-`) appends the qualifier to the version pom_generator
+// reads from bundle.yaml, keeping the POM's <version> aligned with the -local
+// maven_publish coordinates. `--expected-version` carries the RAW bundle.yaml
+// version (no -local suffix): pom_generator runs the stale-BUILD check on the
+// pre-suffix version, then applies the suffix. The snapshot POM also gets
+// `--next-version`, the version (see twinVersion) pom_generator moves to
+// before appending -SNAPSHOT.
+//
+// A bundle whose version comes from the build gets `--version-file` instead
+// of both: nothing bakes the version, so there is nothing to go stale, and
+// mavenPublishRule publishes at whatever version the POM carries. Its
+// snapshot POM gets `--snapshot-base` in place of `--next-version`.
+func pomCommand(config *MergedConfig, variant string, deps []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "$(location //tools:pom_generator) "+
+		"--group-id %s "+
+		"--artifact-id %s ",
+		config.JavaConfig.GroupId, config.JavaConfig.ArtifactId)
+	if versionFromBuild(config) {
+		fmt.Fprintf(&b, "--version-file $(location :%s_version) ", config.BundleName)
+	} else {
+		fmt.Fprintf(&b, "--bundle-yaml $(location bundle.yaml) --expected-version %s ", config.Version)
+	}
+	if variant == publishSnapshot {
+		if versionFromBuild(config) {
+			fmt.Fprintf(&b, "%s ", snapshotBaseFlag(config))
+		} else {
+			fmt.Fprintf(&b, "--next-version=%s ", nextVersion(config))
+		}
+	}
+	if suffix := mavenVersionSuffixes[variant]; suffix != "" {
+		fmt.Fprintf(&b, "--version-suffix=%s ", suffix)
+	}
+	for _, dep := range deps {
+		fmt.Fprintf(&b, "--dependency %s ", dep)
+	}
+	// The POM records the bytecode level as maven.compiler.release (or
+	// maven.compiler.source/target when they differ), matching javacOpts.
+	if source, target := javaRelease(config.JavaConfig.SourceVersion), javaRelease(config.JavaConfig.TargetVersion); source != "" {
+		if source == target {
+			fmt.Fprintf(&b, "--java-release %s ", source)
+		} else {
+			fmt.Fprintf(&b, "--java-source %s --java-target %s ", source, target)
+		}
+	}
+	// Name, description, license, SCM and developers: what Maven Central
+	// requires of a POM besides its coordinates.
+	for _, flag := range metadataFlags(config, metadataPom) {
+		b.WriteString(flag + " ")
+	}
+	// The runtime versions the lake pins, so the POM declares what the JAR
+	// was compiled against; unpinned, the environment's or a fallback.
+	protobufVersion, grpcVersion := config.JavaConfig.ProtobufVersion, config.JavaConfig.GrpcVersion
+	if protobufVersion == "" {
+		protobufVersion = protobufJavaVersionFallback
+	}
+	if grpcVersion == "" {
+		grpcVersion = grpcJavaVersionFallback
+	}
+	fmt.Fprintf(&b, "--protobuf-version %s --grpc-version %s --out $@", protobufVersion, grpcVersion)
+	return b.String()
+}
+
 // generatePythonBundleRules creates Python bundle rules and a per-bundle py_binary
 // publish target. External proto_library targets (e.g.
 // @googleapis//google/api:annotations_proto) are compiled alongside the bundle's
@@ -306,7 +681,7 @@ func generateJavaBundleRules(config *MergedConfig, bundleName string, allProtoTa
 // is referenced via cross-package srcs; the package name is baked at gazelle
 // time, while the version resolves from bundle.yaml at build/run time
 // (bundle_yaml attr on the bundle rule, --bundle-yaml on the publisher).
-func generatePythonBundleRules(config *MergedConfig, bundleName string, allProtoTargets []string, externalProtoLibraries []string) []*rule.Rule {
+func generatePythonBundleRules(config *MergedConfig, bundleName string, protoTargets []string, externalProtoLibraries []string) []*rule.Rule {
 	var rules []*rule.Rule
 
 	// Python gRPC library (includes both proto messages and gRPC stubs).
@@ -315,7 +690,7 @@ func generatePythonBundleRules(config *MergedConfig, bundleName string, allProto
 	// the bundle's own protos — without this the published wheel would be missing
 	// google/api/*_pb2.py companions.
 	pythonGrpcRule := rule.NewRule("python_grpc_library", fmt.Sprintf("%s_python_grpc", bundleName))
-	protos := append([]string{}, allProtoTargets...)
+	protos := append([]string{}, protoTargets...)
 	protos = append(protos, externalProtoLibraries...)
 	pythonGrpcRule.SetAttr("protos", rule.PlatformStrings{Generic: protos})
 	pythonGrpcRule.SetAttr("visibility", []string{"//visibility:public"})
@@ -333,28 +708,22 @@ func generatePythonBundleRules(config *MergedConfig, bundleName string, allProto
 	pyBundleRule.SetAttr("py_deps", rule.PlatformStrings{Generic: []string{}})
 	pyBundleRule.SetAttr("py_grpc_deps", rule.PlatformStrings{Generic: []string{fmt.Sprintf(":%s_python_grpc", bundleName)}})
 	pyBundleRule.SetAttr("package_name", config.PythonConfig.PackageName)
-	pyBundleRule.SetAttr("bundle_yaml", ":bundle.yaml")
+	// Written to the wheel's METADATA as Requires-Python, so pip refuses the
+	// wheel on interpreters the generated code doesn't support.
+	if config.PythonConfig.PythonVersion != "" {
+		pyBundleRule.SetAttr("python_requires", requiresPython(config.PythonConfig.PythonVersion))
+	}
+	setBundleVersion(pyBundleRule, config)
 	pyBundleRule.SetAttr("visibility", []string{"//visibility:public"})
 	rules = append(rules, pyBundleRule)
 
 	// py_binary publish target. Invoked via `bazel run`; exit code propagates.
-	// bundle.yaml rides in `data` and is addressed via the same runfiles-relative
-	// $(location) mechanism as the bundle artifact arg.
-	publishPypiRule := rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_pypi", bundleName))
-	publishPypiRule.SetAttr("srcs", []string{"//tools:publish/pypi_publisher_generated.py"})
-	publishPypiRule.SetAttr("main", "publish/pypi_publisher_generated.py")
-	publishPypiRule.SetAttr("data", []string{
-		fmt.Sprintf(":%s_py_bundle", bundleName),
-		"bundle.yaml",
-	})
-	publishPypiRule.SetAttr("args", []string{
-		fmt.Sprintf("$(location :%s_py_bundle)", bundleName),
-		fmt.Sprintf("--package-name=%s", config.PythonConfig.PackageName),
-		"--bundle-yaml=$(location bundle.yaml)",
-	})
-	publishPypiRule.SetAttr("deps", []string{"//tools:publisher_utils"})
-	publishPypiRule.SetAttr("visibility", []string{"//visibility:public"})
-	rules = append(rules, publishPypiRule)
+	// Its local twin publishes at a `+local` version, its snapshot twin at a
+	// `.devN` one; see pypiPublishRule.
+	rules = append(rules,
+		pypiPublishRule(config, bundleName, publishRelease),
+		pypiPublishRule(config, bundleName, publishLocal),
+		pypiPublishRule(config, bundleName, publishSnapshot))
 
 	// Convenience alias for publishing
 	publishPypiAlias := rule.NewRule("alias", "publish_to_pypi")
@@ -365,13 +734,110 @@ func generatePythonBundleRules(config *MergedConfig, bundleName string, allProto
 	return rules
 }
 
+// pypiLocalVersionSuffix qualifies the version of the pypi local twin. PEP
+// 440 has no `-local` qualifier, so it is a local version label, which
+// keeps the local install off the release version like the Maven one.
+const pypiLocalVersionSuffix = "+local"
+
+// Snapshot qualifiers of the pypi and npm snapshot twins. Neither registry
+// numbers uploads like a Maven snapshot repository does, so the publisher
+// appends the SNAPSHOT_NUMBER env var to the qualifier at run time (CI's
+// build number, say) and fails without one. Each follows `--next-version`
+// (see snapshotArgs): for 1.2.0, 1.2.1.dev42 is a PEP 440 development
+// release of 1.2.1 and 1.2.1-next.42 a semver prerelease of it, both below
+// 1.2.1.
+const (
+	pypiSnapshotQualifier = ".dev"
+	npmSnapshotQualifier  = "-next."
+	// npmPrereleaseSnapshotQualifier extends a prerelease instead, as
+	// nothing appended to 1.2.0-rc.2 sorts below it: 1.2.0-rc.1.next.42
+	// sorts between 1.2.0-rc.1 and 1.2.0-rc.2.
+	npmPrereleaseSnapshotQualifier = ".next."
+	// npmSnapshotDistTag keeps npm's `latest` on the release: snapshots
+	// install with `npm install <package>@next`.
+	npmSnapshotDistTag = "next"
+)
+
+// snapshotArgs returns the args of the pypi or npm (registry) snapshot
+// twin of config's bundle: `--next-version`, the version the publisher
+// appends `--snapshot-qualifier` and the build number to, in the
+// registry's own spelling. Both sort between the version and the next
+// (see semver.nextVersion), like the Maven snapshot: for 1.2.0-rc.1, PyPI
+// gets 1.2.0rc2.dev<N> and npm 1.2.0-rc.1.next.<N>. A version from the
+// build is only known to the publisher, which gets `--snapshot-base` and
+// the release qualifier, and applies both to the version it reads.
+func snapshotArgs(config *MergedConfig, registry string) []string {
+	qualifier := pypiSnapshotQualifier
+	if registry == "npm" {
+		qualifier = npmSnapshotQualifier
+	}
+	if versionFromBuild(config) {
+		return []string{snapshotBaseFlag(config), "--snapshot-qualifier=" + qualifier}
+	}
+	next := nextVersion(config)
+	switch v, err := parseSemver(config.Version); {
+	case err != nil:
+	case registry == "npm" && v.prerelease != "":
+		next, qualifier = config.Version, npmPrereleaseSnapshotQualifier
+	case registry == "pypi":
+		if n, err := parseSemver(next); err == nil {
+			if pep440, err := n.pep440(); err == nil {
+				next = pep440
+			}
+		}
+	}
+	return []string{"--next-version=" + next, "--snapshot-qualifier=" + qualifier}
+}
+
+// pypiPublishRule returns the pypi publish py_binary for config's bundle:
+// the release one for publishRelease, else a twin publishing the same wheel
+// at a qualified version. The local twin publishes at the version plus
+// pypiLocalVersionSuffix, for the same reason as the Maven twin (see
+// generateJavaBundleRules); the snapshot twin at a development release (see
+// pypiSnapshotQualifier). The version source rides in `data` and is
+// addressed via the same runfiles-relative $(location) mechanism as the
+// bundle artifact arg.
+func pypiPublishRule(config *MergedConfig, bundleName, variant string) *rule.Rule {
+	versionSrc, versionArg := versionInput(config)
+	name := fmt.Sprintf("publish_%s_to_pypi", bundleName)
+	args := []string{
+		fmt.Sprintf("$(location :%s_py_bundle)", bundleName),
+		fmt.Sprintf("--package-name=%s", config.PythonConfig.PackageName),
+		versionArg,
+	}
+	switch variant {
+	case publishLocal:
+		args = append(args, fmt.Sprintf("--version-suffix=%s", pypiLocalVersionSuffix))
+	case publishSnapshot:
+		args = append(args, snapshotArgs(config, "pypi")...)
+	}
+	if variant != publishRelease {
+		name += "_" + variant
+	}
+	r := rule.NewRule("py_binary", name)
+	r.SetAttr("srcs", []string{"//tools:publish/pypi_publisher_generated.py"})
+	r.SetAttr("main", "publish/pypi_publisher_generated.py")
+	r.SetAttr("data", []string{
+		fmt.Sprintf(":%s_py_bundle", bundleName),
+		versionSrc,
+	})
+	// The runtime requirements and metadata flags fill the wheel's METADATA
+	// (Requires-Dist, Summary, License, Author, Keywords, Classifier,
+	// Project-URL).
+	args = append(args, runtimeFlags(config, metadataPypi)...)
+	r.SetAttr("args", append(args, metadataFlags(config, metadataPypi)...))
+	r.SetAttr("deps", []string{"//tools:publisher_utils"})
+	r.SetAttr("visibility", []string{"//visibility:public"})
+	return r
+}
+
 // generateJavaScriptBundleRules creates JavaScript bundle rules with Connect-ES
 // and a per-bundle py_binary publish target. External proto_library targets
 // (e.g. @googleapis//google/api:annotations_proto) are compiled alongside the
 // bundle's own protos by es_proto_compile. NPM publish modes (link, workspace,
 // registry, ...) are still selected at runtime via NPM_PUBLISH_MODE; the
 // package version resolves from bundle.yaml at build/run time.
-func generateJavaScriptBundleRules(config *MergedConfig, bundleName string, allProtoTargets []string, externalProtoLibraries []string) []*rule.Rule {
+func generateJavaScriptBundleRules(config *MergedConfig, bundleName string, protoTargets []string, externalProtoLibraries []string) []*rule.Rule {
 	var rules []*rule.Rule
 
 	// Connect-ES compilation (protoc-gen-es) — generates _pb.js + _pb.d.ts.
@@ -380,7 +846,7 @@ func generateJavaScriptBundleRules(config *MergedConfig, bundleName string, allP
 	// without this the generated authz_pb.js would reference missing
 	// ../../../google/api/*_pb imports and the consumer's build would fail.
 	esProtoRule := rule.NewRule("es_proto_compile", fmt.Sprintf("%s_es_proto", bundleName))
-	protos := append([]string{}, allProtoTargets...)
+	protos := append([]string{}, protoTargets...)
 	protos = append(protos, externalProtoLibraries...)
 	esProtoRule.SetAttr("protos", rule.PlatformStrings{Generic: protos})
 	esProtoRule.SetAttr("visibility", []string{"//visibility:public"})
@@ -396,26 +862,17 @@ func generateJavaScriptBundleRules(config *MergedConfig, bundleName string, allP
 	jsBundleRule.SetAttr("proto_deps", rule.PlatformStrings{Generic: []string{fmt.Sprintf(":%s_all_protos", bundleName)}})
 	jsBundleRule.SetAttr("es_deps", rule.PlatformStrings{Generic: []string{fmt.Sprintf(":%s_es_proto", bundleName)}})
 	jsBundleRule.SetAttr("package_name", config.JavaScriptConfig.PackageName)
-	jsBundleRule.SetAttr("bundle_yaml", ":bundle.yaml")
+	setBundleVersion(jsBundleRule, config)
 	jsBundleRule.SetAttr("visibility", []string{"//visibility:public"})
 	rules = append(rules, jsBundleRule)
 
-	// py_binary publish target. Invoked via `bazel run`.
-	publishNpmRule := rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_npm", bundleName))
-	publishNpmRule.SetAttr("srcs", []string{"//tools:publish/npm_publisher_generated.py"})
-	publishNpmRule.SetAttr("main", "publish/npm_publisher_generated.py")
-	publishNpmRule.SetAttr("data", []string{
-		fmt.Sprintf(":%s_js_bundle", bundleName),
-		"bundle.yaml",
-	})
-	publishNpmRule.SetAttr("args", []string{
-		fmt.Sprintf("$(location :%s_js_bundle)", bundleName),
-		fmt.Sprintf("--package-name=%s", config.JavaScriptConfig.PackageName),
-		"--bundle-yaml=$(location bundle.yaml)",
-	})
-	publishNpmRule.SetAttr("deps", []string{"//tools:publisher_utils", "//tools:pkg_editor"})
-	publishNpmRule.SetAttr("visibility", []string{"//visibility:public"})
-	rules = append(rules, publishNpmRule)
+	// py_binary publish target. Invoked via `bazel run`. Its local twin
+	// publishes at a `-local` prerelease, its snapshot twin at a `-next.N`
+	// one; see npmPublishRule.
+	rules = append(rules,
+		npmPublishRule(config, bundleName, publishRelease),
+		npmPublishRule(config, bundleName, publishLocal),
+		npmPublishRule(config, bundleName, publishSnapshot))
 
 	// Convenience alias for publishing
 	publishNpmAlias := rule.NewRule("alias", "publish_to_npm")
@@ -426,6 +883,200 @@ func generateJavaScriptBundleRules(config *MergedConfig, bundleName string, allP
 	return rules
 }
 
+// npmPublishRule returns the npm publish py_binary for config's bundle: the
+// release one for publishRelease, else a twin publishing the same package
+// at a semver prerelease of the version: `-local` for the local twin,
+// `-next.N` under the `next` dist-tag for the snapshot twin (see
+// npmSnapshotQualifier). A prerelease keeps the twin out of every
+// `^1.0.0`-style range, so only a consumer pinning it explicitly picks it up.
+func npmPublishRule(config *MergedConfig, bundleName, variant string) *rule.Rule {
+	versionSrc, versionArg := versionInput(config)
+	name := fmt.Sprintf("publish_%s_to_npm", bundleName)
+	args := []string{
+		fmt.Sprintf("$(location :%s_js_bundle)", bundleName),
+		fmt.Sprintf("--package-name=%s", config.JavaScriptConfig.PackageName),
+		versionArg,
+	}
+	switch variant {
+	case publishLocal:
+		args = append(args, fmt.Sprintf("--version-suffix=%s", localVersionSuffix))
+	case publishSnapshot:
+		args = append(args, snapshotArgs(config, "npm")...)
+		args = append(args, fmt.Sprintf("--dist-tag=%s", npmSnapshotDistTag))
+	}
+	if variant != publishRelease {
+		name += "_" + variant
+	}
+	r := rule.NewRule("py_binary", name)
+	r.SetAttr("srcs", []string{"//tools:publish/npm_publisher_generated.py"})
+	r.SetAttr("main", "publish/npm_publisher_generated.py")
+	r.SetAttr("data", []string{
+		fmt.Sprintf(":%s_js_bundle", bundleName),
+		versionSrc,
+	})
+	// The runtime dependencies and metadata flags fill package.json's
+	// dependencies, description, license, repository, contributors and
+	// keywords.
+	args = append(args, runtimeFlags(config, metadataNpm)...)
+	r.SetAttr("args", append(args, metadataFlags(config, metadataNpm)...))
+	r.SetAttr("deps", []string{"//tools:publisher_utils", "//tools:pkg_editor"})
+	r.SetAttr("visibility", []string{"//visibility:public"})
+	return r
+}
+
+// generateGoBundleRules creates Go bundle rules and a per-bundle py_binary
+// publish target. Unlike the other languages, a Go consumer fetches source, not
+// a compiled artifact: go_proto_bundle packages the generated .pb.go /
+// _grpc.pb.go files as a module zip and synthesizes its go.mod from the baked
+// module path plus the bundle.yaml version (read at build time via the
+// bundle_yaml attr). goMod lays out the packages (see goModuleFor): external
+// proto imports resolve to pre-built Go packages (e.g. genproto's
+// annotations) in their deps — the Go equivalent of Java's umbrella
+// libraries — and imports of other Go bundles to their packages, required in
+// go.mod.
+func generateGoBundleRules(config *MergedConfig, bundleName string, goMod *goModule) []*rule.Rule {
+	var rules []*rule.Rule
+
+	// One go_proto_library per proto package, with both the message and the
+	// gRPC compiler, so each yields .pb.go and _grpc.pb.go for its protos.
+	// The compiler labels match gazelle's own Go extension defaults.
+	var goDeps []string
+	for _, p := range goMod.packages {
+		goProtoRule := rule.NewRule("go_proto_library", p.name)
+		goProtoRule.SetAttr("importpath", p.importPath)
+		if len(p.protos) > 0 {
+			goProtoRule.SetAttr("protos", rule.PlatformStrings{Generic: p.protos})
+		}
+		goProtoRule.SetAttr("compilers", []string{
+			"@io_bazel_rules_go//proto:go_proto",
+			"@io_bazel_rules_go//proto:go_grpc_v2",
+		})
+		if len(p.deps) > 0 {
+			goProtoRule.SetAttr("deps", p.deps)
+		}
+		goProtoRule.SetAttr("visibility", []string{"//visibility:public"})
+		rules = append(rules, goProtoRule)
+		goDeps = append(goDeps, ":"+p.name)
+	}
+
+	// Go bundle rule. The module path is baked from configuration; the bundler
+	// synthesizes go.mod from it and zips the module under the version read
+	// from bundle.yaml at build time via the bundle_yaml attr.
+	goBundleRule := rule.NewRule("go_proto_bundle", fmt.Sprintf("%s_go_bundle", bundleName))
+	goBundleRule.SetAttr("proto_deps", rule.PlatformStrings{Generic: []string{fmt.Sprintf(":%s_all_protos", bundleName)}})
+	goBundleRule.SetAttr("go_deps", rule.PlatformStrings{Generic: goDeps})
+	goBundleRule.SetAttr("module_path", config.GoConfig.ModulePath)
+	if len(goMod.requires) > 0 {
+		goBundleRule.SetAttr("requires", goMod.requires)
+	}
+	setBundleVersion(goBundleRule, config)
+	goBundleRule.SetAttr("visibility", []string{"//visibility:public"})
+	rules = append(rules, goBundleRule)
+
+	// py_binary publish target. Invoked via `bazel run`; uploads the module zip
+	// to the GOPROXY-compatible endpoint named by GOPROXY_PUBLISH_URL at run time.
+	versionSrc, versionArg := versionInput(config)
+	publishGoRule := rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_goproxy", bundleName))
+	publishGoRule.SetAttr("srcs", []string{"//tools:publish/go_publisher_generated.py"})
+	publishGoRule.SetAttr("main", "publish/go_publisher_generated.py")
+	publishGoRule.SetAttr("data", []string{
+		fmt.Sprintf(":%s_go_bundle", bundleName),
+		versionSrc,
+	})
+	publishGoRule.SetAttr("args", []string{
+		fmt.Sprintf("$(location :%s_go_bundle)", bundleName),
+		fmt.Sprintf("--module-path=%s", config.GoConfig.ModulePath),
+		versionArg,
+	})
+	publishGoRule.SetAttr("deps", []string{"//tools:publisher_utils"})
+	publishGoRule.SetAttr("visibility", []string{"//visibility:public"})
+	rules = append(rules, publishGoRule)
+
+	// Convenience alias for publishing
+	publishGoAlias := rule.NewRule("alias", "publish_to_goproxy")
+	publishGoAlias.SetAttr("actual", fmt.Sprintf(":publish_%s_to_goproxy", bundleName))
+	publishGoAlias.SetAttr("visibility", []string{"//visibility:public"})
+	rules = append(rules, publishGoAlias)
+
+	return rules
+}
+
+// generateBufBundleRules creates the rules that publish a bundle to the Buf
+// Schema Registry: a genrule writing the module's buf.yaml (baked from
+// configuration, like the POM), buf_proto_bundle packaging it with the
+// bundle's protos, and a py_binary publish target running `buf push`. BSR
+// takes source, so nothing is compiled; the publisher labels the push with
+// the bundle.yaml version, read at run time.
+func generateBufBundleRules(config *MergedConfig, bundleName string) []*rule.Rule {
+	var rules []*rule.Rule
+
+	bufYamlRule := rule.NewRule("genrule", fmt.Sprintf("%s_buf_yaml", bundleName))
+	bufYamlRule.SetAttr("outs", rule.PlatformStrings{Generic: []string{fmt.Sprintf("%s.buf.yaml", bundleName)}})
+	bufYamlRule.SetAttr("cmd", bufYamlCommand(config))
+	bufYamlRule.SetAttr("visibility", []string{"//visibility:public"})
+	rules = append(rules, bufYamlRule)
+
+	bufBundleRule := rule.NewRule("buf_proto_bundle", fmt.Sprintf("%s_buf_bundle", bundleName))
+	bufBundleRule.SetAttr("proto_deps", rule.PlatformStrings{Generic: []string{fmt.Sprintf(":%s_all_protos", bundleName)}})
+	bufBundleRule.SetAttr("buf_yaml", fmt.Sprintf(":%s_buf_yaml", bundleName))
+	bufBundleRule.SetAttr("visibility", []string{"//visibility:public"})
+	rules = append(rules, bufBundleRule)
+
+	// py_binary publish target. Invoked via `bazel run`; pushes with the BSR
+	// token in BUF_TOKEN at run time, or with a local registry configured,
+	// copies the module to <local_registry>/<module>/<version> instead.
+	versionSrc, versionArg := versionInput(config)
+	publishBufRule := rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_buf", bundleName))
+	publishBufRule.SetAttr("srcs", []string{"//tools:publish/buf_publisher_generated.py"})
+	publishBufRule.SetAttr("main", "publish/buf_publisher_generated.py")
+	publishBufRule.SetAttr("data", []string{
+		fmt.Sprintf(":%s_buf_bundle", bundleName),
+		versionSrc,
+	})
+	args := []string{
+		fmt.Sprintf("$(location :%s_buf_bundle)", bundleName),
+		fmt.Sprintf("--module=%s", config.BufConfig.Module),
+		versionArg,
+	}
+	if config.BufConfig.LocalRegistry != "" {
+		args = append(args, quotedFlag("local-registry", config.BufConfig.LocalRegistry))
+	}
+	publishBufRule.SetAttr("args", args)
+	publishBufRule.SetAttr("deps", []string{"//tools:publisher_utils"})
+	publishBufRule.SetAttr("visibility", []string{"//visibility:public"})
+	rules = append(rules, publishBufRule)
+
+	// Convenience alias for publishing
+	publishBufAlias := rule.NewRule("alias", "publish_to_buf")
+	publishBufAlias.SetAttr("actual", fmt.Sprintf(":publish_%s_to_buf", bundleName))
+	publishBufAlias.SetAttr("visibility", []string{"//visibility:public"})
+	rules = append(rules, publishBufAlias)
+
+	return rules
+}
+
+// bufYamlCommand builds the buf.yaml genrule cmd for config's bundle: a v2
+// buf.yaml naming the module, rooted at the bundle directory the protos are
+// packaged from, with the configured deps.
+func bufYamlCommand(config *MergedConfig) string {
+	lines := []string{
+		"version: v2",
+		"modules:",
+		"  - path: .",
+		"    name: " + config.BufConfig.Module,
+	}
+	if len(config.BufConfig.Deps) > 0 {
+		lines = append(lines, "deps:")
+		for _, dep := range config.BufConfig.Deps {
+			lines = append(lines, "  - "+dep)
+		}
+	}
+	for i, line := range lines {
+		lines[i] = shellQuote(line)
+	}
+	return fmt.Sprintf(`printf '%%s\n' %s > $@`, strings.Join(lines, " "))
+}
+
 // generateDescriptorSetRules creates a proto_descriptor_set rule for Envoy/gRPC tools
 func generateDescriptorSetRules(config *MergedConfig, bundleName string, protoTargets []string) []*rule.Rule {
 	var rules []*rule.Rule
@@ -438,10 +1089,27 @@ func generateDescriptorSetRules(config *MergedConfig, bundleName string, protoTa
 	return rules
 }
 
+// generateBreakingCheckRule creates the <bundle>_breaking_check
+// proto_breaking_test. Its checker (//tools/breaking) compares the bundle's
+// descriptor set against the baseline one and fails on a wire-incompatible
+// change (a field removed, renumbered or retyped; a message, enum value,
+// service or RPC removed) unless the bundle's version, read at test time,
+// bumps the baseline version's major part (its minor part below 1.0.0, as
+// semver allows). A baseline without a "//", "@" or ":" prefix is a file in
+// the bundle directory.
+func generateBreakingCheckRule(config *MergedConfig, bundleName string) *rule.Rule {
+	r := rule.NewRule("proto_breaking_test", fmt.Sprintf("%s_breaking_check", bundleName))
+	r.SetAttr("descriptor", fmt.Sprintf(":%s_descriptor", bundleName))
+	r.SetAttr("baseline", config.BreakingCheck.Baseline)
+	r.SetAttr("baseline_version", config.BreakingCheck.BaselineVersion)
+	setBundleVersion(r, config)
+	return r
+}
+
 // generateProtoLoaderBundleRules creates proto-loader rules for @grpc/proto-loader packages.
 // Like the npm path, the publish target is a per-bundle py_binary; the version
 // resolves from bundle.yaml at build/run time.
-func generateProtoLoaderBundleRules(config *MergedConfig, bundleName string, allProtoTargets []string) []*rule.Rule {
+func generateProtoLoaderBundleRules(config *MergedConfig, bundleName string, protoTargets []string) []*rule.Rule {
 	var rules []*rule.Rule
 
 	// Proto-loader bundle rule
@@ -450,22 +1118,23 @@ func generateProtoLoaderBundleRules(config *MergedConfig, bundleName string, all
 	// Use the same package name with a -loader suffix to distinguish from compiled JS package
 	loaderPkgName := config.JavaScriptConfig.PackageName + "-loader"
 	protoLoaderRule.SetAttr("package_name", loaderPkgName)
-	protoLoaderRule.SetAttr("bundle_yaml", ":bundle.yaml")
+	setBundleVersion(protoLoaderRule, config)
 	protoLoaderRule.SetAttr("visibility", []string{"//visibility:public"})
 	rules = append(rules, protoLoaderRule)
 
 	// py_binary publish target.
+	versionSrc, versionArg := versionInput(config)
 	publishProtoLoaderRule := rule.NewRule("py_binary", fmt.Sprintf("publish_%s_proto_loader_to_npm", bundleName))
 	publishProtoLoaderRule.SetAttr("srcs", []string{"//tools:publish/proto_loader_publisher_generated.py"})
 	publishProtoLoaderRule.SetAttr("main", "publish/proto_loader_publisher_generated.py")
 	publishProtoLoaderRule.SetAttr("data", []string{
 		fmt.Sprintf(":%s_proto_loader_bundle", bundleName),
-		"bundle.yaml",
+		versionSrc,
 	})
 	publishProtoLoaderRule.SetAttr("args", []string{
 		fmt.Sprintf("$(location :%s_proto_loader_bundle)", bundleName),
 		fmt.Sprintf("--package-name=%s", loaderPkgName),
-		"--bundle-yaml=$(location bundle.yaml)",
+		versionArg,
 	})
 	publishProtoLoaderRule.SetAttr("deps", []string{"//tools:pkg_editor"})
 	publishProtoLoaderRule.SetAttr("visibility", []string{"//visibility:public"})
@@ -502,6 +1171,32 @@ func generateLegacyCleanupRules(config *MergedConfig) []*rule.Rule {
 			rule.NewRule("py_binary", fmt.Sprintf("publish_%s_proto_loader_to_npm", bundleName)))
 	}
 
+	// Delete the breaking check once bundle.yaml drops its breaking_check
+	// section.
+	if config.BreakingCheck == nil {
+		empty = append(empty,
+			rule.NewRule("proto_breaking_test", fmt.Sprintf("%s_breaking_check", bundleName)))
+	}
+
+	// A bundle changing its version_source swaps its Maven publish targets
+	// between maven_publish and py_binary (see mavenPublishRule); a
+	// same-named rule of the old kind would block the merge of the new one.
+	// A literal version also leaves no use for the <bundle>_version genrule.
+	if config.JavaConfig.Enabled {
+		kind := "py_binary"
+		if versionFromBuild(config) {
+			kind = "maven_publish"
+		}
+		empty = append(empty,
+			rule.NewRule(kind, fmt.Sprintf("publish_%s_to_maven", bundleName)),
+			rule.NewRule(kind, fmt.Sprintf("publish_%s_to_maven_local", bundleName)),
+			rule.NewRule(kind, fmt.Sprintf("publish_%s_to_maven_snapshot", bundleName)))
+	}
+	if !versionFromBuild(config) {
+		empty = append(empty,
+			rule.NewRule("genrule", fmt.Sprintf("%s_version", bundleName)))
+	}
+
 	// Delete legacy publish genrules. They collide with the new maven_publish /
 	// py_binary rules (same names) — gazelle's merge would silently skip the
 	// new emission if these aren't explicitly removed first.
@@ -547,10 +1242,18 @@ func generateDisabledLanguageCleanupRules(config *MergedConfig) []*rule.Rule {
 		empty = append(empty,
 			rule.NewRule("java_grpc_library", fmt.Sprintf("%s_java_grpc", bundleName)),
 			rule.NewRule("java_proto_bundle", fmt.Sprintf("%s_java_bundle", bundleName)),
+			rule.NewRule("java_source_bundle", fmt.Sprintf("%s_java_sources", bundleName)),
+			rule.NewRule("javadoc", fmt.Sprintf("%s_javadoc", bundleName)),
 			rule.NewRule("genrule", fmt.Sprintf("%s_pom", bundleName)),
 			rule.NewRule("genrule", fmt.Sprintf("%s_pom_local", bundleName)),
+			rule.NewRule("genrule", fmt.Sprintf("%s_pom_snapshot", bundleName)),
 			rule.NewRule("maven_publish", fmt.Sprintf("publish_%s_to_maven", bundleName)),
 			rule.NewRule("maven_publish", fmt.Sprintf("publish_%s_to_maven_local", bundleName)),
+			rule.NewRule("maven_publish", fmt.Sprintf("publish_%s_to_maven_snapshot", bundleName)),
+			// The py_binary form of a version from the build.
+			rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_maven", bundleName)),
+			rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_maven_local", bundleName)),
+			rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_maven_snapshot", bundleName)),
 			rule.NewRule("alias", "publish_to_maven"))
 	}
 
@@ -559,6 +1262,8 @@ func generateDisabledLanguageCleanupRules(config *MergedConfig) []*rule.Rule {
 			rule.NewRule("python_grpc_library", fmt.Sprintf("%s_python_grpc", bundleName)),
 			rule.NewRule("py_proto_bundle", fmt.Sprintf("%s_py_bundle", bundleName)),
 			rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_pypi", bundleName)),
+			rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_pypi_local", bundleName)),
+			rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_pypi_snapshot", bundleName)),
 			rule.NewRule("alias", "publish_to_pypi"))
 	}
 
@@ -567,19 +1272,37 @@ func generateDisabledLanguageCleanupRules(config *MergedConfig) []*rule.Rule {
 			rule.NewRule("es_proto_compile", fmt.Sprintf("%s_es_proto", bundleName)),
 			rule.NewRule("js_proto_bundle", fmt.Sprintf("%s_js_bundle", bundleName)),
 			rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_npm", bundleName)),
+			rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_npm_local", bundleName)),
+			rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_npm_snapshot", bundleName)),
 			rule.NewRule("alias", "publish_to_npm"),
 			// The proto-loader pair is a JS sub-feature — gone with the language.
 			rule.NewRule("js_proto_loader_bundle", fmt.Sprintf("%s_proto_loader_bundle", bundleName)),
 			rule.NewRule("py_binary", fmt.Sprintf("publish_%s_proto_loader_to_npm", bundleName)))
 	}
 
+	if !config.GoConfig.Enabled {
+		empty = append(empty,
+			rule.NewRule("go_proto_library", fmt.Sprintf("%s_go_proto", bundleName)),
+			rule.NewRule("go_proto_bundle", fmt.Sprintf("%s_go_bundle", bundleName)),
+			rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_goproxy", bundleName)),
+			rule.NewRule("alias", "publish_to_goproxy"))
+	}
+
+	if !config.BufConfig.Enabled {
+		empty = append(empty,
+			rule.NewRule("genrule", fmt.Sprintf("%s_buf_yaml", bundleName)),
+			rule.NewRule("buf_proto_bundle", fmt.Sprintf("%s_buf_bundle", bundleName)),
+			rule.NewRule("py_binary", fmt.Sprintf("publish_%s_to_buf", bundleName)),
+			rule.NewRule("alias", "publish_to_buf"))
+	}
+
 	// With zero languages enabled, generateBundleRules emits no
 	// build_validation at all, so a pre-existing `all` rule would survive and
 	// dangle on its just-deleted bundle targets. Empty-delete it explicitly.
 	// (With at least one language enabled the generated build_validation
 	// merges over the old one — `targets` is mergeable — so the danger only
 	// exists here.)
-	if !config.JavaConfig.Enabled && !config.PythonConfig.Enabled && !config.JavaScriptConfig.Enabled {
+	if !config.JavaConfig.Enabled && !config.PythonConfig.Enabled && !config.JavaScriptConfig.Enabled && !config.GoConfig.Enabled && !config.BufConfig.Enabled {
 		empty = append(empty, rule.NewRule("build_validation", "all"))
 	}
 
@@ -596,9 +1319,14 @@ func generateDisabledLanguageCleanupRules(config *MergedConfig) []*rule.Rule {
 //     appending them to the `protos` attribute of `python_grpc_library` and
 //     `es_proto_compile`. This produces the matching _pb2.py / _pb.js companions
 //     inside the published wheel / npm tarball.
+//   - Go imports the upstream pre-generated packages (genproto, protovalidate's
+//     BSR-generated module) via `go_proto_library.deps`; the published module
+//     then requires them in go.mod rather than vendoring their code.
 type ExternalProtoDeps struct {
 	// Java target list (umbrella libraries, typically one entry per provider).
 	Java []string
+	// Go library targets for the upstream pre-generated packages.
+	Go []string
 	// Raw proto_library targets, used by Python and JS which recompile per-bundle.
 	ProtoLibraries []string
 }
@@ -619,89 +1347,147 @@ var googleapisJsProtos = []string{
 	"@googleapis//google/api:resource_proto",
 }
 
-// detectExternalProtoImports scans a bundle's .proto files and returns the per-language
-// Bazel targets required to satisfy external imports (googleapis, longrunning,
-// protovalidate).
-func detectExternalProtoImports(bundleDir string) ExternalProtoDeps {
-	needsGoogleapis := false
-	needsLongrunning := false
-	needsProtovalidate := false
+// defaultExternalProtos are the built-in external import providers. A
+// lake.yaml `external_protos` entry with the same prefix replaces one; other
+// entries add to them (see mergeExternalProtos).
+var defaultExternalProtos = []ExternalProtoProvider{
+	{
+		Prefix:         "google/api/",
+		Java:           []string{"@googleapis//google/api:api_java_proto"},
+		ProtoLibraries: googleapisJsProtos,
+		Go:             []string{"@org_golang_google_genproto_googleapis_api//annotations"},
+	},
+	{
+		// Java umbrella target aggregates longrunning message + gRPC classes.
+		// Python/JS recompile raw proto_library targets per-bundle —
+		// operations.proto is the only file under google/longrunning and
+		// carries everything callers need.
+		Prefix:         "google/longrunning/",
+		Java:           []string{"@googleapis//google/longrunning:longrunning_java_proto"},
+		ProtoLibraries: []string{"@googleapis//google/longrunning:operations_proto"},
+		Go:             []string{"@com_google_cloud_go_longrunning//autogen/longrunningpb"},
+	},
+	{
+		// Raw proto_library lives under the module's proto/ subtree — see the
+		// lake's `protovalidate_java_proto` target for the canonical path.
+		Prefix:         "buf/validate/",
+		Java:           []string{"//:protovalidate_java_proto"},
+		ProtoLibraries: []string{"@protovalidate//proto/protovalidate/buf/validate:validate_proto"},
+		Go:             []string{"@build_buf_gen_go_bufbuild_protovalidate_protocolbuffers_go//buf/validate"},
+	},
+}
+
+// mergeExternalProtos overlays lake-declared providers on the defaults: an
+// entry whose prefix matches a default replaces it in place, the rest are
+// appended in declaration order.
+func mergeExternalProtos(defaults, lake []ExternalProtoProvider) []ExternalProtoProvider {
+	merged := append([]ExternalProtoProvider{}, defaults...)
+	for _, p := range lake {
+		replaced := false
+		for i := range merged {
+			if merged[i].Prefix == p.Prefix {
+				merged[i] = p
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, p)
+		}
+	}
+	return merged
+}
+
+// matchExternalProto returns the provider for importPath: the one with the
+// longest matching prefix, so a `google/api/` entry wins over a `google/` one.
+func matchExternalProto(providers []ExternalProtoProvider, importPath string) *ExternalProtoProvider {
+	var best *ExternalProtoProvider
+	for i := range providers {
+		p := &providers[i]
+		if p.Prefix != "" && strings.HasPrefix(importPath, p.Prefix) && (best == nil || len(p.Prefix) > len(best.Prefix)) {
+			best = p
+		}
+	}
+	return best
+}
 
-	protoFiles := collectBundleProtoFiles(bundleDir)
-	for _, protoFile := range protoFiles {
+// detectExternalProtoImports scans a bundle's .proto files and returns the
+// per-language Bazel targets required to satisfy the imports covered by
+// providers (googleapis, longrunning and protovalidate by default). Targets
+// are listed in provider order, each once.
+func detectExternalProtoImports(bundleDir string, providers []ExternalProtoProvider) ExternalProtoDeps {
+	needed := make(map[string]bool)
+	for _, protoFile := range collectBundleProtoFiles(bundleDir) {
 		content, err := os.ReadFile(protoFile)
 		if err != nil {
 			continue
 		}
-		matches := importPattern.FindAllStringSubmatch(string(content), -1)
-		for _, match := range matches {
-			importPath := match[1]
-			if strings.HasPrefix(importPath, "google/api/") {
-				needsGoogleapis = true
-			}
-			if strings.HasPrefix(importPath, "google/longrunning/") {
-				needsLongrunning = true
-			}
-			if strings.HasPrefix(importPath, "buf/validate/") {
-				needsProtovalidate = true
+		for _, match := range importPattern.FindAllStringSubmatch(string(content), -1) {
+			if p := matchExternalProto(providers, match[1]); p != nil {
+				needed[p.Prefix] = true
 			}
 		}
 	}
 
 	var out ExternalProtoDeps
-	if needsGoogleapis {
-		out.Java = append(out.Java, "@googleapis//google/api:api_java_proto")
-		out.ProtoLibraries = append(out.ProtoLibraries, googleapisJsProtos...)
-	}
-	if needsLongrunning {
-		// Java umbrella target aggregates longrunning message + gRPC classes.
-		out.Java = append(out.Java, "@googleapis//google/longrunning:longrunning_java_proto")
-		// Python/JS recompile raw proto_library targets per-bundle — operations.proto
-		// is the only file under google/longrunning and carries everything callers need.
-		out.ProtoLibraries = append(out.ProtoLibraries, "@googleapis//google/longrunning:operations_proto")
+	seen := make(map[string]bool)
+	add := func(list []string, targets []string) []string {
+		for _, t := range targets {
+			if !seen[t] {
+				seen[t] = true
+				list = append(list, t)
+			}
+		}
+		return list
 	}
-	if needsProtovalidate {
-		out.Java = append(out.Java, "//:protovalidate_java_proto")
-		// Raw proto_library lives under the module's proto/ subtree — see the lake's
-		// `protovalidate_java_proto` target for the canonical path.
-		out.ProtoLibraries = append(out.ProtoLibraries, "@protovalidate//proto/protovalidate/buf/validate:validate_proto")
+	for _, p := range providers {
+		if needed[p.Prefix] {
+			out.Java = add(out.Java, p.Java)
+			out.ProtoLibraries = add(out.ProtoLibraries, p.ProtoLibraries)
+			out.Go = add(out.Go, p.Go)
+		}
 	}
 	return out
 }
 
-// collectBundleTransitiveDependencies finds transitive dependencies for a specific bundle
-// This replaces the overly aggressive global approach with bundle-scoped dependency collection
-func collectBundleTransitiveDependencies(c *config.Config, bundleDir string, directTargets []string) []string {
-	allDeps := make(map[string]bool)
-
-	// Add direct targets
-	for _, target := range directTargets {
-		allDeps[target] = true
+// collectBundleImports returns the sorted, deduplicated import paths of every
+// proto file in the bundle that resolve within the repo. Well-known types
+// (google/...) and imports covered by an external provider are left out:
+// detectExternalProtoImports wires those per language.
+func collectBundleImports(bundleDir string, providers []ExternalProtoProvider) []string {
+	seen := make(map[string]bool)
+	var imports []string
+	for _, protoFile := range collectBundleProtoFiles(bundleDir) {
+		for _, importPath := range protoFileImports(protoFile, providers) {
+			if !seen[importPath] {
+				seen[importPath] = true
+				imports = append(imports, importPath)
+			}
+		}
 	}
+	sort.Strings(imports)
+	return imports
+}
 
-	// Build global import index (still needed for resolving imports to targets)
-	importToTarget := make(map[string]string)
-	buildImportIndex(c.RepoRoot, importToTarget)
-
-	// Collect bundle-specific proto files and their imports
-	bundleProtoFiles := collectBundleProtoFiles(bundleDir)
-	log.Printf("Found %d proto files in bundle at %s", len(bundleProtoFiles), bundleDir)
-
-	// For each proto file in the bundle, find its imports and resolve them
-	for _, protoFile := range bundleProtoFiles {
-		collectImportsFromProtoFile(protoFile, importToTarget, allDeps)
+// protoFileImports returns the import paths of protoFile in source order,
+// excluding those collectBundleImports leaves out.
+func protoFileImports(protoFile string, providers []ExternalProtoProvider) []string {
+	content, err := os.ReadFile(protoFile)
+	if err != nil {
+		log.Printf("Failed to read proto file %s: %v", protoFile, err)
+		return nil
 	}
-
-	// Convert back to slice
-	var result []string
-	for target := range allDeps {
-		result = append(result, target)
+	var imports []string
+	for _, match := range importPattern.FindAllStringSubmatch(string(content), -1) {
+		if !strings.HasPrefix(match[1], "google/") && matchExternalProto(providers, match[1]) == nil {
+			imports = append(imports, match[1])
+		}
 	}
-
-	return result
+	return imports
 }
 
-// collectBundleProtoFiles finds all proto files within a bundle directory (including subdirectories)
+// collectBundleProtoFiles finds all proto files within a bundle directory (including subdirectories),
+// leaving out nested bundles as discoverProtoTargetsRecursively does.
 func collectBundleProtoFiles(bundleDir string) []string {
 	var protoFiles []string
 
@@ -710,6 +1496,10 @@ func collectBundleProtoFiles(bundleDir string) []string {
 			return nil
 		}
 
+		if info.IsDir() && path != bundleDir && isBundleDir(path) {
+			return filepath.SkipDir
+		}
+
 		// Only include .proto files
 		if strings.HasSuffix(path, ".proto") {
 			// Skip bazel output directories
@@ -723,88 +1513,3 @@ func collectBundleProtoFiles(bundleDir string) []string {
 
 	return protoFiles
 }
-
-// collectImportsFromProtoFile parses a single proto file and collects its imports
-func collectImportsFromProtoFile(protoFile string, importToTarget map[string]string, allDeps map[string]bool) {
-	content, err := os.ReadFile(protoFile)
-	if err != nil {
-		log.Printf("Failed to read proto file %s: %v", protoFile, err)
-		return
-	}
-
-	// Extract imports
-	matches := importPattern.FindAllStringSubmatch(string(content), -1)
-
-	for _, match := range matches {
-		importPath := match[1]
-
-		// Skip well-known protos
-		if strings.HasPrefix(importPath, "google/") {
-			continue
-		}
-
-		// Look up the target for this import
-		if target, ok := importToTarget[importPath]; ok {
-			if !allDeps[target] {
-				allDeps[target] = true
-				log.Printf("Added transitive dependency: %s (from import %s in %s)", target, importPath, protoFile)
-				// Note: We don't recursively collect here to avoid the aggressive behavior
-				// The bundle should only include direct imports from its own proto files
-			}
-		} else {
-			log.Printf("Warning: Could not resolve import %s from %s", importPath, protoFile)
-		}
-	}
-}
-
-// buildImportIndex creates a mapping from import paths to bazel targets
-// This remains largely unchanged but is now only used for resolving specific imports
-func buildImportIndex(repoRoot string, index map[string]string) {
-	filepath.Walk(repoRoot, func(path string, info os.FileInfo, err error) error {
-		if err != nil || !strings.HasSuffix(path, ".proto") {
-			return nil
-		}
-
-		// Skip bazel output directories
-		if strings.Contains(path, bazelDirPrefix) {
-			return nil
-		}
-
-		// Get relative path from repo root
-		relPath, err := filepath.Rel(repoRoot, path)
-		if err != nil {
-			return nil
-		}
-
-		// Find BUILD file for this proto
-		dir := filepath.Dir(path)
-		buildPath := filepath.Join(dir, buildBazelFile)
-		if _, err := os.Stat(buildPath); os.IsNotExist(err) {
-			buildPath = filepath.Join(dir, buildFile)
-			if _, err := os.Stat(buildPath); os.IsNotExist(err) {
-				return nil
-			}
-		}
-
-		// Read BUILD file and find the proto_library rule
-		content, err := os.ReadFile(buildPath)
-		if err == nil {
-			if target := findProtoTarget(string(content), filepath.Base(path)); target != "" {
-				pkg, _ := filepath.Rel(repoRoot, dir)
-				fullTarget := fmt.Sprintf("//%s:%s", pkg, target)
-				index[relPath] = fullTarget
-			}
-		}
-		return nil
-	})
-}
-
-// findProtoTarget finds the proto_library rule that contains the given proto file
-func findProtoTarget(buildContent, protoFile string) string {
-	// Simple pattern matching - in production use proper BUILD parser
-	pattern := regexp.MustCompile(`proto_library\(\s*name\s*=\s*"([^"]+)"[^)]*srcs\s*=\s*\[[^\]]*"` + regexp.QuoteMeta(protoFile) + `"`)
-	if matches := pattern.FindStringSubmatch(buildContent); len(matches) > 1 {
-		return matches[1]
-	}
-	return ""
-}
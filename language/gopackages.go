@@ -0,0 +1,271 @@
+package language
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// goPackage is one go_proto_library of a Go-enabled bundle. rules_go
+// compiles each proto package into its own Go package, so a bundle spanning
+// api/v1 and api/v2 gets a rule for each, and an import from one to the
+// other is a dep between them.
+type goPackage struct {
+	dir        string // repo-relative directory of the package's protos
+	name       string
+	importPath string
+
+	// protos are the bundle's own proto_library targets in dir. A package
+	// compiled in from outside the bundle has none at generation time:
+	// Resolve fills them in from imports, the import paths it serves.
+	protos  []string
+	imports []string
+
+	// deps are the go_proto_library targets of the packages dir's protos
+	// import, in this bundle or another, and external Go packages.
+	deps []string
+}
+
+// goModule is the Go side of a bundle: its packages, and the other bundles'
+// modules they import as go.mod requirements ("module@vX.Y.Z").
+type goModule struct {
+	packages []*goPackage
+	requires []string
+}
+
+// goPackageName names the go_proto_library of protos in dir for the bundle
+// named bundleName whose Go packages are laid out from base: the bundle's
+// own directory for its protos, the repo root for those compiled in.
+func goPackageName(bundleName, base, dir string) string {
+	sub := goSubpath(base, dir)
+	if sub == "" {
+		return bundleName + "_go_proto"
+	}
+	return fmt.Sprintf("%s_%s_go_proto", bundleName, strings.ReplaceAll(sub, "/", "_"))
+}
+
+// goImportPath is the Go import path of protos in dir, under prefix as laid
+// out from base (see goPackageName).
+func goImportPath(prefix, base, dir string) string {
+	if sub := goSubpath(base, dir); sub != "" {
+		return prefix + "/" + sub
+	}
+	return prefix
+}
+
+func goSubpath(base, dir string) string {
+	if base == "" {
+		return dir
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(dir, base), "/")
+}
+
+// goModuleFor lays out the Go packages of the bundle at rel: one per
+// directory holding its proto targets, plus one per directory of protos it
+// imports that no Go-publishing bundle owns, compiled in under the bundle's
+// import path prefix at their repo path. Protos another bundle publishes for
+// Go are a dep on that bundle's go_proto_library and a go.mod requirement
+// on its module, whatever bundle_dependencies says: the alternative is a
+// second copy of the same proto package in a different Go package. Every
+// import is followed, since a Go package needs a dep for each of them.
+func (pe *protolakeExtension) goModuleFor(c *config.Config, rel string, config *MergedConfig, protoTargets []string) *goModule {
+	pe.importIndex(c)
+	bundleName := config.BundleName
+	prefix := config.GoConfig.ImportPathPrefix
+	m := &goModule{}
+	byDir := make(map[string]*goPackage)
+	byName := make(map[string]*goPackage)
+	requires := make(map[string]string)
+
+	type pending struct {
+		pkg  *goPackage
+		file string
+	}
+	var queue []pending
+
+	add := func(p *goPackage) {
+		if other := byName[p.name]; other != nil {
+			log.Fatalf("[protolake-gazelle] bundle %q at %s would generate two Go packages named %s: "+
+				"protos in %s and %s compiled in at %s. Move one of them.",
+				bundleName, rel, p.name, other.dir, p.dir, p.importPath)
+		}
+		byDir[p.dir] = p
+		byName[p.name] = p
+		m.packages = append(m.packages, p)
+	}
+
+	for _, t := range protoTargets {
+		l, err := label.Parse(t)
+		if err != nil {
+			continue
+		}
+		l = l.Abs("", rel)
+		p := byDir[l.Pkg]
+		if p == nil {
+			p = &goPackage{
+				dir:        l.Pkg,
+				name:       goPackageName(bundleName, rel, l.Pkg),
+				importPath: goImportPath(prefix, rel, l.Pkg),
+			}
+			add(p)
+		}
+		p.protos = append(p.protos, t)
+		for _, file := range pe.targetFiles(l) {
+			queue = append(queue, pending{p, file})
+		}
+	}
+
+	deps := make(map[*goPackage]map[string]bool)
+	addDep := func(p *goPackage, dep string) {
+		if deps[p] == nil {
+			deps[p] = make(map[string]bool)
+		}
+		deps[p][dep] = true
+	}
+
+	link := func(p *goPackage, imp string) {
+		file := pe.protoFileFor(c, imp)
+		if file == "" {
+			log.Printf("Warning: Could not find %s, imported by Go package %s", imp, p.importPath)
+			return
+		}
+		dir := path.Dir(file)
+		if dir == "." {
+			dir = ""
+		}
+		if q := byDir[dir]; q != nil {
+			if q != p {
+				addDep(p, ":"+q.name)
+			}
+			if q.protos == nil && !contains(q.imports, imp) {
+				q.imports = append(q.imports, imp)
+				queue = append(queue, pending{q, file})
+			}
+			return
+		}
+		if owner, owned := pe.bundleOwner(c, dir); owned && owner != rel {
+			if cfg := pe.bundleConfig(c, owner); cfg != nil && cfg.GoConfig.Enabled {
+				if versionFromBuild(cfg) || cfg.Version == "" {
+					log.Fatalf("[protolake-gazelle] bundle %q at %s imports %s from Go module %s, whose "+
+						"version isn't known at gazelle time (bundle %q at %s), so its go.mod can't require it. "+
+						"Give %q a literal version, or disable Go for one of the bundles.",
+						bundleName, rel, imp, cfg.GoConfig.ModulePath, cfg.BundleName, owner, cfg.BundleName)
+				}
+				if err := goModuleError(cfg.GoConfig.ModulePath, cfg.Version); err != nil {
+					log.Fatalf("[protolake-gazelle] bundle %q at %s imports %s from bundle %q at %s, "+
+						"whose go.mod couldn't require it: %v. Fix the module_path in %q's bundle.yaml.",
+						bundleName, rel, imp, cfg.BundleName, owner, err, cfg.BundleName)
+				}
+				addDep(p, fmt.Sprintf("//%s:%s", owner, goPackageName(cfg.BundleName, owner, dir)))
+				requires[cfg.GoConfig.ModulePath] = cfg.Version
+				return
+			}
+		}
+		q := &goPackage{
+			dir:        dir,
+			name:       goPackageName(bundleName, "", dir),
+			importPath: goImportPath(prefix, "", dir),
+			imports:    []string{imp},
+		}
+		add(q)
+		addDep(p, ":"+q.name)
+		queue = append(queue, pending{q, file})
+	}
+
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		content, err := os.ReadFile(filepath.Join(c.RepoRoot, next.file))
+		if err != nil {
+			log.Printf("Failed to read proto file %s: %v", next.file, err)
+			continue
+		}
+		for _, match := range importPattern.FindAllStringSubmatch(string(content), -1) {
+			imp := match[1]
+			if provider := matchExternalProto(config.ExternalProtos, imp); provider != nil {
+				for _, dep := range provider.Go {
+					addDep(next.pkg, dep)
+				}
+				continue
+			}
+			if strings.HasPrefix(imp, "google/") {
+				// Well-known types come with the go_proto compiler.
+				continue
+			}
+			link(next.pkg, imp)
+		}
+	}
+
+	for _, p := range m.packages {
+		for dep := range deps[p] {
+			p.deps = append(p.deps, dep)
+		}
+		sort.Strings(p.deps)
+	}
+	for module, version := range requires {
+		m.requires = append(m.requires, fmt.Sprintf("%s@v%s", module, version))
+	}
+	sort.Strings(m.requires)
+	return m
+}
+
+// targetFiles returns the repo-relative source files of the proto_library l.
+func (pe *protolakeExtension) targetFiles(l label.Label) []string {
+	want := fmt.Sprintf("//%s:%s", l.Pkg, l.Name)
+	var files []string
+	for importPath, target := range pe.index.byPkg[l.Pkg] {
+		if target == want {
+			files = append(files, pe.index.files[importPath])
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// staleGoPackageRules returns Empty rules for the bundle's go_proto_library
+// rules in f that gen no longer holds: packages whose protos moved or were
+// deleted.
+func staleGoPackageRules(f *rule.File, bundleName string, gen []*rule.Rule) []*rule.Rule {
+	if f == nil {
+		return nil
+	}
+	current := make(map[string]bool)
+	for _, r := range gen {
+		if r.Kind() == "go_proto_library" {
+			current[r.Name()] = true
+		}
+	}
+	var empty []*rule.Rule
+	for _, r := range f.Rules {
+		name := r.Name()
+		if r.Kind() == "go_proto_library" && !current[name] &&
+			strings.HasPrefix(name, bundleName+"_") && strings.HasSuffix(name, "_go_proto") {
+			empty = append(empty, rule.NewRule("go_proto_library", name))
+		}
+	}
+	return empty
+}
+
+// resolveGoPackage fills in the protos of a Go package compiled in from
+// outside the bundle, resolving the import paths it serves.
+func (pe *protolakeExtension) resolveGoPackage(c *config.Config, ix *resolve.RuleIndex, r *rule.Rule, p *goPackage, from label.Label) {
+	var protos []label.Label
+	for _, imp := range p.imports {
+		l, ok := pe.resolveProtoImport(c, ix, imp)
+		if !ok {
+			log.Printf("Warning: Could not resolve import %s for %s", imp, from)
+			continue
+		}
+		protos = append(protos, l)
+	}
+	appendLabels(r, "protos", protos, from)
+}
@@ -0,0 +1,288 @@
+package language
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+)
+
+// graphFlag turns on the graph export: once gazelle has resolved every rule,
+// the lake's bundles and the imports between them are written to
+// <path>.json for tools and <path>.dot for Graphviz, path relative to the
+// repo root. "Which bundles break if I change common/types.proto" is then a
+// query on the JSON rather than a grep.
+const graphFlag = "protolake_graph"
+
+// bundleGraph is the JSON document the graph export writes.
+type bundleGraph struct {
+	Bundles []graphBundle `json:"bundles"`
+	// Imports are the edges of the graph, sorted by importing bundle.
+	Imports []graphImport `json:"imports"`
+}
+
+// graphBundle is a bundle generated this run.
+type graphBundle struct {
+	Name    string `json:"name"`
+	Package string `json:"package"`
+	// Version is bundle.yaml's version, empty when VersionSource takes it
+	// from the build.
+	Version       string `json:"version,omitempty"`
+	VersionSource string `json:"version_source"`
+	// ProtoTargets are the proto_library targets the bundle publishes.
+	ProtoTargets []string `json:"proto_targets"`
+	// Coordinates holds, per enabled registry, what the bundle publishes as:
+	// maven group:artifact, pypi and npm package names, the go module path
+	// and the buf module.
+	Coordinates map[string]string `json:"coordinates"`
+	// External lists the targets of the external providers (see
+	// detectExternalProtoImports) the bundle's imports use, per language.
+	External *graphExternal `json:"external,omitempty"`
+}
+
+type graphExternal struct {
+	Java           []string `json:"java,omitempty"`
+	ProtoLibraries []string `json:"proto_libraries,omitempty"`
+	Go             []string `json:"go,omitempty"`
+}
+
+// graphImport is an import reached from a bundle's protos: directly, or
+// through the in-repo protos no bundle owns, which the bundle compiles in.
+type graphImport struct {
+	From   string `json:"from"`   // package of the importing bundle
+	Import string `json:"import"` // import path
+	// Via is the import path of the unowned proto that imports it, empty for
+	// the bundle's own imports.
+	Via string `json:"via,omitempty"`
+	// Target is the proto_library providing the import, empty when it
+	// doesn't resolve; Bundle is the package of the bundle owning Target,
+	// empty when none does.
+	Target string `json:"target,omitempty"`
+	Bundle string `json:"bundle,omitempty"`
+}
+
+// graphRecord is what generateBundle keeps of a bundle for the graph export.
+type graphRecord struct {
+	c            *config.Config
+	rel          string
+	config       *MergedConfig
+	protoTargets []string
+	imports      []string
+	external     ExternalProtoDeps
+}
+
+// recordGraphBundle keeps the bundle generated from args for the graph.
+func (pe *protolakeExtension) recordGraphBundle(args language.GenerateArgs, config *MergedConfig, protoTargets, imports []string) {
+	pe.graphBundles = append(pe.graphBundles, &graphRecord{
+		c:            args.Config,
+		rel:          args.Rel,
+		config:       config,
+		protoTargets: protoTargets,
+		imports:      imports,
+		external:     detectExternalProtoImports(args.Dir, config.ExternalProtos),
+	})
+}
+
+// buildGraph resolves the recorded bundles' imports through the repo import
+// index (see buildImportIndex), following protos no bundle owns like the
+// default dependency mode does.
+func (pe *protolakeExtension) buildGraph() *bundleGraph {
+	graph := &bundleGraph{Bundles: []graphBundle{}, Imports: []graphImport{}}
+	records := append([]*graphRecord{}, pe.graphBundles...)
+	sort.Slice(records, func(i, j int) bool { return records[i].rel < records[j].rel })
+
+	for _, rec := range records {
+		b := graphBundle{
+			Name:          rec.config.BundleName,
+			Package:       rec.rel,
+			Version:       rec.config.Version,
+			VersionSource: rec.config.VersionSource,
+			ProtoTargets:  []string{},
+			Coordinates:   bundleCoordinates(rec.config),
+		}
+		for _, t := range rec.protoTargets {
+			if l, err := label.Parse(t); err == nil {
+				t = absoluteLabel(l.Abs("", rec.rel))
+			}
+			b.ProtoTargets = append(b.ProtoTargets, t)
+		}
+		if ext := rec.external; len(ext.Java)+len(ext.ProtoLibraries)+len(ext.Go) > 0 {
+			b.External = &graphExternal{Java: ext.Java, ProtoLibraries: ext.ProtoLibraries, Go: ext.Go}
+		}
+		graph.Bundles = append(graph.Bundles, b)
+
+		seen := make(map[string]bool)
+		var visit func(imp, via string)
+		visit = func(imp, via string) {
+			if seen[imp] {
+				return
+			}
+			seen[imp] = true
+			edge := graphImport{From: rec.rel, Import: imp, Via: via}
+			l, ok := pe.resolveProtoImport(rec.c, nil, imp)
+			if !ok {
+				graph.Imports = append(graph.Imports, edge)
+				return
+			}
+			edge.Target = absoluteLabel(l)
+			inRepo := l.Repo == ""
+			owner, owned := "", false
+			if inRepo {
+				owner, owned = pe.bundleOwner(rec.c, l.Pkg)
+			}
+			if owned && owner == rec.rel {
+				// The bundle's own protos import each other.
+				return
+			}
+			edge.Bundle = owner
+			graph.Imports = append(graph.Imports, edge)
+			if !inRepo || owned {
+				return
+			}
+			if file := pe.protoFileFor(rec.c, imp); file != "" {
+				for _, next := range protoFileImports(filepath.Join(rec.c.RepoRoot, file), rec.config.ExternalProtos) {
+					visit(next, imp)
+				}
+			}
+		}
+		for _, imp := range rec.imports {
+			visit(imp, "")
+		}
+	}
+	return graph
+}
+
+// absoluteLabel formats l in full, "//pkg:name" even for "//pkg:pkg".
+func absoluteLabel(l label.Label) string {
+	if l.Repo != "" {
+		return fmt.Sprintf("@%s//%s:%s", l.Repo, l.Pkg, l.Name)
+	}
+	return fmt.Sprintf("//%s:%s", l.Pkg, l.Name)
+}
+
+// bundleCoordinates returns what config's bundle publishes as, per enabled
+// registry.
+func bundleCoordinates(config *MergedConfig) map[string]string {
+	coords := make(map[string]string)
+	if config.JavaConfig.Enabled {
+		coords["maven"] = config.JavaConfig.GroupId + ":" + config.JavaConfig.ArtifactId
+	}
+	if config.PythonConfig.Enabled {
+		coords["pypi"] = config.PythonConfig.PackageName
+	}
+	if config.JavaScriptConfig.Enabled {
+		coords["npm"] = config.JavaScriptConfig.PackageName
+		if config.JavaScriptConfig.ProtoLoader {
+			coords["npm_proto_loader"] = config.JavaScriptConfig.PackageName + "-loader"
+		}
+	}
+	if config.GoConfig.Enabled {
+		coords["go"] = config.GoConfig.ModulePath
+	}
+	if config.BufConfig.Enabled {
+		coords["buf"] = config.BufConfig.Module
+	}
+	return coords
+}
+
+// dot renders g for Graphviz: a box per bundle, an edge per bundle it
+// imports from labelled with the imports, ellipses for the in-repo protos no
+// bundle owns, and dashed edges to the external providers' proto_library
+// targets. Unresolved imports and the providers' per-language targets are
+// left out; the JSON has them.
+func (g *bundleGraph) dot() string {
+	var b strings.Builder
+	b.WriteString("digraph bundles {\n  rankdir = LR;\n")
+	for _, bundle := range g.Bundles {
+		text := bundle.Name
+		if bundle.Version != "" {
+			text += "\\n" + bundle.Version
+		}
+		fmt.Fprintf(&b, "  %s [shape = box, label = %s];\n", dotQuote("//"+bundle.Package), dotQuote(text))
+	}
+
+	type edge struct{ from, to string }
+	labels := make(map[edge][]string)
+	var edges []edge
+	protos := make(map[string]bool)
+	for _, imp := range g.Imports {
+		if imp.Target == "" {
+			continue
+		}
+		from := "//" + imp.From
+		if imp.Via != "" {
+			// Drawn from the unowned proto that imports it.
+			from = g.targetOf(imp.From, imp.Via)
+		}
+		to := imp.Target
+		if imp.Bundle != "" {
+			to = "//" + imp.Bundle
+		} else {
+			protos[to] = true
+		}
+		e := edge{from, to}
+		if _, ok := labels[e]; !ok {
+			edges = append(edges, e)
+		}
+		labels[e] = append(labels[e], imp.Import)
+	}
+	for _, p := range sortedSet(protos) {
+		fmt.Fprintf(&b, "  %s [shape = ellipse];\n", dotQuote(p))
+	}
+	for _, e := range edges {
+		fmt.Fprintf(&b, "  %s -> %s [label = %s];\n", dotQuote(e.from), dotQuote(e.to), dotQuote(strings.Join(labels[e], "\\n")))
+	}
+	for _, bundle := range g.Bundles {
+		if bundle.External == nil {
+			continue
+		}
+		for _, t := range bundle.External.ProtoLibraries {
+			fmt.Fprintf(&b, "  %s -> %s [style = dashed];\n", dotQuote("//"+bundle.Package), dotQuote(t))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotQuote quotes s as a DOT string, keeping its `\n` line breaks.
+func dotQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// targetOf returns the target the bundle at from resolved import imp to.
+func (g *bundleGraph) targetOf(from, imp string) string {
+	for _, i := range g.Imports {
+		if i.From == from && i.Import == imp {
+			return i.Target
+		}
+	}
+	return imp
+}
+
+// writeGraph writes the graph export to path's .json and .dot files.
+func (pe *protolakeExtension) writeGraph(path string) error {
+	graph := pe.buildGraph()
+	data, err := json.MarshalIndent(graph, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".json", append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".dot", []byte(graph.dot()), 0644); err != nil {
+		return err
+	}
+	log.Printf("[protolake-gazelle] Wrote the graph of %d bundles and %d imports to %s.json and %s.dot",
+		len(graph.Bundles), len(graph.Imports), path, path)
+	return nil
+}
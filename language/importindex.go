@@ -0,0 +1,259 @@
+package language
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+const (
+	bazelIgnoreFile  = ".bazelignore"
+	excludeDirective = "exclude"
+
+	// protoLang is the proto extension's language name: the namespace of
+	// proto import specs and `# gazelle:resolve proto ...` directives.
+	protoLang = "proto"
+)
+
+// bundleRuleKinds are the per-language bundle rules that advertise the
+// imports their bundle provides.
+var bundleRuleKinds = map[string]bool{
+	"java_proto_bundle": true,
+	"py_proto_bundle":   true,
+	"js_proto_bundle":   true,
+	"go_proto_bundle":   true,
+}
+
+// protoImportIndex maps proto import paths to the proto_library labels that
+// provide them, repo-wide. One walk builds it per gazelle run, the first time
+// a bundle needs it; after that recordProtoPackage keeps it current as gazelle
+// generates each package, so later bundles still see this pass's rules.
+type protoImportIndex struct {
+	targets map[string]string            // import path -> label
+	files   map[string]string            // import path -> repo-relative source file
+	byPkg   map[string]map[string]string // package -> import path -> label it provides
+
+	// configFiles are the repo-relative lake.yaml, group.yaml and
+	// bundle.yaml files the walk found, for validateLake.
+	configFiles []string
+}
+
+func newProtoImportIndex() *protoImportIndex {
+	return &protoImportIndex{
+		targets: make(map[string]string),
+		files:   make(map[string]string),
+		byPkg:   make(map[string]map[string]string),
+	}
+}
+
+// setPackage replaces everything package pkg provides with libs' srcs, indexed
+// under the import path Bazel gives them (see protoLibraryInfo.importPath).
+func (ix *protoImportIndex) setPackage(pkg string, libs []protoLibraryInfo) {
+	for importPath, target := range ix.byPkg[pkg] {
+		// Only drop entries this package still owns; another package may
+		// have claimed the import path since.
+		if ix.targets[importPath] == target {
+			delete(ix.targets, importPath)
+			delete(ix.files, importPath)
+		}
+	}
+	delete(ix.byPkg, pkg)
+
+	for _, lib := range libs {
+		for _, src := range lib.Srcs {
+			if !strings.HasSuffix(src, ".proto") {
+				continue
+			}
+			importPath := lib.importPath(pkg, src)
+			target := fmt.Sprintf("//%s:%s", pkg, lib.Name)
+			ix.targets[importPath] = target
+			ix.files[importPath] = path.Join(pkg, src)
+			if ix.byPkg[pkg] == nil {
+				ix.byPkg[pkg] = make(map[string]string)
+			}
+			ix.byPkg[pkg][importPath] = target
+		}
+	}
+}
+
+// importIndex returns the repo-wide import index, walking the repo on first
+// use only.
+func (pe *protolakeExtension) importIndex(c *config.Config) map[string]string {
+	if pe.index == nil {
+		pe.index = pe.buildImportIndex(c)
+	}
+	return pe.index.targets
+}
+
+// buildImportIndex walks every package in the repo and indexes its
+// proto_library srcs. Packages gazelle has already generated this pass come
+// from memory (see protoLibrariesIn); the rest are parsed from disk. The walk
+// skips what gazelle's own walk skips: bazel output trees, .git, paths listed
+// in .bazelignore, and `# gazelle:exclude` patterns (from the -exclude flag,
+// directives seen by Configure, and directives in BUILD files parsed here).
+// It also collects the lake's config files, sparing validateLake a walk.
+func (pe *protolakeExtension) buildImportIndex(c *config.Config) *protoImportIndex {
+	ix := newProtoImportIndex()
+	ignored := loadBazelIgnore(c.RepoRoot)
+
+	filepath.Walk(c.RepoRoot, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(c.RepoRoot, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if !info.IsDir() {
+			switch info.Name() {
+			case lakeYamlFile, groupYamlFile, bundleYamlFile:
+				ix.configFiles = append(ix.configFiles, rel)
+			}
+			return nil
+		}
+		if rel == "." {
+			rel = ""
+		}
+		if rel != "" {
+			name := info.Name()
+			if name == ".git" || strings.HasPrefix(name, bazelDirPrefix) || ignored[rel] || matchesAnyGlob(pe.excludes, rel) {
+				return filepath.SkipDir
+			}
+		}
+
+		libs, ok := pe.protoPackages[rel]
+		if !ok {
+			f := loadBuildFile(c, p)
+			if f == nil {
+				return nil
+			}
+			pe.recordExcludes(rel, f)
+			libs = protoLibrariesInFile(c, f, p)
+		}
+		ix.setPackage(rel, libs)
+		return nil
+	})
+
+	log.Printf("Indexed %d proto imports across %d packages", len(ix.targets), len(ix.byPkg))
+	return ix
+}
+
+// protoFileFor returns the repo-relative source file of import path imp, or ""
+// when it isn't in the repo. Files outside any indexed proto_library are
+// found at their import path, the layout of a repo without import prefixes.
+func (pe *protolakeExtension) protoFileFor(c *config.Config, imp string) string {
+	pe.importIndex(c)
+	if file, ok := pe.index.files[imp]; ok {
+		return file
+	}
+	if info, err := os.Stat(filepath.Join(c.RepoRoot, imp)); err == nil && !info.IsDir() {
+		return imp
+	}
+	return ""
+}
+
+// providedImports returns the sorted import paths served by targets, the
+// bundle's proto_library labels as written in package rel.
+func (pe *protolakeExtension) providedImports(c *config.Config, rel string, targets []string) []string {
+	pe.importIndex(c)
+	var provides []string
+	for _, t := range targets {
+		l, err := label.Parse(t)
+		if err != nil {
+			continue
+		}
+		l = l.Abs("", rel)
+		want := fmt.Sprintf("//%s:%s", l.Pkg, l.Name)
+		for importPath, target := range pe.index.byPkg[l.Pkg] {
+			if target == want {
+				provides = append(provides, importPath)
+			}
+		}
+	}
+	sort.Strings(provides)
+	return provides
+}
+
+// resolveProtoImport finds the proto_library providing imp: a
+// `# gazelle:resolve proto` override, else the RuleIndex (populated by the
+// proto extension), else the repo import index.
+func (pe *protolakeExtension) resolveProtoImport(c *config.Config, ix *resolve.RuleIndex, imp string) (label.Label, bool) {
+	spec := resolve.ImportSpec{Lang: protoLang, Imp: imp}
+	if l, ok := resolve.FindRuleWithOverride(c, spec, protoLang); ok {
+		return l, true
+	}
+	if ix != nil {
+		if results := ix.FindRulesByImportWithConfig(c, spec, protoLang); len(results) > 0 {
+			if len(results) > 1 {
+				log.Printf("Multiple proto_library rules provide %s; using %s", imp, results[0].Label)
+			}
+			return results[0].Label, true
+		}
+	}
+	if target, ok := pe.importIndex(c)[imp]; ok {
+		if l, err := label.Parse(target); err == nil {
+			return l, true
+		}
+	}
+	return label.NoLabel, false
+}
+
+// relativeLabel formats l the way discovery writes bundle targets: ":name"
+// within from's package, "//pkg:name" elsewhere in the repo. Unlike
+// label.Label.String it never shortens "//a/b:b" to "//a/b", so resolved
+// targets dedupe against discovered ones.
+func relativeLabel(l label.Label, from label.Label) string {
+	if l.Repo == "" || l.Repo == from.Repo {
+		if l.Pkg == from.Pkg {
+			return ":" + l.Name
+		}
+		return fmt.Sprintf("//%s:%s", l.Pkg, l.Name)
+	}
+	return fmt.Sprintf("@%s//%s:%s", l.Repo, l.Pkg, l.Name)
+}
+
+// recordExcludes collects the `# gazelle:exclude` directives in f, the BUILD
+// file of package rel. Like gazelle, patterns are relative to the package.
+func (pe *protolakeExtension) recordExcludes(rel string, f *rule.File) {
+	for _, d := range f.Directives {
+		if d.Key == excludeDirective {
+			pe.excludes = append(pe.excludes, path.Join(rel, d.Value))
+		}
+	}
+}
+
+// loadBazelIgnore returns the repo-relative paths listed in the repo's
+// .bazelignore. Bazel doesn't support globs there, so neither do we.
+func loadBazelIgnore(repoRoot string) map[string]bool {
+	ignored := make(map[string]bool)
+	file, err := os.Open(filepath.Join(repoRoot, bazelIgnoreFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read %s: %v", bazelIgnoreFile, err)
+		}
+		return ignored
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ignored[path.Clean(line)] = true
+	}
+	return ignored
+}
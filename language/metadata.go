@@ -0,0 +1,134 @@
+package language
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Metadata is the metadata section of lake.yaml, group.yaml and bundle.yaml:
+// what registries show for a published artifact, and what Maven Central
+// requires of a POM (license, SCM URL, developers). Each layer overrides the
+// fields it sets; a list replaces the one below it rather than extending it.
+type Metadata struct {
+	Homepage    string      `yaml:"homepage"`
+	License     string      `yaml:"license"` // SPDX identifier, e.g. "Apache-2.0"
+	LicenseURL  string      `yaml:"license_url"`
+	ScmURL      string      `yaml:"scm_url"`
+	Developers  []Developer `yaml:"developers"`
+	Keywords    []string    `yaml:"keywords"`
+	Classifiers []string    `yaml:"classifiers"` // PyPI trove classifiers; the wheel only
+}
+
+// Developer is one entry of a metadata developers list.
+type Developer struct {
+	Name  string `yaml:"name" schema:"required"`
+	Email string `yaml:"email"`
+	URL   string `yaml:"url"`
+}
+
+// String formats d as a person string, "Name <email> (url)", the form npm
+// takes and pom_generator and the pypi publisher parse.
+func (d Developer) String() string {
+	s := d.Name
+	if d.Email != "" {
+		s += " <" + d.Email + ">"
+	}
+	if d.URL != "" {
+		s += " (" + d.URL + ")"
+	}
+	return s
+}
+
+// applyMetadata applies one layer's metadata section to m.
+func (m *MergedConfig) applyMetadata(o *Metadata) {
+	if o.Homepage != "" {
+		m.Metadata.Homepage = o.Homepage
+	}
+	if o.License != "" {
+		m.Metadata.License = o.License
+	}
+	if o.LicenseURL != "" {
+		m.Metadata.LicenseURL = o.LicenseURL
+	}
+	if o.ScmURL != "" {
+		m.Metadata.ScmURL = o.ScmURL
+	}
+	if len(o.Developers) > 0 {
+		m.Metadata.Developers = o.Developers
+	}
+	if len(o.Keywords) > 0 {
+		m.Metadata.Keywords = o.Keywords
+	}
+	if len(o.Classifiers) > 0 {
+		m.Metadata.Classifiers = o.Classifiers
+	}
+}
+
+// Publish targets whose command lines carry metadata; each takes the flags
+// its manifest has a field for.
+const (
+	metadataPom  = "pom"
+	metadataPypi = "pypi"
+	metadataNpm  = "npm"
+)
+
+// metadataFlags returns the metadata command-line flags of config's bundle
+// for target, each `--flag=value` and shell-quoted: both a genrule cmd and
+// py_binary args are Bourne-tokenized by Bazel, and a description has spaces.
+// The equals form keeps argparse from taking a value that starts with `-` for
+// a flag. Unset fields are left out, and the tool falls back to its default.
+func metadataFlags(config *MergedConfig, target string) []string {
+	var flags []string
+	add := func(flag, value string) {
+		if value != "" {
+			flags = append(flags, quotedFlag(flag, value))
+		}
+	}
+
+	if target == metadataPom {
+		add("name", config.DisplayName)
+	}
+	// A folded YAML description spans lines; every manifest wants one.
+	add("description", strings.Join(strings.Fields(config.Description), " "))
+	add("homepage", config.Metadata.Homepage)
+	add("license", config.Metadata.License)
+	if target == metadataPom {
+		add("license-url", config.Metadata.LicenseURL)
+	}
+	add("scm-url", config.Metadata.ScmURL)
+	for _, d := range config.Metadata.Developers {
+		add("developer", d.String())
+	}
+	if target != metadataPom {
+		for _, k := range config.Metadata.Keywords {
+			add("keyword", k)
+		}
+	}
+	if target == metadataPypi {
+		for _, c := range config.Metadata.Classifiers {
+			add("classifier", c)
+		}
+	}
+	return flags
+}
+
+// shellSafe matches values that need no quoting in a Bourne-tokenized string.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_-]+$`)
+
+// quotedFlag formats --flag=value for a genrule cmd or py_binary args, the
+// value quoted by shellQuote.
+func quotedFlag(flag, value string) string {
+	return fmt.Sprintf("--%s=%s", flag, shellQuote(value))
+}
+
+// shellQuote quotes value for a genrule cmd or py_binary args: single-quoted
+// unless it is shell-safe, with `$` doubled so Bazel's make variable
+// expansion leaves it alone.
+func shellQuote(value string) string {
+	value = strings.ReplaceAll(value, "$", "$$")
+	if !shellSafe.MatchString(value) {
+		value = "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+	}
+	return value
+}
@@ -0,0 +1,167 @@
+package language
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// Coordinate templates let lake.yaml derive each bundle's publish coordinates
+// from its name, so a new bundle.yaml needs only a name and a version:
+//
+//	java:
+//	  artifact_id: "{{.Name}}-proto"
+//	python:
+//	  package_prefix: "example_proto"   # package_name defaults to "{{.PackagePrefix}}_{{.Name | snake}}"
+//	javascript:
+//	  package_scope: "@example"         # package_name defaults to "{{.PackageScope}}/{{.Name}}"
+//
+// Any artifact_id or package_name, in lake.yaml or bundle.yaml, is a Go
+// text/template over coordinateData; one without actions is used as is.
+
+// Default package_name templates, used when lake.yaml sets a prefix or scope
+// but no package_name.
+const (
+	defaultPythonPackageTemplate = "{{.PackagePrefix}}_{{.Name | snake}}"
+	defaultJSPackageTemplate     = "{{.PackageScope}}/{{.Name}}"
+)
+
+// coordinateData is what a coordinate template can refer to.
+type coordinateData struct {
+	Name          string // bundle name from bundle.yaml
+	Version       string
+	GroupId       string // merged Java group_id
+	PackagePrefix string // lake.yaml python.package_prefix
+	PackageScope  string // lake.yaml javascript.package_scope, always with its leading "@"
+}
+
+// namingFuncs are the functions coordinate templates can pipe through.
+var namingFuncs = template.FuncMap{
+	"snake": func(s string) string { return strings.Join(nameWords(s), "_") },
+	"kebab": func(s string) string { return strings.Join(nameWords(s), "-") },
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// parseCoordinateTemplate parses text as a coordinate template.
+func parseCoordinateTemplate(text string) (*template.Template, error) {
+	return template.New("coordinate").Funcs(namingFuncs).Parse(text)
+}
+
+// expandCoordinate renders coordinate template text for data.
+func expandCoordinate(text string, data coordinateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := parseCoordinateTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// expandCoordinates derives merged's Java artifact_id, Python/JS package
+// names and BSR module from their templates, after the lake defaults and
+// bundle overrides are merged. With a lake package_prefix or package_scope,
+// an unset package name defaults to the matching default template.
+// Templates are checked when the lake's config is validated, so expanding
+// one only fails on a bug.
+func expandCoordinates(merged *MergedConfig, lakeConfig *LakeConfig) {
+	data := coordinateData{
+		Name:    merged.BundleName,
+		Version: merged.Version,
+		GroupId: merged.JavaConfig.GroupId,
+	}
+	if lakeConfig != nil {
+		data.PackagePrefix = lakeConfig.Config.LanguageDefaults.Python.PackagePrefix
+		data.PackageScope = packageScope(lakeConfig.Config.LanguageDefaults.Javascript.PackageScope)
+	}
+	if merged.PythonConfig.PackageName == "" && data.PackagePrefix != "" {
+		merged.PythonConfig.PackageName = defaultPythonPackageTemplate
+	}
+	if merged.JavaScriptConfig.PackageName == "" && data.PackageScope != "" {
+		merged.JavaScriptConfig.PackageName = defaultJSPackageTemplate
+	}
+
+	for _, c := range []struct {
+		field string
+		value *string
+	}{
+		{"java artifact_id", &merged.JavaConfig.ArtifactId},
+		{"python package_name", &merged.PythonConfig.PackageName},
+		{"javascript package_name", &merged.JavaScriptConfig.PackageName},
+		{"buf module", &merged.BufConfig.Module},
+	} {
+		expanded, err := expandCoordinate(*c.value, data)
+		if err != nil {
+			log.Fatalf("[protolake-gazelle] bundle %q: cannot expand %s template %q: %v",
+				merged.BundleName, c.field, *c.value, err)
+		}
+		*c.value = expanded
+	}
+}
+
+// unknownFieldPattern picks the field name out of text/template's error for
+// a reference to a field coordinateData doesn't have.
+var unknownFieldPattern = regexp.MustCompile(`can't evaluate field (\w+)`)
+
+// checkCoordinateTemplate reports whether text parses and only refers to
+// fields coordinateData has.
+func checkCoordinateTemplate(text string) error {
+	_, err := expandCoordinate(text, coordinateData{Name: "bundle"})
+	if err == nil {
+		return nil
+	}
+	if m := unknownFieldPattern.FindStringSubmatch(err.Error()); m != nil {
+		return fmt.Errorf("invalid template: unknown field .%s (known: .Name, .Version, .GroupId, .PackagePrefix, .PackageScope)", m[1])
+	}
+	return fmt.Errorf("invalid template: %s", strings.TrimPrefix(err.Error(), "template: "))
+}
+
+// nameWords splits a bundle name into lower-case words at separators and
+// camel-case boundaries: "userService", "user-service" and "User_Service"
+// all give [user service].
+func nameWords(s string) []string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+			continue
+		case unicode.IsUpper(r) && i > 0 && len(word) > 0:
+			// A new word starts at "xY", or at the last capital of "XYz".
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				flush()
+			}
+		}
+		word = append(word, r)
+	}
+	flush()
+	return words
+}
+
+// packageScope normalizes a lake.yaml package_scope to its "@scope" form.
+func packageScope(scope string) string {
+	if scope == "" || strings.HasPrefix(scope, "@") {
+		return scope
+	}
+	return "@" + scope
+}
@@ -4,8 +4,8 @@ import (
 	"flag"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
@@ -21,19 +21,66 @@ const (
 	buildBazelFile = "BUILD.bazel"
 	buildFile      = "BUILD"
 	bundleYamlFile = "bundle.yaml"
+	groupYamlFile  = "group.yaml"
 	lakeYamlFile   = "lake.yaml"
 	bazelDirPrefix = "bazel-"
 )
 
-var protoLibraryPattern = regexp.MustCompile(`proto_library\s*\(\s*[^)]*name\s*=\s*"([^"]+)"[^)]*\)`)
-
 // protolakeExtension implements the Gazelle language.Language interface
 // for generating protolake bundle rules with hybrid publishing support
-type protolakeExtension struct{}
+type protolakeExtension struct {
+	// protoPackages records, per package (slash-separated rel, "" for the
+	// root), the proto_library rules the package holds once this pass is
+	// written — its BUILD file merged with the proto extension's output (see
+	// effectiveProtoLibraries). Gazelle visits subdirectories before their
+	// parents, so a bundle directory sees its whole subtree here, including
+	// packages whose proto_library rules don't exist on disk yet.
+	protoPackages map[string][]protoLibraryInfo
+
+	// index is the repo-wide import index, built once per run on first use.
+	index *protoImportIndex
+
+	// bundleProvides maps a bundle package to the proto import paths its
+	// protos provide, advertised on its bundle rules (see Imports).
+	bundleProvides map[string][]string
+
+	// bundleConfigs maps a bundle package to its merged configuration: bundles
+	// generated this pass, plus bundles outside the walk loaded on demand
+	// (see bundleConfig). Artifact-mode dependencies are computed from it.
+	bundleConfigs map[string]*MergedConfig
+
+	// lakeBundles maps a lake root package to the bundles generated under it
+	// this pass, by package, with their Resolve payloads: what the root's
+	// publish_all targets release (see generateLakePublishRules).
+	lakeBundles map[string]map[string]*bundleImports
+
+	// lakeValidated is set once validateLake has checked the lake's config
+	// files this run.
+	lakeValidated bool
+
+	// check is set by -protolake_check: report stale bundle BUILD files and
+	// fail instead of rewriting them. checks holds each bundle's snapshot.
+	check  bool
+	checks []*bundleCheck
+
+	// graph is the -protolake_graph output path, without extension, and
+	// graphBundles what the export needs of each bundle generated.
+	graph        string
+	graphBundles []*graphRecord
+
+	// excludes holds repo-relative `gazelle:exclude` patterns (-exclude flag
+	// and directives) that the import index walk honors.
+	excludes []string
+}
 
 func NewLanguage() language.Language {
 	log.Printf("[protolake-gazelle] NewLanguage() called - extension initialized")
-	return &protolakeExtension{}
+	return &protolakeExtension{
+		protoPackages:  make(map[string][]protoLibraryInfo),
+		bundleProvides: make(map[string][]string),
+		bundleConfigs:  make(map[string]*MergedConfig),
+		lakeBundles:    make(map[string]map[string]*bundleImports),
+	}
 }
 
 func (pe *protolakeExtension) Name() string {
@@ -47,6 +94,13 @@ func (pe *protolakeExtension) RegisterFlags(fs *flag.FlagSet, cmd string, c *con
 		enabled: true,
 	}
 	c.Exts[protolakeName] = pc
+
+	if fs != nil {
+		fs.BoolVar(&pe.check, checkFlag, false,
+			"protolake: fail with a per-bundle diff instead of updating bundle BUILD files that are out of date")
+		fs.StringVar(&pe.graph, graphFlag, "",
+			"protolake: write the bundle dependency graph to this path's .json and .dot files, relative to the repo root")
+	}
 }
 
 // KnownDirectives returns the list of directives recognized by this extension
@@ -68,6 +122,8 @@ func (pe *protolakeExtension) Configure(c *config.Config, rel string, f *rule.Fi
 	// Check for directive to enable/disable
 	for _, d := range f.Directives {
 		switch d.Key {
+		case excludeDirective:
+			pe.excludes = append(pe.excludes, path.Join(rel, d.Value))
 		case "protolake":
 			if pc, ok := c.Exts[protolakeName].(*protolakeConfig); ok {
 				pc.enabled = d.Value == "true"
@@ -85,8 +141,13 @@ func getProtolakeConfig(c *config.Config) *protolakeConfig {
 	return pc
 }
 
-// GenerateRules generates bundle rules for directories containing bundle.yaml
+// GenerateRules generates bundle rules for directories containing bundle.yaml,
+// and the lake's publish_all targets in the directory containing lake.yaml.
 func (pe *protolakeExtension) GenerateRules(args language.GenerateArgs) language.GenerateResult {
+	// Record every package, bundle or not and enabled or not: bundles above
+	// this directory discover their targets and resolve imports from it.
+	pe.recordProtoPackage(args)
+
 	pc := getProtolakeConfig(args.Config)
 	if !pc.enabled {
 		return language.GenerateResult{}
@@ -95,6 +156,22 @@ func (pe *protolakeExtension) GenerateRules(args language.GenerateArgs) language
 	// Debug logging
 	log.Printf("[protolake-gazelle] GenerateRules called for dir: %s, rel: %s", args.Dir, args.Rel)
 
+	result := pe.generateBundle(args)
+	if isLakeRoot(args.Dir) {
+		lake := pe.generateLakePublishRules(args)
+		if pe.check {
+			pe.recordBundleCheck(args, "", lake.Gen, lake.Empty)
+		}
+		result.Gen = append(result.Gen, lake.Gen...)
+		result.Empty = append(result.Empty, lake.Empty...)
+		result.Imports = append(result.Imports, lake.Imports...)
+	}
+	return result
+}
+
+// generateBundle generates the rules of the bundle in args' directory, if
+// it holds a bundle.yaml.
+func (pe *protolakeExtension) generateBundle(args language.GenerateArgs) language.GenerateResult {
 	// Check if this directory has a bundle.yaml file
 	bundleYamlPath := filepath.Join(args.Dir, bundleYamlFile)
 	if _, err := os.Stat(bundleYamlPath); os.IsNotExist(err) {
@@ -103,11 +180,14 @@ func (pe *protolakeExtension) GenerateRules(args language.GenerateArgs) language
 
 	log.Printf("[protolake-gazelle] Found bundle.yaml at: %s", bundleYamlPath)
 
+	// Check every lake.yaml and bundle.yaml up front, reporting all problems
+	// in one failure.
+	pe.validateLake(args.Config)
+
 	// Load lake configuration (walks up directory tree)
 	lakeConfig, err := LoadLakeConfig(args.Dir)
 	if err != nil {
-		log.Printf("Failed to load lake configuration: %v", err)
-		return language.GenerateResult{}
+		log.Fatalf("[protolake-gazelle] failed to load lake configuration for bundle dir %s:\n%v", args.Dir, err)
 	}
 
 	// Fail fast if there's no lake.yaml. A bundle.yaml outside a lake is a
@@ -128,8 +208,7 @@ func (pe *protolakeExtension) GenerateRules(args language.GenerateArgs) language
 	// Load bundle configuration
 	bundleConfig, err := LoadBundleConfig(args.Dir)
 	if err != nil {
-		log.Printf("Failed to load bundle configuration: %v", err)
-		return language.GenerateResult{}
+		log.Fatalf("[protolake-gazelle] failed to load bundle configuration:\n%v", err)
 	}
 
 	if bundleConfig == nil {
@@ -137,8 +216,15 @@ func (pe *protolakeExtension) GenerateRules(args language.GenerateArgs) language
 		return language.GenerateResult{}
 	}
 
-	// Merge lake and bundle configurations
-	mergedConfig := MergeConfigurations(lakeConfig, bundleConfig)
+	// Load the group.yaml layers between the lake root and the bundle
+	groupConfigs, err := LoadGroupConfigs(args.Config.RepoRoot, args.Dir)
+	if err != nil {
+		log.Fatalf("[protolake-gazelle] failed to load group configuration for bundle dir %s:\n%v", args.Dir, err)
+	}
+
+	// Merge lake, group and bundle configurations
+	mergedConfig := MergeConfigurationLayers(lakeConfig, groupConfigs, bundleConfig)
+	pe.bundleConfigs[args.Rel] = mergedConfig
 
 	log.Printf("Processing bundle: %s at %s", mergedConfig.BundleName, args.Rel)
 
@@ -151,8 +237,18 @@ func (pe *protolakeExtension) GenerateRules(args language.GenerateArgs) language
 
 	log.Printf("Found %d proto targets for bundle %s: %v", len(protoTargets), mergedConfig.BundleName, protoTargets)
 
+	retainAggregateRule(args.OtherEmpty, mergedConfig.BundleName+"_all_protos")
+
 	// Generate bundle rules using the merged configuration
-	rules := generateBundleRules(mergedConfig, protoTargets, args.Rel, args.Config)
+	var goMod *goModule
+	if mergedConfig.GoConfig.Enabled {
+		goMod = pe.goModuleFor(args.Config, args.Rel, mergedConfig, protoTargets)
+	}
+	rules := generateBundleRules(mergedConfig, protoTargets, args.Rel, args.Config, goMod)
+
+	// Advertise the imports this bundle's protos provide on its bundle rules
+	// (see Imports).
+	pe.bundleProvides[args.Rel] = pe.providedImports(args.Config, args.Rel, protoTargets)
 
 	log.Printf("Generated %d rules for bundle %s", len(rules), mergedConfig.BundleName)
 
@@ -162,16 +258,44 @@ func (pe *protolakeExtension) GenerateRules(args language.GenerateArgs) language
 		gen = append(gen, r)
 	}
 
-	// Create empty imports (we don't track imports for bundle rules)
+	// The compile and publish rules carry the bundle's proto imports; Resolve
+	// turns them into the cross-bundle targets the compile rules also need
+	// and, in artifact mode, the dependencies the publish rules declare.
+	// Gazelle hands each rule's payload to the language owning its kind, so
+	// the aggregate proto_library (resolved by the proto extension) gets none.
+	// A Go package compiled in from outside the bundle carries the imports
+	// its protos resolve from (see resolveGoPackage).
+	payload := &bundleImports{config: mergedConfig, imports: collectBundleImports(args.Dir, mergedConfig.ExternalProtos)}
+	compiledIn := make(map[string]*goPackage)
+	if goMod != nil {
+		for _, p := range goMod.packages {
+			if len(p.imports) > 0 {
+				compiledIn[p.name] = p
+			}
+		}
+	}
 	imports := make([]interface{}, len(gen))
-	for i := range imports {
-		imports[i] = nil
+	for i, r := range gen {
+		if p, ok := compiledIn[r.Name()]; ok && r.Kind() == "go_proto_library" {
+			imports[i] = p
+		} else if resolvedKinds[r.Kind()] {
+			imports[i] = payload
+		}
+	}
+	pe.recordLakeBundle(args.Config, args.Rel, payload)
+	if pe.graph != "" {
+		pe.recordGraphBundle(args, mergedConfig, protoTargets, payload.imports)
 	}
 
 	// Signal deletion of legacy rules replaced by migrations, plus stale rules
 	// for languages this bundle has disabled (or never enabled).
 	emptyRules := generateLegacyCleanupRules(mergedConfig)
 	emptyRules = append(emptyRules, generateDisabledLanguageCleanupRules(mergedConfig)...)
+	emptyRules = append(emptyRules, staleGoPackageRules(args.File, mergedConfig.BundleName, gen)...)
+
+	if pe.check {
+		pe.recordBundleCheck(args, mergedConfig.BundleName, gen, emptyRules)
+	}
 
 	return language.GenerateResult{
 		Gen:     gen,
@@ -180,6 +304,51 @@ func (pe *protolakeExtension) GenerateRules(args language.GenerateArgs) language
 	}
 }
 
+// retainAggregateRule keeps the proto extension from deleting the bundle's
+// aggregate proto_library. That extension emits an Empty stub for every
+// proto_library without srcs, and since srcs is its only non-empty attr, the
+// stub deletes the aggregate during the post-resolve merge — after this
+// extension regenerated it, and gazelle never revives a deleted rule. A
+// combined `-lang=proto,protolake` run would drop the aggregate on every other
+// pass. Clearing the stub's name leaves it matching no rule.
+func retainAggregateRule(otherEmpty []*rule.Rule, aggregateName string) {
+	for _, r := range otherEmpty {
+		if r.Kind() == protoLibraryKind && r.Name() == aggregateName {
+			r.SetName("")
+		}
+	}
+}
+
+// recordProtoPackage stores the proto_library rules args' package will hold
+// after this pass.
+func (pe *protolakeExtension) recordProtoPackage(args language.GenerateArgs) {
+	if pe.protoPackages == nil {
+		pe.protoPackages = make(map[string][]protoLibraryInfo)
+	}
+	libs := effectiveProtoLibraries(args)
+	pe.protoPackages[args.Rel] = libs
+	if pe.index != nil {
+		pe.index.setPackage(args.Rel, libs)
+	}
+}
+
+// protoLibrariesIn returns the proto_library rules of the package at dir:
+// the in-memory view when gazelle has already visited it this pass, otherwise
+// its BUILD file on disk (directories outside the walk, e.g. a partial
+// `gazelle <subdir>` run).
+func (pe *protolakeExtension) protoLibrariesIn(c *config.Config, dir string) []protoLibraryInfo {
+	if rel, err := filepath.Rel(c.RepoRoot, dir); err == nil {
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+		if libs, ok := pe.protoPackages[rel]; ok {
+			return libs
+		}
+	}
+	return loadProtoLibraries(c, dir)
+}
+
 // discoverExistingProtoTargets finds proto_library targets in the current directory and subdirectories
 // This enhanced version searches recursively to support bundles with protos in subdirectories.
 // The bundle's own aggregated rule (<bundle>_all_protos) is excluded from the bundle-dir scan:
@@ -193,10 +362,10 @@ func (pe *protolakeExtension) discoverExistingProtoTargets(args language.Generat
 	aggregateRuleName := bundleName + "_all_protos"
 
 	// First, check for protos in the current directory (bundle.yaml directory)
-	targets = append(targets, pe.discoverProtoTargetsInDirectory(args.Dir, args.Config.RepoRoot, aggregateRuleName)...)
+	targets = append(targets, pe.discoverProtoTargetsInDirectory(args.Config, args.Dir, aggregateRuleName)...)
 
 	// Then recursively search subdirectories for additional proto targets
-	subdirTargets := pe.discoverProtoTargetsRecursively(args.Dir, args.Config.RepoRoot)
+	subdirTargets := pe.discoverProtoTargetsRecursively(args.Config, args.Dir)
 	targets = append(targets, subdirTargets...)
 
 	log.Printf("Discovered %d total proto targets for bundle: %v", len(targets), targets)
@@ -204,54 +373,36 @@ func (pe *protolakeExtension) discoverExistingProtoTargets(args language.Generat
 }
 
 // discoverProtoTargetsInDirectory finds proto_library targets in a specific directory,
-// skipping any rule named skipRuleName. Callers pass the bundle's generated aggregate
-// name when scanning the bundle's own directory and "" (no skip) everywhere else.
-func (pe *protolakeExtension) discoverProtoTargetsInDirectory(dir string, repoRoot string, skipRuleName string) []string {
+// skipping any rule named skipRuleName and any rule tagged no-protolake. Callers
+// pass the bundle's generated aggregate name when scanning the bundle's own
+// directory and "" (no skip) everywhere else. Rules come from this pass's
+// in-memory view of the package (see protoLibrariesIn), so proto_library rules
+// the proto extension is generating right now are found without a second run.
+func (pe *protolakeExtension) discoverProtoTargetsInDirectory(c *config.Config, dir string, skipRuleName string) []string {
 	var targets []string
 
-	// Look for existing BUILD file
-	bf := filepath.Join(dir, buildBazelFile)
-	if _, err := os.Stat(bf); os.IsNotExist(err) {
-		bf = filepath.Join(dir, buildFile)
-	}
-
-	if _, err := os.Stat(bf); os.IsNotExist(err) {
-		log.Printf("No BUILD file found in %s", dir)
-		return targets
-	}
+	for _, lib := range pe.protoLibrariesIn(c, dir) {
+		name := lib.Name
 
-	// Read BUILD file and parse proto_library rules
-	content, err := os.ReadFile(bf)
-	if err != nil {
-		log.Printf("Failed to read BUILD file %s: %v", bf, err)
-		return targets
-	}
-
-	buildContent := string(content)
-
-	// Use regex to find proto_library rules with proper multi-line support
-	matches := protoLibraryPattern.FindAllStringSubmatch(buildContent, -1)
-
-	for _, match := range matches {
-		if len(match) > 1 {
-			name := match[1]
-
-			if skipRuleName != "" && name == skipRuleName {
-				continue
-			}
+		if skipRuleName != "" && name == skipRuleName {
+			continue
+		}
+		if lib.hasTag(noProtolakeTag) {
+			log.Printf("Skipping proto_library %s in %s (tagged %s)", name, dir, noProtolakeTag)
+			continue
+		}
 
-			// Determine the correct target format based on directory relationship
-			pkg, err := filepath.Rel(repoRoot, dir)
-			if err != nil || pkg == "." {
-				// Same directory as bundle.yaml - use local reference
-				targets = append(targets, ":"+name)
-				log.Printf("Found local proto_library target: %s", name)
-			} else {
-				// Subdirectory - use full package reference
-				fullTarget := "//" + pkg + ":" + name
-				targets = append(targets, fullTarget)
-				log.Printf("Found subdirectory proto_library target: %s", fullTarget)
-			}
+		// Determine the correct target format based on directory relationship
+		pkg, err := filepath.Rel(c.RepoRoot, dir)
+		if err != nil || pkg == "." {
+			// Same directory as bundle.yaml - use local reference
+			targets = append(targets, ":"+name)
+			log.Printf("Found local proto_library target: %s", name)
+		} else {
+			// Subdirectory - use full package reference
+			fullTarget := "//" + filepath.ToSlash(pkg) + ":" + name
+			targets = append(targets, fullTarget)
+			log.Printf("Found subdirectory proto_library target: %s", fullTarget)
 		}
 	}
 
@@ -259,10 +410,15 @@ func (pe *protolakeExtension) discoverProtoTargetsInDirectory(dir string, repoRo
 }
 
 // discoverProtoTargetsRecursively finds proto_library targets in all subdirectories.
-// BUILD files sitting in bundleDir itself are excluded — the caller already scanned
-// that directory (with the bundle's generated aggregate skipped); rescanning it here
-// would duplicate its targets and re-discover the aggregate without the skip.
-func (pe *protolakeExtension) discoverProtoTargetsRecursively(bundleDir string, repoRoot string) []string {
+// Every directory is visited, not just those with a BUILD file: a brand-new
+// subdirectory only has proto rules in memory until gazelle writes it out.
+// bundleDir itself is excluded — the caller already scanned that directory
+// (with the bundle's generated aggregate skipped); rescanning it here would
+// duplicate its targets and re-discover the aggregate without the skip.
+// A subdirectory holding its own bundle.yaml is a nested bundle: its subtree
+// is that bundle's to publish, and the outer bundle's imports of it resolve
+// to it as to any other bundle.
+func (pe *protolakeExtension) discoverProtoTargetsRecursively(c *config.Config, bundleDir string) []string {
 	var targets []string
 
 	// Walk through all subdirectories
@@ -276,21 +432,22 @@ func (pe *protolakeExtension) discoverProtoTargetsRecursively(bundleDir string,
 			return nil
 		}
 
+		if !info.IsDir() {
+			return nil
+		}
+
 		// Skip bazel output directories
-		if info.IsDir() && strings.HasPrefix(info.Name(), bazelDirPrefix) {
+		if strings.HasPrefix(info.Name(), bazelDirPrefix) {
 			return filepath.SkipDir
 		}
 
-		// Process BUILD files in subdirectories only
-		if info.Name() == buildBazelFile || info.Name() == buildFile {
-			dir := filepath.Dir(path)
-			if dir == bundleDir {
-				return nil
-			}
-			dirTargets := pe.discoverProtoTargetsInDirectory(dir, repoRoot, "")
-			targets = append(targets, dirTargets...)
+		if isBundleDir(path) {
+			log.Printf("Skipping nested bundle at %s", path)
+			return filepath.SkipDir
 		}
 
+		dirTargets := pe.discoverProtoTargetsInDirectory(c, path, "")
+		targets = append(targets, dirTargets...)
 		return nil
 	})
 
@@ -307,10 +464,13 @@ func (pe *protolakeExtension) KindInfo() map[string]rule.KindInfo {
 	return map[string]rule.KindInfo{
 		// On the bundle kinds, every generated attr is mergeable:
 		//   - `bundle_yaml` is the label the bundlers read the version from at
-		//     build time. `version` is no longer emitted but stays mergeable:
-		//     gazelle only deletes an existing attr that the generated rule
-		//     omits when that attr is mergeable, so this is what strips the
-		//     stale baked `version = "X"` from pre-PL-bstm BUILD files.
+		//     build time, and `version_file` its replacement when the version
+		//     comes from the build (see setBundleVersion); a bundle changing
+		//     its version_source swaps one for the other. `version` is no
+		//     longer emitted but stays mergeable: gazelle only deletes an
+		//     existing attr that the generated rule omits when that attr is
+		//     mergeable, so this is what strips the stale baked
+		//     `version = "X"` from pre-PL-bstm BUILD files.
 		//   - the NonEmptyAttrs must ALSO be mergeable so that the
 		//     disabled-language cleanup works: gazelle deletes a rule matched
 		//     by an Empty rule only once the merge has dropped every
@@ -336,9 +496,23 @@ func (pe *protolakeExtension) KindInfo() map[string]rule.KindInfo {
 				"descriptor_pb":  true,
 				"bundle_name":    true,
 				"bundle_yaml":    true,
+				"version_file":   true,
 				"version":        true,
 			},
 		},
+		"java_source_bundle": {
+			// See java_proto_bundle for why every generated attr is mergeable.
+			// proto_deps and java_grpc_deps are the attrs of earlier versions;
+			// mergeable, so regeneration drops them.
+			NonEmptyAttrs: map[string]bool{
+				"srcjars": true,
+			},
+			MergeableAttrs: map[string]bool{
+				"srcjars":        true,
+				"proto_deps":     true,
+				"java_grpc_deps": true,
+			},
+		},
 		"py_proto_bundle": {
 			NonEmptyAttrs: map[string]bool{
 				"package_name": true,
@@ -347,12 +521,14 @@ func (pe *protolakeExtension) KindInfo() map[string]rule.KindInfo {
 				"py_grpc_deps": true,
 			},
 			MergeableAttrs: map[string]bool{
-				"package_name": true,
-				"proto_deps":   true,
-				"py_deps":      true,
-				"py_grpc_deps": true,
-				"bundle_yaml":  true,
-				"version":      true,
+				"package_name":    true,
+				"proto_deps":      true,
+				"py_deps":         true,
+				"py_grpc_deps":    true,
+				"python_requires": true,
+				"bundle_yaml":     true,
+				"version_file":    true,
+				"version":         true,
 			},
 		},
 		"js_proto_bundle": {
@@ -366,9 +542,38 @@ func (pe *protolakeExtension) KindInfo() map[string]rule.KindInfo {
 				"proto_deps":   true,
 				"es_deps":      true,
 				"bundle_yaml":  true,
+				"version_file": true,
 				"version":      true,
 			},
 		},
+		"go_proto_bundle": {
+			// See java_proto_bundle for why every generated attr is mergeable.
+			NonEmptyAttrs: map[string]bool{
+				"module_path": true,
+				"proto_deps":  true,
+				"go_deps":     true,
+			},
+			MergeableAttrs: map[string]bool{
+				"module_path":  true,
+				"proto_deps":   true,
+				"go_deps":      true,
+				"requires":     true,
+				"bundle_yaml":  true,
+				"version_file": true,
+				"version":      true,
+			},
+		},
+		"buf_proto_bundle": {
+			// See java_proto_bundle for why every generated attr is mergeable.
+			NonEmptyAttrs: map[string]bool{
+				"proto_deps": true,
+				"buf_yaml":   true,
+			},
+			MergeableAttrs: map[string]bool{
+				"proto_deps": true,
+				"buf_yaml":   true,
+			},
+		},
 		"es_proto_compile": {
 			NonEmptyAttrs: map[string]bool{
 				"protos": true,
@@ -381,6 +586,8 @@ func (pe *protolakeExtension) KindInfo() map[string]rule.KindInfo {
 				"protos":     true,
 				"visibility": true,
 			},
+			// Resolve appends cross-bundle proto targets after generation.
+			ResolveAttrs: map[string]bool{"protos": true},
 		},
 		// java_grpc_library + python_grpc_library live in @rules_proto_grpc_{java,python}
 		// but we generate them from this extension and want to merge our attrs into
@@ -393,8 +600,12 @@ func (pe *protolakeExtension) KindInfo() map[string]rule.KindInfo {
 			MergeableAttrs: map[string]bool{
 				"protos":     true,
 				"deps":       true,
+				"javacopts":  true,
 				"visibility": true,
 			},
+			// Resolve appends cross-bundle proto targets after generation, and
+			// in artifact mode the other bundles' java_grpc libraries to deps.
+			ResolveAttrs: map[string]bool{"protos": true, "deps": true},
 		},
 		"python_grpc_library": {
 			NonEmptyAttrs: map[string]bool{
@@ -405,6 +616,26 @@ func (pe *protolakeExtension) KindInfo() map[string]rule.KindInfo {
 				"deps":       true,
 				"visibility": true,
 			},
+			// Resolve appends cross-bundle proto targets after generation.
+			ResolveAttrs: map[string]bool{"protos": true},
+		},
+		// go_proto_library lives in @io_bazel_rules_go; registered for the same
+		// reason as the rules_proto_grpc kinds above. `importpath` merges so a
+		// module_path/import_path_prefix edit in bundle.yaml propagates.
+		"go_proto_library": {
+			NonEmptyAttrs: map[string]bool{
+				"protos": true,
+			},
+			MergeableAttrs: map[string]bool{
+				"protos":     true,
+				"deps":       true,
+				"importpath": true,
+				"compilers":  true,
+				"visibility": true,
+			},
+			// Resolve fills in the protos of a package compiled in from
+			// outside the bundle (see resolveGoPackage).
+			ResolveAttrs: map[string]bool{"protos": true},
 		},
 		"proto_descriptor_set": {
 			NonEmptyAttrs: map[string]bool{
@@ -430,6 +661,7 @@ func (pe *protolakeExtension) KindInfo() map[string]rule.KindInfo {
 				"package_name": true,
 				"proto_deps":   true,
 				"bundle_yaml":  true,
+				"version_file": true,
 				"version":      true,
 			},
 		},
@@ -446,17 +678,29 @@ func (pe *protolakeExtension) KindInfo() map[string]rule.KindInfo {
 		},
 		// Publish-rule kinds — emitted by generateJavaBundleRules /
 		// generatePythonBundleRules / generateJavaScriptBundleRules /
-		// generateProtoLoaderBundleRules.
+		// generateGoBundleRules / generateProtoLoaderBundleRules.
 		"maven_publish": {
 			NonEmptyAttrs: map[string]bool{
 				"coordinates": true,
 				"artifact":    true,
 			},
 			MergeableAttrs: map[string]bool{
-				"coordinates": true,
-				"pom":         true,
-				"artifact":    true,
-				"visibility":  true,
+				"coordinates":          true,
+				"pom":                  true,
+				"artifact":             true,
+				"classifier_artifacts": true,
+				"toolchains":           true,
+				"visibility":           true,
+			},
+		},
+		// rules_jvm_external's javadoc, for the -javadoc classifier JAR.
+		"javadoc": {
+			NonEmptyAttrs: map[string]bool{
+				"deps": true,
+			},
+			MergeableAttrs: map[string]bool{
+				"deps":        true,
+				"javadocopts": true,
 			},
 		},
 		"py_binary": {
@@ -472,6 +716,38 @@ func (pe *protolakeExtension) KindInfo() map[string]rule.KindInfo {
 				"deps":       true,
 				"visibility": true,
 			},
+			// Resolve appends artifact-mode bundle dependencies to the pypi
+			// and npm publishers' args.
+			ResolveAttrs: map[string]bool{"args": true},
+		},
+		// The <bundle>_breaking_check test. baseline_version and the
+		// version attrs are mergeable so a bundle.yaml edit, or a switch of
+		// version_source, updates the test in place.
+		"proto_breaking_test": {
+			NonEmptyAttrs: map[string]bool{
+				"descriptor": true,
+				"baseline":   true,
+			},
+			MergeableAttrs: map[string]bool{
+				"descriptor":       true,
+				"baseline":         true,
+				"baseline_version": true,
+				"bundle_yaml":      true,
+				"version_file":     true,
+			},
+		},
+		// rules_multirun's multirun, for the lake root's publish_all targets.
+		// `commands` is listed in publish order, which Resolve fills in once
+		// every bundle's imports can be resolved.
+		"multirun": {
+			NonEmptyAttrs: map[string]bool{
+				"commands": true,
+			},
+			MergeableAttrs: map[string]bool{
+				"commands": true,
+				"jobs":     true,
+			},
+			ResolveAttrs: map[string]bool{"commands": true},
 		},
 		// `alias` is a built-in, registered so the disabled-language cleanup
 		// can delete a stale `publish_to_*` convenience alias — left behind,
@@ -523,6 +799,9 @@ func (pe *protolakeExtension) KindInfo() map[string]rule.KindInfo {
 				"outs": true,
 				"srcs": true,
 			},
+			// Resolve rebuilds the pom genrules' cmd with artifact-mode
+			// `--dependency` coordinates.
+			ResolveAttrs: map[string]bool{"cmd": true},
 		},
 	}
 }
@@ -553,17 +832,29 @@ func (pe *protolakeExtension) Loads() []rule.LoadInfo {
 			Name:    "@rules_jvm_external//private/rules:maven_publish.bzl",
 			Symbols: []string{"maven_publish"},
 		},
+		{
+			Name:    "@rules_jvm_external//:defs.bzl",
+			Symbols: []string{"javadoc"},
+		},
+		{
+			Name:    "@io_bazel_rules_go//proto:def.bzl",
+			Symbols: []string{"go_proto_library"},
+		},
 		{
 			Name:    "@rules_python//python:defs.bzl",
 			Symbols: []string{"py_binary"},
 		},
+		{
+			Name:    "@rules_multirun//:defs.bzl",
+			Symbols: []string{"multirun"},
+		},
 		{
 			Name:    "//tools:es_proto.bzl",
 			Symbols: []string{"es_proto_compile"},
 		},
 		{
 			Name:    "//tools:proto_bundle.bzl",
-			Symbols: []string{"build_validation", "java_proto_bundle", "py_proto_bundle", "js_proto_bundle", "proto_descriptor_set", "js_proto_loader_bundle"},
+			Symbols: []string{"build_validation", "java_proto_bundle", "java_source_bundle", "py_proto_bundle", "js_proto_bundle", "proto_descriptor_set", "js_proto_loader_bundle", "go_proto_bundle", "buf_proto_bundle", "proto_breaking_test"},
 		},
 		// Legacy load — kept so Gazelle can remove it when no rules reference these symbols
 		{
@@ -576,17 +867,69 @@ func (pe *protolakeExtension) Loads() []rule.LoadInfo {
 // Required interface methods with empty implementations
 func (pe *protolakeExtension) Fix(c *config.Config, f *rule.File) {}
 
+// Imports advertises, on each per-language bundle rule, the proto import
+// paths the bundle provides, so the RuleIndex can answer "which bundle ships
+// this .proto". The specs use the proto language's import namespace but are
+// recorded under this extension's name, so they never compete with the
+// proto_library rules the proto extension resolves against.
+//
+// The <bundle>_all_protos aggregate advertises nothing. Gazelle indexes a
+// rule through the extension owning its kind, and proto_library is the
+// proto extension's, which advertises a rule's srcs; the aggregate has
+// none. Claiming proto_library here would take the kind from the proto
+// extension and break the resolution of every proto_library in the run.
 func (pe *protolakeExtension) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
-	return nil
+	if !bundleRuleKinds[r.Kind()] || f == nil {
+		return nil
+	}
+	provides := pe.bundleProvides[f.Pkg]
+	if len(provides) == 0 {
+		return nil
+	}
+	specs := make([]resolve.ImportSpec, 0, len(provides))
+	for _, imp := range provides {
+		specs = append(specs, resolve.ImportSpec{Lang: protoLang, Imp: imp})
+	}
+	return specs
 }
 
 func (pe *protolakeExtension) Embeds(r *rule.Rule, from label.Label) []label.Label {
 	return nil
 }
 
+// Resolve appends to a compile rule's `protos` the proto_library targets that
+// its bundle's imports resolve to outside the bundle — cross-bundle and other
+// in-repo protos. Each import is resolved like the proto extension resolves
+// it: `# gazelle:resolve proto ...` overrides first, then the RuleIndex.
+// When the proto language isn't part of the run (e.g. `-lang=protolake`) the
+// RuleIndex holds no proto_library rules, so the repo import index is the
+// fallback. With `bundle_dependencies: artifact`, imports owned by another
+// bundle become artifact dependencies instead (see resolveBundleRule). The
+// same resolution orders the lake's publish_all targets (see publishOrder).
 func (pe *protolakeExtension) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+	switch payload := imports.(type) {
+	case *bundleImports:
+		if len(payload.imports) > 0 {
+			pe.resolveBundleRule(c, ix, r, payload, from)
+		}
+	case *goPackage:
+		pe.resolveGoPackage(c, ix, r, payload, from)
+	case *lakePublish:
+		pe.resolveLakePublishRule(c, ix, r, payload, from)
+	}
 }
 
 func (pe *protolakeExtension) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
+	// gazelle's walker owns -exclude; read it so the import index walk skips
+	// the same paths.
+	if fs == nil {
+		return nil
+	}
+	if f := fs.Lookup(excludeDirective); f != nil && f.Value.String() != "" {
+		pe.excludes = append(pe.excludes, strings.Split(f.Value.String(), ",")...)
+	}
+	if pe.graph != "" && !filepath.IsAbs(pe.graph) {
+		pe.graph = filepath.Join(c.RepoRoot, pe.graph)
+	}
 	return nil
 }
@@ -1,11 +1,24 @@
 package language
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/merger"
+	"github.com/bazelbuild/bazel-gazelle/repo"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -51,10 +64,13 @@ func TestKindsAndKindInfo(t *testing.T) {
 	// Test Kinds method
 	kinds := ext.Kinds()
 	expectedKinds := []string{
-		"java_proto_bundle", "py_proto_bundle", "js_proto_bundle",
+		"java_proto_bundle", "java_source_bundle", "javadoc",
+		"py_proto_bundle", "js_proto_bundle",
 		"es_proto_compile", "proto_descriptor_set", "js_proto_loader_bundle",
-		"build_validation",
-		"maven_publish", "py_binary", "alias",
+		"go_proto_bundle", "go_proto_library",
+		"buf_proto_bundle",
+		"build_validation", "proto_breaking_test",
+		"maven_publish", "py_binary", "alias", "multirun",
 		"js_grpc_library", "js_grpc_web_library",
 		"genrule",
 	}
@@ -86,7 +102,7 @@ func TestKindsAndKindInfo(t *testing.T) {
 	// Verify the bundle kinds merge `bundle_yaml` (the build-time version
 	// source) and still merge `version` — required so the stale baked
 	// version attr is deleted from pre-PL-bstm BUILD files on regenerate.
-	for _, kind := range []string{"java_proto_bundle", "py_proto_bundle", "js_proto_bundle", "js_proto_loader_bundle"} {
+	for _, kind := range []string{"java_proto_bundle", "py_proto_bundle", "js_proto_bundle", "js_proto_loader_bundle", "go_proto_bundle"} {
 		info, exists := kindInfo[kind]
 		if !exists {
 			t.Errorf("%s kind info not found", kind)
@@ -160,6 +176,7 @@ func TestCleanupRuleKindsRegistered(t *testing.T) {
 			JavaConfig:       JavaConfig{Enabled: true, GroupId: "g", ArtifactId: "a"},
 			PythonConfig:     PythonConfig{Enabled: true, PackageName: "p"},
 			JavaScriptConfig: JavaScriptConfig{Enabled: true, PackageName: "@d/p"},
+			GoConfig:         GoConfig{Enabled: true, ModulePath: "example.com/d", ImportPathPrefix: "example.com/d"},
 		},
 		{
 			BundleName:       "demo",
@@ -204,17 +221,31 @@ func TestGenerateDisabledLanguageCleanupRules(t *testing.T) {
 
 	want := map[string]string{
 		// python disabled
-		"demo_python_grpc":     "python_grpc_library",
-		"demo_py_bundle":       "py_proto_bundle",
-		"publish_demo_to_pypi": "py_binary",
-		"publish_to_pypi":      "alias",
+		"demo_python_grpc":              "python_grpc_library",
+		"demo_py_bundle":                "py_proto_bundle",
+		"publish_demo_to_pypi":          "py_binary",
+		"publish_demo_to_pypi_local":    "py_binary",
+		"publish_demo_to_pypi_snapshot": "py_binary",
+		"publish_to_pypi":               "alias",
 		// javascript disabled (incl. the proto-loader pair)
 		"demo_es_proto":                    "es_proto_compile",
 		"demo_js_bundle":                   "js_proto_bundle",
 		"publish_demo_to_npm":              "py_binary",
+		"publish_demo_to_npm_local":        "py_binary",
+		"publish_demo_to_npm_snapshot":     "py_binary",
 		"publish_to_npm":                   "alias",
 		"demo_proto_loader_bundle":         "js_proto_loader_bundle",
 		"publish_demo_proto_loader_to_npm": "py_binary",
+		// go disabled
+		"demo_go_proto":           "go_proto_library",
+		"demo_go_bundle":          "go_proto_bundle",
+		"publish_demo_to_goproxy": "py_binary",
+		"publish_to_goproxy":      "alias",
+		// buf disabled
+		"demo_buf_yaml":       "genrule",
+		"demo_buf_bundle":     "buf_proto_bundle",
+		"publish_demo_to_buf": "py_binary",
+		"publish_to_buf":      "alias",
 	}
 
 	if len(got) != len(want) {
@@ -228,8 +259,9 @@ func TestGenerateDisabledLanguageCleanupRules(t *testing.T) {
 
 	// Java is enabled — none of its targets may be scheduled for deletion.
 	for _, name := range []string{
-		"demo_java_grpc", "demo_java_bundle", "demo_pom", "demo_pom_local",
-		"publish_demo_to_maven", "publish_demo_to_maven_local", "publish_to_maven",
+		"demo_java_grpc", "demo_java_bundle", "demo_java_sources", "demo_javadoc", "demo_pom", "demo_pom_local",
+		"demo_pom_snapshot", "publish_demo_to_maven", "publish_demo_to_maven_local", "publish_demo_to_maven_snapshot",
+		"publish_to_maven",
 	} {
 		if _, ok := got[name]; ok {
 			t.Errorf("cleanup rule emitted for ENABLED java target %s", name)
@@ -253,6 +285,7 @@ func TestGenerateDisabledLanguageCleanupRulesAllDisabled(t *testing.T) {
 		JavaConfig:       JavaConfig{Enabled: false},
 		PythonConfig:     PythonConfig{Enabled: false},
 		JavaScriptConfig: JavaScriptConfig{Enabled: false},
+		GoConfig:         GoConfig{Enabled: false},
 	}
 
 	empty := generateDisabledLanguageCleanupRules(config)
@@ -266,13 +299,18 @@ func TestGenerateDisabledLanguageCleanupRulesAllDisabled(t *testing.T) {
 		t.Errorf("expected Empty build_validation(\"all\") with all languages disabled, got kind %q", got["all"])
 	}
 
-	// All three languages' deterministic targets must be scheduled too.
+	// Every language's deterministic targets must be scheduled too.
 	for _, name := range []string{
-		"demo_java_grpc", "demo_java_bundle", "demo_pom", "demo_pom_local",
-		"publish_demo_to_maven", "publish_demo_to_maven_local", "publish_to_maven",
-		"demo_python_grpc", "demo_py_bundle", "publish_demo_to_pypi", "publish_to_pypi",
-		"demo_es_proto", "demo_js_bundle", "publish_demo_to_npm", "publish_to_npm",
+		"demo_java_grpc", "demo_java_bundle", "demo_java_sources", "demo_javadoc", "demo_pom", "demo_pom_local",
+		"demo_pom_snapshot", "publish_demo_to_maven", "publish_demo_to_maven_local", "publish_demo_to_maven_snapshot",
+		"publish_to_maven",
+		"demo_python_grpc", "demo_py_bundle", "publish_demo_to_pypi", "publish_demo_to_pypi_local",
+		"publish_demo_to_pypi_snapshot", "publish_to_pypi",
+		"demo_es_proto", "demo_js_bundle", "publish_demo_to_npm", "publish_demo_to_npm_local",
+		"publish_demo_to_npm_snapshot", "publish_to_npm",
 		"demo_proto_loader_bundle", "publish_demo_proto_loader_to_npm",
+		"demo_go_proto", "demo_go_bundle", "publish_demo_to_goproxy", "publish_to_goproxy",
+		"demo_buf_yaml", "demo_buf_bundle", "publish_demo_to_buf", "publish_to_buf",
 	} {
 		if _, ok := got[name]; !ok {
 			t.Errorf("expected cleanup rule for %s with all languages disabled", name)
@@ -290,9 +328,12 @@ func TestLoads(t *testing.T) {
 		"@rules_proto_grpc_java//:defs.bzl":                    {"java_grpc_library"},
 		"@rules_proto_grpc_python//:defs.bzl":                  {"python_grpc_library"},
 		"@rules_jvm_external//private/rules:maven_publish.bzl": {"maven_publish"},
+		"@rules_jvm_external//:defs.bzl":                       {"javadoc"},
+		"@io_bazel_rules_go//proto:def.bzl":                    {"go_proto_library"},
 		"@rules_python//python:defs.bzl":                       {"py_binary"},
+		"@rules_multirun//:defs.bzl":                           {"multirun"},
 		"//tools:es_proto.bzl":                                 {"es_proto_compile"},
-		"//tools:proto_bundle.bzl":                             {"build_validation", "java_proto_bundle", "py_proto_bundle", "js_proto_bundle", "proto_descriptor_set", "js_proto_loader_bundle"},
+		"//tools:proto_bundle.bzl":                             {"build_validation", "java_proto_bundle", "java_source_bundle", "py_proto_bundle", "js_proto_bundle", "proto_descriptor_set", "js_proto_loader_bundle", "go_proto_bundle", "buf_proto_bundle", "proto_breaking_test"},
 		"@rules_proto_grpc_js//:defs.bzl":                      {"js_grpc_library", "js_grpc_web_library"},
 	}
 
@@ -685,7 +726,7 @@ config:
 		}
 	})
 
-	// Test case 4: Empty bundle name (should return nil)
+	// Test case 4: Empty bundle name (an error, not a silently skipped bundle)
 	t.Run("EmptyBundleName", func(t *testing.T) {
 		tmpDir := t.TempDir()
 
@@ -704,8 +745,8 @@ config:
 		}
 
 		config, err := LoadBundleConfig(tmpDir)
-		if err != nil {
-			t.Errorf("Should not error for empty bundle name: %v", err)
+		if err == nil || !strings.Contains(err.Error(), "bundle.yaml:2:7: required field name is empty") {
+			t.Errorf("Expected a located error for the empty bundle name, got %v", err)
 		}
 
 		if config != nil {
@@ -713,7 +754,7 @@ config:
 		}
 	})
 
-	// Test case 5: Missing bundle name (should return nil)
+	// Test case 5: Missing bundle name (an error, not a silently skipped bundle)
 	t.Run("MissingBundleName", func(t *testing.T) {
 		tmpDir := t.TempDir()
 
@@ -731,8 +772,8 @@ config:
 		}
 
 		config, err := LoadBundleConfig(tmpDir)
-		if err != nil {
-			t.Errorf("Should not error for missing bundle name: %v", err)
+		if err == nil || !strings.Contains(err.Error(), "bundle.yaml:2:1: missing required field name") {
+			t.Errorf("Expected a located error for the missing bundle name, got %v", err)
 		}
 
 		if config != nil {
@@ -805,6 +846,133 @@ display_name: "Test Bundle"
 	})
 }
 
+// TestConfigSchemaValidation checks strict decoding reports each problem at
+// its file:line:column.
+func TestConfigSchemaValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{
+			name: "UnknownKey",
+			yaml: `name: "orders"
+config:
+  languages:
+    java:
+      group-id: "com.example"
+`,
+			want: []string{`bundle.yaml:5:7: unknown field "group-id" in config.languages.java (did you mean "group_id"?)`},
+		},
+		{
+			name: "WrongTypes",
+			yaml: `name: "orders"
+config:
+  generate_descriptor_set: sometimes
+  languages:
+    python: "yes"
+`,
+			want: []string{
+				`bundle.yaml:3:28: config.generate_descriptor_set must be true or false, got "sometimes"`,
+				`bundle.yaml:5:13: config.languages.python must be a mapping, got "yes"`,
+			},
+		},
+		{
+			name: "BadEnum",
+			yaml: `name: "orders"
+config:
+  dependency_mode: deep
+`,
+			want: []string{`bundle.yaml:3:20: config.dependency_mode must be one of "direct", "transitive", got "deep"`},
+		},
+		{
+			name: "BadTemplate",
+			yaml: `name: "orders"
+config:
+  languages:
+    java:
+      artifact_id: "{{.Nme}}-proto"
+`,
+			want: []string{`bundle.yaml:5:20: config.languages.java.artifact_id: invalid template: unknown field .Nme (known: .Name, .Version, .GroupId, .PackagePrefix, .PackageScope)`},
+		},
+		{
+			name: "DeveloperWithoutName",
+			yaml: `name: "orders"
+metadata:
+  developers:
+    - email: "orders@example.com"
+`,
+			want: []string{`bundle.yaml:4:7: missing required field metadata.developers[0].name`},
+		},
+		{
+			name: "BreakingCheckWithoutBaselineVersion",
+			yaml: `name: "orders"
+config:
+  breaking_check:
+    baseline: "orders.baseline.pb"
+`,
+			want: []string{`bundle.yaml:4:5: missing required field config.breaking_check.baseline_version`},
+		},
+		{
+			name: "SyntaxError",
+			yaml: "name: [\n",
+			want: []string{"bundle.yaml:1: did not find expected node content"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decodeConfig("bundle.yaml", []byte(tt.yaml), &BundleConfig{})
+			errs, ok := err.(ConfigErrors)
+			if !ok {
+				t.Fatalf("Expected ConfigErrors, got %v", err)
+			}
+			var got []string
+			for _, e := range errs {
+				got = append(got, e.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected errors:\n%s\ngot:\n%s", strings.Join(tt.want, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+// TestLakeConfigErrors checks every config file in the lake is validated and
+// the problems come back together, sorted by file.
+func TestLakeConfigErrors(t *testing.T) {
+	repoRoot := t.TempDir()
+	files := map[string]string{
+		"lake.yaml":                 "config:\n  bundle_dependencies: sometimes\n",
+		"com/orders/bundle.yaml":    "version: \"1.0.0\"\n",
+		"com/shared/bundle.yaml":    "name: shared\nversoin: \"1.0.0\"\n",
+		"com/fine/bundle.yaml":      "name: fine\nversion: \"1.0.0\"\n",
+		"bazel-out/bad/bundle.yaml": "nonsense: true\n",
+	}
+	for file, content := range files {
+		p := filepath.Join(repoRoot, file)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("Failed to create dir for %s: %v", file, err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", file, err)
+		}
+	}
+
+	ext := NewLanguage().(*protolakeExtension)
+	var got []string
+	for _, e := range ext.lakeConfigErrors(&config.Config{RepoRoot: repoRoot}) {
+		got = append(got, e.Error())
+	}
+	want := []string{
+		"com/orders/bundle.yaml:1:1: missing required field name",
+		`com/shared/bundle.yaml:2:1: unknown field "versoin" at the top level (did you mean "version"?)`,
+		`lake.yaml:2:24: config.bundle_dependencies must be one of "compile", "artifact", got "sometimes"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected errors:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
 func TestMergeConfigurations(t *testing.T) {
 	// Test with nil lake config
 	bundleConfig := &BundleConfig{}
@@ -855,57 +1023,2198 @@ func TestMergeConfigurations(t *testing.T) {
 	}
 }
 
-func TestBundleConfigStructure(t *testing.T) {
-	// Test that we can create the basic structures without file I/O
+func TestMergeConfigurationsBundleDependencies(t *testing.T) {
 	bundleConfig := &BundleConfig{}
-	bundleConfig.Name = "test-bundle"
-	bundleConfig.DisplayName = "Test Bundle"
-	bundleConfig.Description = "Test bundle"
+	bundleConfig.Name = "deps-bundle"
+
+	if merged := MergeConfigurations(nil, bundleConfig); merged.BundleDependencies != BundleDependenciesCompile {
+		t.Errorf("Expected bundle_dependencies to default to %q, got %q", BundleDependenciesCompile, merged.BundleDependencies)
+	}
+
+	lakeConfig := &LakeConfig{}
+	lakeConfig.Config.BundleDependencies = BundleDependenciesArtifact
+	if merged := MergeConfigurations(lakeConfig, bundleConfig); merged.BundleDependencies != BundleDependenciesArtifact {
+		t.Errorf("Expected bundle_dependencies inherited from lake.yaml, got %q", merged.BundleDependencies)
+	}
+
+	bundleConfig.Config.BundleDependencies = BundleDependenciesCompile
+	if merged := MergeConfigurations(lakeConfig, bundleConfig); merged.BundleDependencies != BundleDependenciesCompile {
+		t.Errorf("Expected bundle.yaml to override bundle_dependencies, got %q", merged.BundleDependencies)
+	}
+
+	bundleConfig.Config.DependencyMode = DependencyModeDirect
+	if merged := MergeConfigurations(lakeConfig, bundleConfig); merged.DependencyMode != DependencyModeDirect {
+		t.Errorf("Expected dependency_mode from bundle.yaml, got %q", merged.DependencyMode)
+	}
+}
+
+func TestMergeConfigurationsGo(t *testing.T) {
+	lakeConfig := &LakeConfig{}
+	lakeConfig.Config.LanguageDefaults.Go.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Go.ModulePath = "example.com/lake/proto"
+
+	// Bundle inherits enablement, overrides the module path and leaves the
+	// import path prefix unset — it must default to the module path.
+	bundleConfig := &BundleConfig{}
+	bundleConfig.Name = "go-bundle"
+	bundleConfig.Config.Languages.Go.ModulePath = "example.com/lake/go-bundle"
+
+	merged := MergeConfigurations(lakeConfig, bundleConfig)
+
+	if !merged.GoConfig.Enabled {
+		t.Error("Expected Go to inherit enabled from lake defaults")
+	}
+	if merged.GoConfig.ModulePath != "example.com/lake/go-bundle" {
+		t.Errorf("Expected Go module_path 'example.com/lake/go-bundle', got '%s'", merged.GoConfig.ModulePath)
+	}
+	if merged.GoConfig.ImportPathPrefix != "example.com/lake/go-bundle" {
+		t.Errorf("Expected Go import_path_prefix to default to the module path, got '%s'", merged.GoConfig.ImportPathPrefix)
+	}
+
+	// An explicit prefix wins, and an explicit false disables.
+	bundleConfig.Config.Languages.Go.Enabled = boolPtr(false)
+	bundleConfig.Config.Languages.Go.ImportPathPrefix = "example.com/lake/go-bundle/gen"
+
+	merged = MergeConfigurations(lakeConfig, bundleConfig)
+
+	if merged.GoConfig.Enabled {
+		t.Error("Expected Go to be disabled by explicit bundle override")
+	}
+	if merged.GoConfig.ImportPathPrefix != "example.com/lake/go-bundle/gen" {
+		t.Errorf("Expected Go import_path_prefix 'example.com/lake/go-bundle/gen', got '%s'", merged.GoConfig.ImportPathPrefix)
+	}
+}
+
+// TestGroupConfigLayers checks group.yaml files between the lake root and a
+// bundle apply outermost first, with the same tri-state overrides as
+// bundle.yaml, and bundle.yaml still has the last word.
+func TestGroupConfigLayers(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"group.yaml": `config:
+  languages:
+    java:
+      group_id: "com.outside"
+`,
+		"lake/lake.yaml": `config:
+  language_defaults:
+    java:
+      enabled: true
+      group_id: "com.example"
+    python:
+      enabled: true
+      package_prefix: "example"
+`,
+		"lake/payments/group.yaml": `config:
+  bundle_dependencies: artifact
+  languages:
+    java:
+      group_id: "com.example.payments"
+      artifact_id: "payments-{{.Name}}"
+    python:
+      enabled: false
+`,
+		"lake/payments/cards/group.yaml": `config:
+  languages:
+    java:
+      group_id: "com.example.payments.cards"
+`,
+		"lake/payments/cards/issuing/group.yaml": `config:
+  languages:
+    java:
+      group_id: "com.ignored"
+`,
+		"lake/payments/cards/issuing/bundle.yaml": `name: "issuing"
+version: "1.0.0"
+config:
+  languages:
+    python:
+      enabled: true
+`,
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("Failed to create dir for %s: %v", name, err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	bundleDir := filepath.Join(root, "lake", "payments", "cards", "issuing")
+	groups, err := LoadGroupConfigs(root, bundleDir)
+	if err != nil {
+		t.Fatalf("LoadGroupConfigs: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("Expected the payments and cards groups only, got %d", len(groups))
+	}
+
+	// A bundle at the lake root, or a tree without lake.yaml, must not climb
+	// out of the repository into group.yaml files above it.
+	repo := filepath.Join(root, "repo")
+	for name, content := range map[string]string{
+		"repo/lake.yaml":          "config: {}\n",
+		"repo/bundle.yaml":        "name: \"root\"\nversion: \"1.0.0\"\n",
+		"repo/orphan/bundle.yaml": "name: \"orphan\"\nversion: \"1.0.0\"\n",
+	} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("Failed to create dir for %s: %v", name, err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if groups, err := LoadGroupConfigs(repo, repo); err != nil || len(groups) != 0 {
+		t.Errorf("Expected no groups for a bundle at the lake root, got %d (err %v)", len(groups), err)
+	}
+	if err := os.Remove(filepath.Join(repo, "lake.yaml")); err != nil {
+		t.Fatalf("Failed to remove lake.yaml: %v", err)
+	}
+	if groups, err := LoadGroupConfigs(repo, filepath.Join(repo, "orphan")); err != nil || len(groups) != 0 {
+		t.Errorf("Expected no groups outside the repository root, got %d (err %v)", len(groups), err)
+	}
+	if got := groups[0].Config.Languages.Java.GroupId; got != "com.example.payments" {
+		t.Errorf("Expected the outermost group first, got group_id %q", got)
+	}
+
+	lakeConfig, err := LoadLakeConfig(bundleDir)
+	if err != nil {
+		t.Fatalf("LoadLakeConfig: %v", err)
+	}
+	bundleConfig, err := LoadBundleConfig(bundleDir)
+	if err != nil {
+		t.Fatalf("LoadBundleConfig: %v", err)
+	}
+	merged := MergeConfigurationLayers(lakeConfig, groups, bundleConfig)
+
+	if merged.JavaConfig.GroupId != "com.example.payments.cards" {
+		t.Errorf("Expected the innermost group's group_id, got %q", merged.JavaConfig.GroupId)
+	}
+	if merged.JavaConfig.ArtifactId != "payments-issuing" {
+		t.Errorf("Expected artifact_id from the payments group template, got %q", merged.JavaConfig.ArtifactId)
+	}
+	if merged.BundleDependencies != BundleDependenciesArtifact {
+		t.Errorf("Expected bundle_dependencies from the payments group, got %q", merged.BundleDependencies)
+	}
+	if !merged.PythonConfig.Enabled || merged.PythonConfig.PackageName != "example_issuing" {
+		t.Errorf("Expected bundle.yaml to re-enable Python over the group, got %+v", merged.PythonConfig)
+	}
+}
+
+// TestJavaAndPythonVersions checks the lake's Java source/target and Python
+// versions merge with bundle overrides and reach the javacopts, the POM flags
+// and the wheel's Requires-Python.
+func TestJavaAndPythonVersions(t *testing.T) {
+	lakeConfig := &LakeConfig{}
+	lakeConfig.Config.LanguageDefaults.Java.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Java.GroupId = "com.example"
+	lakeConfig.Config.LanguageDefaults.Java.SourceVersion = "1.8"
+	lakeConfig.Config.LanguageDefaults.Python.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Python.PackageName = "example_proto"
+	lakeConfig.Config.LanguageDefaults.Python.Version = "3.9"
+
+	bundleConfig := &BundleConfig{}
+	bundleConfig.Name = "versions"
 	bundleConfig.Version = "1.0.0"
-	bundleConfig.Config.Languages.Java.Enabled = boolPtr(true)
-	bundleConfig.Config.Languages.Java.GroupId = "com.test.proto"
-	bundleConfig.Config.Languages.Java.ArtifactId = "test-proto"
-	bundleConfig.Config.Languages.Python.Enabled = boolPtr(true)
-	bundleConfig.Config.Languages.Python.PackageName = "test_proto"
-	bundleConfig.Config.Languages.Javascript.Enabled = boolPtr(true)
-	bundleConfig.Config.Languages.Javascript.PackageName = "@test/proto"
+	bundleConfig.Config.Languages.Java.ArtifactId = "versions-proto"
 
-	if bundleConfig.Name != "test-bundle" {
-		t.Errorf("Expected bundle name 'test-bundle', got '%s'", bundleConfig.Name)
+	// A lone source version applies to both levels.
+	merged := MergeConfigurations(lakeConfig, bundleConfig)
+	if merged.JavaConfig.SourceVersion != "1.8" || merged.JavaConfig.TargetVersion != "1.8" {
+		t.Errorf("Expected source and target 1.8, got %q and %q", merged.JavaConfig.SourceVersion, merged.JavaConfig.TargetVersion)
+	}
+	if got := javacOpts(merged.JavaConfig); !reflect.DeepEqual(got, []string{"--release", "8"}) {
+		t.Errorf("Expected --release 8, got %v", got)
+	}
+	if cmd := pomCommand(merged, "", nil); !strings.Contains(cmd, "--java-release 8 ") {
+		t.Errorf("Expected the pom cmd to carry --java-release 8, got %q", cmd)
 	}
 
-	if bundleConfig.Description != "Test bundle" {
-		t.Errorf("Expected description 'Test bundle', got '%s'", bundleConfig.Description)
+	// Bundle overrides win; differing levels use -source/-target.
+	bundleConfig.Config.Languages.Java.SourceVersion = "11"
+	bundleConfig.Config.Languages.Java.TargetVersion = "17"
+	bundleConfig.Config.Languages.Python.PythonVersion = ">=3.10,<4"
+	merged = MergeConfigurations(lakeConfig, bundleConfig)
+	if got := javacOpts(merged.JavaConfig); !reflect.DeepEqual(got, []string{"-source", "11", "-target", "17"}) {
+		t.Errorf("Expected -source 11 -target 17, got %v", got)
+	}
+	if cmd := pomCommand(merged, "", nil); !strings.Contains(cmd, "--java-source 11 --java-target 17 ") {
+		t.Errorf("Expected the pom cmd to carry the source and target, got %q", cmd)
 	}
 
-	if bundleConfig.Config.Languages.Java.GroupId != "com.test.proto" {
-		t.Errorf("Expected Java group_id 'com.test.proto', got '%s'", bundleConfig.Config.Languages.Java.GroupId)
+	c := &config.Config{RepoRoot: t.TempDir()}
+	byName := make(map[string]*rule.Rule)
+	for _, r := range generateBundleRules(merged, []string{":api_proto"}, "", c, nil) {
+		byName[r.Name()] = r
+	}
+	if got := byName["versions_java_grpc"].AttrStrings("javacopts"); !reflect.DeepEqual(got, []string{"-source", "11", "-target", "17"}) {
+		t.Errorf("Expected java_grpc_library javacopts, got %v", got)
+	}
+	if got := byName["versions_py_bundle"].AttrString("python_requires"); got != ">=3.10,<4" {
+		t.Errorf("Expected python_requires '>=3.10,<4', got %q", got)
+	}
+
+	for v, want := range map[string]string{"3.9": ">=3.9", " 3.11 ": ">=3.11", "~=3.8": "~=3.8", "": ""} {
+		if got := requiresPython(v); got != want {
+			t.Errorf("requiresPython(%q) = %q, want %q", v, got, want)
+		}
+	}
+	for v, want := range map[string]string{"8": "8", "1.8": "8", "21": "21", "eleven": "", "11.0.2": ""} {
+		if got := javaRelease(v); got != want {
+			t.Errorf("javaRelease(%q) = %q, want %q", v, got, want)
+		}
+	}
+
+	// Without versions nothing is pinned.
+	if got := javacOpts(JavaConfig{}); got != nil {
+		t.Errorf("Expected no javacopts without versions, got %v", got)
 	}
 }
 
-func TestLakeConfigStructure(t *testing.T) {
-	// Test that we can create the basic structures without file I/O
+// TestVersionPolicy checks bundle versions against semver, the publishers'
+// rules and lake.yaml's versioning policy.
+func TestVersionPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		python   bool
+		goModule string
+		policy   VersioningConfig
+		want     string // error, or "" for none
+	}{
+		{name: "Release", version: "1.2.3", python: true},
+		{name: "TwoParts", version: "1.0", want: `"1.0" is not a semantic version (MAJOR.MINOR.PATCH[-PRERELEASE]); did you mean "1.0.0"?`},
+		{name: "LeadingV", version: "v1.2.3", want: `"v1.2.3" is not a semantic version (MAJOR.MINOR.PATCH[-PRERELEASE]); did you mean "1.2.3"?`},
+		{name: "Garbage", version: "latest", want: `"latest" is not a semantic version (MAJOR.MINOR.PATCH[-PRERELEASE])`},
+		{name: "LeadingZero", version: "1.02.0", want: `"1.02.0" is not a semantic version (MAJOR.MINOR.PATCH[-PRERELEASE])`},
+		{name: "Prerelease", version: "1.2.0-rc.1", python: true},
+		{name: "UnmappedPrereleaseWithoutPython", version: "1.2.0-next.1"},
+		{name: "UnmappedPrereleaseWithPython", version: "1.2.0-next.1", python: true,
+			want: `"1.2.0-next.1" can't be published to PyPI: prerelease "next.1" has no PEP 440 equivalent (use alpha, beta, rc or dev, optionally numbered)`},
+		{name: "BuildMetadataWithPython", version: "1.2.0+build.5", python: true,
+			want: `"1.2.0+build.5" can't be published to PyPI: build metadata "+build.5" would make a PEP 440 local version, which PyPI refuses`},
+		{name: "AllowedTag", version: "1.2.0-beta2", policy: VersioningConfig{PrereleaseTags: []string{"beta", "rc"}}},
+		{name: "DisallowedTag", version: "1.2.0-alpha.1", policy: VersioningConfig{PrereleaseTags: []string{"beta", "rc"}},
+			want: `prerelease tag "alpha" of "1.2.0-alpha.1" is not one of lake.yaml's versioning.prerelease_tags (beta, rc)`},
+		{name: "SnapshotAllowed", version: "1.2.0-SNAPSHOT", python: true, policy: VersioningConfig{PrereleaseTags: []string{"rc"}}},
+		{name: "SnapshotRejected", version: "1.2.0-SNAPSHOT", policy: VersioningConfig{Snapshots: "reject"},
+			want: `"1.2.0-SNAPSHOT" is a snapshot, and lake.yaml's versioning.snapshots rejects them`},
+		{name: "GoV1", version: "1.4.0", goModule: "example.com/acme/orders"},
+		{name: "GoMajorSuffix", version: "2.1.0-rc.1", goModule: "example.com/acme/orders/v2"},
+		{name: "GoMissingMajorSuffix", version: "2.1.0", goModule: "example.com/acme/orders",
+			want: "Go module example.com/acme/orders can't be published at v2.1.0: from v2 on, the module path must end in /v2 (example.com/acme/orders/v2)"},
+		{name: "GoWrongMajorSuffix", version: "1.4.0", goModule: "example.com/acme/orders/v2",
+			want: "Go module example.com/acme/orders/v2 can't be published at v1.4.0: its path names major version 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := &MergedConfig{
+				BundleName:   "orders",
+				Version:      tt.version,
+				PythonConfig: PythonConfig{Enabled: tt.python},
+				GoConfig:     GoConfig{Enabled: tt.goModule != "", ModulePath: tt.goModule},
+				Versioning:   tt.policy,
+			}
+			var got string
+			if err := versionError(merged); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("versionError(%q) = %q, want %q", tt.version, got, tt.want)
+			}
+		})
+	}
+
+	for version, want := range map[string]string{
+		"1.2.0":          "1.2.0",
+		"1.2.0-alpha":    "1.2.0a0",
+		"1.2.0-beta.2":   "1.2.0b2",
+		"1.2.0-rc1":      "1.2.0rc1",
+		"1.2.0-RC.1":     "1.2.0rc1",
+		"1.2.0-preview":  "1.2.0rc0",
+		"1.2.0-dev.3":    "1.2.0.dev3",
+		"1.2.0-SNAPSHOT": "1.2.0.dev0",
+	} {
+		v, err := parseSemver(version)
+		if err != nil {
+			t.Fatalf("parseSemver(%q): %v", version, err)
+		}
+		if got, err := v.pep440(); got != want || err != nil {
+			t.Errorf("pep440(%q) = %q, %v, want %q", version, got, err, want)
+		}
+	}
+
+	merged := &MergedConfig{
+		Version:          "2.0.0-rc.1",
+		JavaConfig:       JavaConfig{Enabled: true},
+		PythonConfig:     PythonConfig{Enabled: true},
+		JavaScriptConfig: JavaScriptConfig{Enabled: true},
+	}
+	if got, want := publishedVersions(merged), "maven 2.0.0-rc.1, pypi 2.0.0rc1, npm 2.0.0-rc.1"; got != want {
+		t.Errorf("publishedVersions = %q, want %q", got, want)
+	}
+}
+
+// TestVersionSource checks a git_tag or stamp bundle reads its version from
+// the stamped <bundle>_version genrule everywhere, publishes Java through
+// the py_binary publisher, and that the genrule cmd finds the version in the
+// workspace status.
+func TestVersionSource(t *testing.T) {
 	lakeConfig := &LakeConfig{}
+	lakeConfig.Config.VersionSource = VersionSourceGitTag
+	lakeConfig.Config.Signing = SigningConfig{Enabled: true}
 	lakeConfig.Config.LanguageDefaults.Java.Enabled = true
-	lakeConfig.Config.LanguageDefaults.Java.GroupId = "com.lake.proto"
-	lakeConfig.Config.LanguageDefaults.Java.SourceVersion = "11"
-	lakeConfig.Config.LanguageDefaults.Java.TargetVersion = "8"
+	lakeConfig.Config.LanguageDefaults.Java.GroupId = "com.example"
+	lakeConfig.Config.LanguageDefaults.Java.ArtifactId = "{{.Name}}-proto"
 	lakeConfig.Config.LanguageDefaults.Python.Enabled = true
-	lakeConfig.Config.LanguageDefaults.Python.PackageName = "lake_proto"
-	lakeConfig.Config.LanguageDefaults.Python.Version = ">=3.8"
+	lakeConfig.Config.LanguageDefaults.Python.PackagePrefix = "example_proto"
+
+	bundleConfig := &BundleConfig{}
+	bundleConfig.Name = "orders"
+	merged := MergeConfigurations(lakeConfig, bundleConfig)
+	if merged.VersionSource != VersionSourceGitTag {
+		t.Fatalf("Expected version_source git_tag from lake.yaml, got %q", merged.VersionSource)
+	}
+
+	c := &config.Config{RepoRoot: t.TempDir()}
+	byName := make(map[string]*rule.Rule)
+	for _, r := range generateBundleRules(merged, []string{":api_proto"}, "", c, nil) {
+		byName[r.Name()] = r
+	}
+
+	version := byName["orders_version"]
+	if version == nil || version.Kind() != "genrule" {
+		t.Fatalf("Expected genrule orders_version, got %v", version)
+	}
+	if stamp := version.Attr("stamp"); stamp == nil || bzl.FormatString(stamp) != "1" {
+		t.Errorf("Expected stamp = 1, got %v", stamp)
+	}
+
+	cmd := version.AttrString("cmd")
+	status := "BUILD_SCM_STATUS clean\nSTABLE_GIT_TAG_orders orders-v1.4.0-rc.2\nSTABLE_GIT_TAG_ordersx ordersx-v9.9.9\n"
+	if got, err := runVersionCmd(t, cmd, status); err != nil || got != "1.4.0-rc.2\n" {
+		t.Errorf("Expected version 1.4.0-rc.2 from the tag, got %q, %v", got, err)
+	}
+	if got, err := runVersionCmd(t, cmd, "STABLE_GIT_TAG_orders orders-vnext\n"); err == nil || !strings.Contains(got, "no orders-vX.Y.Z tag") {
+		t.Errorf("Expected a malformed tag to fail the genrule, got %q, %v", got, err)
+	}
+
+	// Everything reads the version file instead of bundle.yaml.
+	if got := byName["orders_java_bundle"].AttrString("version_file"); got != ":orders_version" {
+		t.Errorf("Expected java bundle version_file :orders_version, got %q", got)
+	}
+	if got := byName["orders_java_bundle"].AttrString("bundle_yaml"); got != "" {
+		t.Errorf("Expected no bundle_yaml on the java bundle, got %q", got)
+	}
+	pom := byName["orders_pom"]
+	if got := pom.AttrStrings("srcs"); !reflect.DeepEqual(got, []string{":orders_version"}) {
+		t.Errorf("Expected pom srcs [:orders_version], got %v", got)
+	}
+	if cmd := pom.AttrString("cmd"); !strings.Contains(cmd, "--version-file $(location :orders_version)") ||
+		strings.Contains(cmd, "--expected-version") || strings.Contains(cmd, "--bundle-yaml") {
+		t.Errorf("Expected the pom cmd to read only the version file, got %q", cmd)
+	}
+	pypi := byName["publish_orders_to_pypi"]
+	if got := pypi.AttrStrings("args"); !slices.Contains(got, "--version-file=$(location :orders_version)") {
+		t.Errorf("Expected the pypi publisher to read the version file, got %v", got)
+	}
+	if got := pypi.AttrStrings("data"); !slices.Contains(got, ":orders_version") || slices.Contains(got, "bundle.yaml") {
+		t.Errorf("Expected the pypi publisher data to carry the version file, got %v", got)
+	}
+
+	// maven_publish can't take a build-time version: a py_binary publishes
+	// from the POM, signed like the maven_publish it replaces.
+	maven := byName["publish_orders_to_maven"]
+	if maven == nil || maven.Kind() != "py_binary" {
+		t.Fatalf("Expected py_binary publish_orders_to_maven, got %v", maven)
+	}
+	wantArgs := []string{
+		"$(location :orders_java_bundle)",
+		"--pom=$(location :orders_pom)",
+		"--classifier-artifact=sources=$(location :orders_java_sources)",
+		"--classifier-artifact=javadoc=$(location :orders_javadoc)",
+		"--gpg-sign",
+	}
+	if got := maven.AttrStrings("args"); !reflect.DeepEqual(got, wantArgs) {
+		t.Errorf("Expected maven publisher args %v, got %v", wantArgs, got)
+	}
+	if got := byName["publish_orders_to_maven_local"].AttrStrings("args"); slices.Contains(got, "--gpg-sign") ||
+		!slices.Contains(got, "--pom=$(location :orders_pom_local)") {
+		t.Errorf("Expected the unsigned local twin to publish the local POM, got %v", got)
+	}
+
+	// Switching version_source removes the other kind of Maven publisher.
+	emptyKinds := func(config *MergedConfig) map[string]string {
+		kinds := make(map[string]string)
+		for _, r := range generateLegacyCleanupRules(config) {
+			if r.Kind() != "genrule" || r.Name() == "orders_version" {
+				kinds[r.Name()] = r.Kind()
+			}
+		}
+		return kinds
+	}
+	if got := emptyKinds(merged); got["publish_orders_to_maven"] != "maven_publish" || got["orders_version"] != "" {
+		t.Errorf("Expected git_tag cleanup of the maven_publish rules only, got %v", got)
+	}
+	bundleConfig.Config.VersionSource = VersionSourceLiteral
+	bundleConfig.Version = "1.4.0"
+	literal := MergeConfigurations(lakeConfig, bundleConfig)
+	if got := emptyKinds(literal); got["publish_orders_to_maven_local"] != "py_binary" || got["orders_version"] != "genrule" {
+		t.Errorf("Expected literal cleanup of the py_binary publishers and version genrule, got %v", got)
+	}
+
+	// stamp reads the lake's own status key, as is.
+	bundleConfig.Config.VersionSource = VersionSourceStamp
+	bundleConfig.Version = ""
+	stamped := MergeConfigurations(lakeConfig, bundleConfig)
+	if got := versionStatusKey(stamped); got != "STABLE_VERSION_orders" {
+		t.Errorf("Expected status key STABLE_VERSION_orders, got %q", got)
+	}
+	if got, err := runVersionCmd(t, versionCommand(stamped), "STABLE_VERSION_orders 2.0.0\n"); err != nil || got != "2.0.0\n" {
+		t.Errorf("Expected stamped version 2.0.0, got %q, %v", got, err)
+	}
+	if got, err := runVersionCmd(t, versionCommand(stamped), ""); err == nil || !strings.Contains(got, "no STABLE_VERSION_orders") {
+		t.Errorf("Expected a missing status key to fail the genrule, got %q, %v", got, err)
+	}
+}
+
+// runVersionCmd runs a <bundle>_version genrule cmd as Bazel would: `$$`
+// expanded to `$`, from an execroot holding the stable status file. It
+// returns the version file, or the output of a failed run.
+func runVersionCmd(t *testing.T, cmd, status string) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "bazel-out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bazel-out", "stable-status.txt"), []byte(status), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sh := exec.Command("sh", "-c", strings.ReplaceAll(strings.ReplaceAll(cmd, "$$", "$"), "$@", "orders.version"))
+	sh.Dir = dir
+	if output, err := sh.CombinedOutput(); err != nil {
+		return string(output), err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "orders.version"))
+	return string(data), err
+}
+
+// TestVersionCommandPolicy checks the version genrule holds a stamped
+// version to lake.yaml's versioning policy and to PEP 440, as versionError
+// does a literal one.
+func TestVersionCommandPolicy(t *testing.T) {
+	config := &MergedConfig{
+		BundleName:    "orders",
+		VersionSource: VersionSourceStamp,
+		PythonConfig:  PythonConfig{Enabled: true},
+		Versioning:    VersioningConfig{PrereleaseTags: []string{"rc", "beta"}, Snapshots: "reject"},
+	}
+	cmd := versionCommand(config)
+	for _, tc := range []struct {
+		version string
+		fails   string
+	}{
+		{"1.2.0", ""},
+		{"1.2.0-rc.1", ""},
+		{"1.2.0-beta2", ""},
+		{"1.2.0-SNAPSHOT", "versioning.snapshots rejects them"},
+		{"1.2.0-alpha.1", "versioning.prerelease_tags (rc, beta)"},
+		{"1.2.0-rc.1.2", "can't be published to PyPI"},
+		{"1.2.0+build.5", "can't be published to PyPI"},
+	} {
+		got, err := runVersionCmd(t, cmd, "STABLE_VERSION_orders "+tc.version+"\n")
+		if tc.fails == "" {
+			if err != nil || got != tc.version+"\n" {
+				t.Errorf("Expected %s to pass, got %q, %v", tc.version, got, err)
+			}
+		} else if err == nil || !strings.Contains(got, "orders: version "+tc.version+" ") || !strings.Contains(got, tc.fails) {
+			t.Errorf("Expected %s to fail with %q, got %q, %v", tc.version, tc.fails, got, err)
+		}
+	}
+
+	// Without the policy or Python, any semantic version passes, as a
+	// literal one would.
+	plain := &MergedConfig{BundleName: "orders", VersionSource: VersionSourceStamp}
+	for _, version := range []string{"1.2.0-SNAPSHOT", "1.2.0-alpha.1.2", "1.2.0+build.5"} {
+		if got, err := runVersionCmd(t, versionCommand(plain), "STABLE_VERSION_orders "+version+"\n"); err != nil || got != version+"\n" {
+			t.Errorf("Expected %s to pass without a policy, got %q, %v", version, got, err)
+		}
+	}
+}
+
+// TestRuntimeVersions checks the lake's pinned protobuf and gRPC versions
+// reach the POM and the wheel and package.json dependencies, and a pin
+// MODULE.bazel disagrees with is reported where MODULE.bazel declares it.
+func TestRuntimeVersions(t *testing.T) {
+	lakeConfig := &LakeConfig{}
+	lakeConfig.Config.LanguageDefaults.Java.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Java.GroupId = "com.example"
+	lakeConfig.Config.LanguageDefaults.Python.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Python.PackagePrefix = "example"
 	lakeConfig.Config.LanguageDefaults.Javascript.Enabled = true
-	lakeConfig.Config.LanguageDefaults.Javascript.PackageName = "@lake/proto"
+	lakeConfig.Config.LanguageDefaults.Javascript.PackageScope = "example"
 
-	if lakeConfig.Config.LanguageDefaults.Java.GroupId != "com.lake.proto" {
-		t.Errorf("Expected Java group_id 'com.lake.proto', got '%s'", lakeConfig.Config.LanguageDefaults.Java.GroupId)
+	bundleConfig := &BundleConfig{}
+	bundleConfig.Name = "runtimes"
+	bundleConfig.Version = "1.0.0"
+	bundleConfig.Config.Languages.Java.ArtifactId = "runtimes-proto"
+
+	// Unpinned, the pom takes the versions from the environment.
+	merged := MergeConfigurations(lakeConfig, bundleConfig)
+	if cmd := pomCommand(merged, "", nil); !strings.Contains(cmd, "--protobuf-version "+protobufJavaVersionFallback+" --grpc-version "+grpcJavaVersionFallback+" ") {
+		t.Errorf("Expected the pom cmd to fall back to the environment, got %q", cmd)
+	}
+	if flags := runtimeFlags(merged, metadataPypi); flags != nil {
+		t.Errorf("Expected no runtime requirements without pins, got %v", flags)
 	}
 
-	if lakeConfig.Config.LanguageDefaults.Java.SourceVersion != "11" {
-		t.Errorf("Expected Java source_version '11', got '%s'", lakeConfig.Config.LanguageDefaults.Java.SourceVersion)
+	lakeConfig.Config.LanguageDefaults.Java.ProtobufVersion = "4.31.1"
+	lakeConfig.Config.LanguageDefaults.Java.GrpcVersion = "1.75.0"
+	lakeConfig.Config.LanguageDefaults.Python.ProtobufVersion = "6.31.1"
+	lakeConfig.Config.LanguageDefaults.Python.GrpcVersion = "1.74.0"
+	lakeConfig.Config.LanguageDefaults.Javascript.ProtobufVersion = "2.6.0"
+	merged = MergeConfigurations(lakeConfig, bundleConfig)
+	if cmd := pomCommand(merged, "", nil); !strings.Contains(cmd, "--protobuf-version 4.31.1 --grpc-version 1.75.0 ") {
+		t.Errorf("Expected the pom cmd to carry the pinned versions, got %q", cmd)
 	}
 
-	if lakeConfig.Config.LanguageDefaults.Python.PackageName != "lake_proto" {
-		t.Errorf("Expected Python package_name 'lake_proto', got '%s'", lakeConfig.Config.LanguageDefaults.Python.PackageName)
+	c := &config.Config{RepoRoot: t.TempDir()}
+	byName := make(map[string]*rule.Rule)
+	for _, r := range generateBundleRules(merged, []string{":api_proto"}, "", c, nil) {
+		byName[r.Name()] = r
+	}
+	for _, name := range []string{"publish_runtimes_to_pypi", "publish_runtimes_to_pypi_local"} {
+		args := byName[name].AttrStrings("args")
+		for _, want := range []string{"--requirement='protobuf>=6.31.1,<7'", "--requirement='grpcio>=1.74.0,<2'"} {
+			if !slices.Contains(args, want) {
+				t.Errorf("Expected %s arg %q, got %v", name, want, args)
+			}
+		}
+	}
+	npmArgs := byName["publish_runtimes_to_npm"].AttrStrings("args")
+	if !slices.Contains(npmArgs, "--dependency='@bufbuild/protobuf@^2.6.0'") {
+		t.Errorf("Expected the npm publisher to depend on @bufbuild/protobuf, got %v", npmArgs)
+	}
+	for _, arg := range npmArgs {
+		if strings.Contains(arg, jsGrpcPackage) {
+			t.Errorf("Expected no unpinned @connectrpc/connect dependency, got %q", arg)
+		}
+	}
+
+	dir := t.TempDir()
+	module := `module(name = "example_lake")
+
+bazel_dep(name = "protobuf", version = "31.1")
+bazel_dep(name = "grpc", version = "1.74.1")
+
+maven = use_extension("@rules_jvm_external//:extensions.bzl", "maven")
+maven.install(
+    artifacts = [
+        "com.google.protobuf:protobuf-java:4.31.1",
+        "io.grpc:grpc-stub:1.76.0",
+    ],
+)
+maven.artifact(
+    artifact = "grpc-protobuf",
+    group = "io.grpc",
+    version = "1.75.0",
+)
+`
+	if err := os.WriteFile(filepath.Join(dir, "MODULE.bazel"), []byte(module), 0644); err != nil {
+		t.Fatalf("Failed to write MODULE.bazel: %v", err)
+	}
+	mv, err := loadModuleVersions(dir, "MODULE.bazel")
+	if err != nil {
+		t.Fatalf("Failed to load MODULE.bazel: %v", err)
+	}
+	var got []string
+	for _, e := range runtimeVersionErrors(lakeConfig, mv) {
+		got = append(got, e.Error())
+	}
+	want := []string{
+		"MODULE.bazel:10:9: lake.yaml pins java grpc_version 1.75.0, but MODULE.bazel resolves io.grpc:grpc-stub to 1.76.0",
+		"MODULE.bazel:4:1: lake.yaml pins python grpc_version 1.74.0, but MODULE.bazel resolves module grpc to 1.74.1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected errors:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	// No MODULE.bazel, nothing to check against.
+	if mv, err := loadModuleVersions(t.TempDir(), "MODULE.bazel"); mv != nil || err != nil {
+		t.Errorf("Expected no module versions without a MODULE.bazel, got %v, %v", mv, err)
+	}
+
+	// A lake below the workspace root is checked against the workspace's
+	// MODULE.bazel.
+	repoRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repoRoot, "MODULE.bazel"), []byte(module), 0644); err != nil {
+		t.Fatalf("Failed to write MODULE.bazel: %v", err)
+	}
+	lakeDir := filepath.Join(repoRoot, "protos")
+	if err := os.MkdirAll(lakeDir, 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", lakeDir, err)
+	}
+	nested := "config:\n  language_defaults:\n    python:\n      grpc_version: \"1.74.0\"\n"
+	if err := os.WriteFile(filepath.Join(lakeDir, "lake.yaml"), []byte(nested), 0644); err != nil {
+		t.Fatalf("Failed to write lake.yaml: %v", err)
+	}
+	got = nil
+	for _, e := range NewLanguage().(*protolakeExtension).lakeConfigErrors(&config.Config{RepoRoot: repoRoot}) {
+		got = append(got, e.Error())
+	}
+	want = []string{"MODULE.bazel:4:1: lake.yaml pins python grpc_version 1.74.0, but MODULE.bazel resolves module grpc to 1.74.1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the nested lake checked against the root MODULE.bazel:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	for runtime, want := range map[string]bool{"4.31.1": true, "6.31.1": true, "31.1": true, "4.31.0": false, "3.25.5": false} {
+		if got := protobufRuntimeMatches(runtime, "31.1"); got != want {
+			t.Errorf("protobufRuntimeMatches(%q, \"31.1\") = %v, want %v", runtime, got, want)
+		}
+	}
+}
+
+// TestCoordinateTemplates checks a bundle.yaml with only a name and version
+// gets its coordinates from the lake's templates, and explicit values still
+// win.
+func TestCoordinateTemplates(t *testing.T) {
+	lakeConfig := &LakeConfig{}
+	lakeConfig.Config.LanguageDefaults.Java.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Java.GroupId = "com.example"
+	lakeConfig.Config.LanguageDefaults.Java.ArtifactId = "{{.Name | kebab}}-proto"
+	lakeConfig.Config.LanguageDefaults.Python.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Python.PackagePrefix = "example_proto"
+	lakeConfig.Config.LanguageDefaults.Javascript.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Javascript.PackageScope = "example"
+
+	bundleConfig := &BundleConfig{}
+	bundleConfig.Name = "userService"
+	bundleConfig.Version = "1.0.0"
+
+	merged := MergeConfigurations(lakeConfig, bundleConfig)
+	if merged.JavaConfig.ArtifactId != "user-service-proto" {
+		t.Errorf("Expected artifact_id 'user-service-proto', got %q", merged.JavaConfig.ArtifactId)
+	}
+	if merged.PythonConfig.PackageName != "example_proto_user_service" {
+		t.Errorf("Expected package_name 'example_proto_user_service', got %q", merged.PythonConfig.PackageName)
+	}
+	if merged.JavaScriptConfig.PackageName != "@example/userService" {
+		t.Errorf("Expected package_name '@example/userService', got %q", merged.JavaScriptConfig.PackageName)
+	}
+
+	// A lake package_name template beats the prefix default, and explicit
+	// bundle values beat both.
+	lakeConfig.Config.LanguageDefaults.Python.PackageName = "{{.PackagePrefix}}.{{.Name | lower}}"
+	bundleConfig.Config.Languages.Java.ArtifactId = "users-api"
+	bundleConfig.Config.Languages.Javascript.PackageName = "@other/users"
+	merged = MergeConfigurations(lakeConfig, bundleConfig)
+	if merged.PythonConfig.PackageName != "example_proto.userservice" {
+		t.Errorf("Expected package_name 'example_proto.userservice', got %q", merged.PythonConfig.PackageName)
+	}
+	if merged.JavaConfig.ArtifactId != "users-api" || merged.JavaScriptConfig.PackageName != "@other/users" {
+		t.Errorf("Expected explicit bundle coordinates to win, got %q and %q",
+			merged.JavaConfig.ArtifactId, merged.JavaScriptConfig.PackageName)
+	}
+
+	for name, want := range map[string]string{
+		"user-service":   "user_service",
+		"UserService":    "user_service",
+		"HTTPServer2API": "http_server2_api",
+		"orders_v2":      "orders_v2",
+	} {
+		if got := namingFuncs["snake"].(func(string) string)(name); got != want {
+			t.Errorf("snake(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestJavaReleaseArtifacts checks both maven_publish rules ship the sources
+// and javadoc JARs, and only the release one is signed, per lake.yaml.
+func TestJavaReleaseArtifacts(t *testing.T) {
+	lakeConfig := &LakeConfig{}
+	lakeConfig.Config.LanguageDefaults.Java.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Java.GroupId = "com.example"
+	lakeConfig.Config.LanguageDefaults.Java.ArtifactId = "{{.Name}}-proto"
+
+	bundleConfig := &BundleConfig{}
+	bundleConfig.Name = "orders"
+	bundleConfig.Version = "1.0.0"
+
+	generate := func() map[string]*rule.Rule {
+		merged := MergeConfigurations(lakeConfig, bundleConfig)
+		c := &config.Config{RepoRoot: t.TempDir()}
+		byName := make(map[string]*rule.Rule)
+		for _, r := range generateBundleRules(merged, []string{":api_proto"}, "", c, nil) {
+			byName[r.Name()] = r
+		}
+		return byName
+	}
+
+	byName := generate()
+	if r := byName["orders_java_sources"]; r == nil || r.Kind() != "java_source_bundle" {
+		t.Fatalf("Expected a java_source_bundle orders_java_sources, got %v", r)
+	}
+	if got := byName["orders_java_sources"].AttrStrings("srcjars"); !reflect.DeepEqual(got, []string{":liborders_java_grpc-src.jar"}) {
+		t.Errorf("Expected the sources JAR built from the library's source JAR, got %v", got)
+	}
+	if got := byName["orders_javadoc"].AttrStrings("deps"); !reflect.DeepEqual(got, []string{":orders_java_grpc"}) {
+		t.Errorf("Expected javadoc over :orders_java_grpc, got %v", got)
+	}
+	wantClassifiers := `{":orders_java_sources": "sources", ":orders_javadoc": "javadoc"}`
+	for _, name := range []string{"publish_orders_to_maven", "publish_orders_to_maven_local"} {
+		r := byName[name]
+		if got := formatExpr(r.Attr("classifier_artifacts")); got != wantClassifiers {
+			t.Errorf("%s: expected classifier_artifacts %s, got %s", name, wantClassifiers, got)
+		}
+		if r.Attr("toolchains") != nil {
+			t.Errorf("%s: expected no signing toolchain with signing off", name)
+		}
+	}
+
+	lakeConfig.Config.Signing.Enabled = true
+	byName = generate()
+	if got := byName["publish_orders_to_maven"].AttrStrings("toolchains"); !reflect.DeepEqual(got, []string{"//tools:maven_gpg_signing"}) {
+		t.Errorf("Expected the keyring signing toolchain, got %v", got)
+	}
+	if byName["publish_orders_to_maven_local"].Attr("toolchains") != nil {
+		t.Error("Expected the local twin to stay unsigned")
+	}
+	lakeConfig.Config.Signing.InMemoryKeys = true
+	if got := generate()["publish_orders_to_maven"].AttrStrings("toolchains"); !reflect.DeepEqual(got, []string{"//tools:maven_gpg_signing_in_memory"}) {
+		t.Errorf("Expected the in-memory signing toolchain, got %v", got)
+	}
+}
+
+// TestLocalPublishTwins checks the pypi and npm publishers get local twins
+// publishing at PEP 440 and semver qualified versions.
+func TestLocalPublishTwins(t *testing.T) {
+	merged := &MergedConfig{
+		BundleName:       "orders",
+		Version:          "1.0.0",
+		PythonConfig:     PythonConfig{Enabled: true, PackageName: "example-orders"},
+		JavaScriptConfig: JavaScriptConfig{Enabled: true, PackageName: "@example/orders"},
+	}
+	c := &config.Config{RepoRoot: t.TempDir()}
+	byName := make(map[string]*rule.Rule)
+	for _, r := range generateBundleRules(merged, []string{":api_proto"}, "", c, nil) {
+		byName[r.Name()] = r
+	}
+
+	for name, suffix := range map[string]string{
+		"publish_orders_to_pypi_local": "--version-suffix=+local",
+		"publish_orders_to_npm_local":  "--version-suffix=-local",
+	} {
+		r := byName[name]
+		if r == nil {
+			t.Errorf("Expected local twin %s", name)
+			continue
+		}
+		if args := r.AttrStrings("args"); !slices.Contains(args, suffix) {
+			t.Errorf("%s: expected %s in args, got %v", name, suffix, args)
+		}
+		release := byName[strings.TrimSuffix(name, "_local")]
+		if !reflect.DeepEqual(r.AttrStrings("data"), release.AttrStrings("data")) {
+			t.Errorf("%s: expected the release publisher's data, got %v", name, r.AttrStrings("data"))
+		}
+	}
+	for _, name := range []string{"publish_orders_to_pypi", "publish_orders_to_npm"} {
+		for _, arg := range byName[name].AttrStrings("args") {
+			if strings.HasPrefix(arg, "--version-suffix") {
+				t.Errorf("%s: expected no version suffix on the release publisher, got %q", name, arg)
+			}
+		}
+	}
+	if got := byName["publish_to_pypi"].AttrString("actual"); got != ":publish_orders_to_pypi" {
+		t.Errorf("Expected publish_to_pypi to stay on the release publisher, got %q", got)
+	}
+}
+
+func TestSnapshotPublishTwins(t *testing.T) {
+	merged := &MergedConfig{
+		BundleName:       "orders",
+		Version:          "1.0.0",
+		JavaConfig:       JavaConfig{Enabled: true, GroupId: "com.example", ArtifactId: "orders-proto"},
+		PythonConfig:     PythonConfig{Enabled: true, PackageName: "example-orders"},
+		JavaScriptConfig: JavaScriptConfig{Enabled: true, PackageName: "@example/orders"},
+		Signing:          SigningConfig{Enabled: true},
+	}
+	c := &config.Config{RepoRoot: t.TempDir()}
+	byName := make(map[string]*rule.Rule)
+	for _, r := range generateBundleRules(merged, []string{":api_proto"}, "", c, nil) {
+		byName[r.Name()] = r
+	}
+
+	maven := byName["publish_orders_to_maven_snapshot"]
+	if maven == nil || maven.Kind() != "maven_publish" {
+		t.Fatalf("Expected maven_publish publish_orders_to_maven_snapshot, got %v", maven)
+	}
+	// A snapshot of the next patch release, which sorts above 1.0.0.
+	if got := maven.AttrString("coordinates"); got != "com.example:orders-proto:1.0.1-SNAPSHOT" {
+		t.Errorf("Expected snapshot coordinates, got %q", got)
+	}
+	if got := maven.AttrString("pom"); got != ":orders_pom_snapshot" {
+		t.Errorf("Expected the snapshot POM, got %q", got)
+	}
+	if maven.Attr("toolchains") != nil {
+		t.Error("Expected the snapshot twin to stay unsigned")
+	}
+	if cmd := byName["orders_pom_snapshot"].AttrString("cmd"); !strings.Contains(cmd, "--next-version=1.0.1 --version-suffix=-SNAPSHOT") {
+		t.Errorf("Expected the snapshot POM to qualify the next patch version, got %q", cmd)
+	}
+
+	for name, want := range map[string][]string{
+		"publish_orders_to_pypi_snapshot": {"--next-version=1.0.1", "--snapshot-qualifier=.dev"},
+		"publish_orders_to_npm_snapshot":  {"--next-version=1.0.1", "--snapshot-qualifier=-next.", "--dist-tag=next"},
+	} {
+		r := byName[name]
+		if r == nil {
+			t.Errorf("Expected snapshot twin %s", name)
+			continue
+		}
+		args := r.AttrStrings("args")
+		for _, arg := range want {
+			if !slices.Contains(args, arg) {
+				t.Errorf("%s: expected %s in args, got %v", name, arg, args)
+			}
+		}
+		if slices.ContainsFunc(args, func(a string) bool { return strings.HasPrefix(a, "--version-suffix") }) {
+			t.Errorf("%s: expected no version suffix, got %v", name, args)
+		}
+	}
+
+	// A prerelease snapshots ahead of itself, below the next prerelease,
+	// in each registry's ordering; a snapshot is one of its release.
+	for _, tt := range []struct {
+		version, maven string
+		pypi, npm      []string
+	}{
+		{"1.2.0-rc.1", "1.2.0-rc.2-SNAPSHOT",
+			[]string{"--next-version=1.2.0rc2", "--snapshot-qualifier=.dev"},
+			[]string{"--next-version=1.2.0-rc.1", "--snapshot-qualifier=.next."}},
+		{"1.2.0-beta3", "1.2.0-beta4-SNAPSHOT",
+			[]string{"--next-version=1.2.0b4", "--snapshot-qualifier=.dev"},
+			[]string{"--next-version=1.2.0-beta3", "--snapshot-qualifier=.next."}},
+		{"1.2.0-rc", "1.2.0-rc.1-SNAPSHOT",
+			[]string{"--next-version=1.2.0rc1", "--snapshot-qualifier=.dev"},
+			[]string{"--next-version=1.2.0-rc", "--snapshot-qualifier=.next."}},
+		{"1.2.0-SNAPSHOT", "1.2.0-SNAPSHOT",
+			[]string{"--next-version=1.2.0", "--snapshot-qualifier=.dev"},
+			[]string{"--next-version=1.2.0-SNAPSHOT", "--snapshot-qualifier=.next."}},
+	} {
+		merged.Version = tt.version
+		if got := twinVersion(merged, publishSnapshot); got != tt.maven {
+			t.Errorf("Expected %s to snapshot as %s, got %s", tt.version, tt.maven, got)
+		}
+		if got := snapshotArgs(merged, "pypi"); !reflect.DeepEqual(got, tt.pypi) {
+			t.Errorf("Expected %s to snapshot on PyPI with %v, got %v", tt.version, tt.pypi, got)
+		}
+		if got := snapshotArgs(merged, "npm"); !reflect.DeepEqual(got, tt.npm) {
+			t.Errorf("Expected %s to snapshot on npm with %v, got %v", tt.version, tt.npm, got)
+		}
+	}
+
+	// lake.yaml can move snapshots to the next minor or major release.
+	merged.Version = "1.2.3"
+	for base, want := range map[string]string{
+		snapshotBaseMinor: "1.3.0-SNAPSHOT",
+		snapshotBaseMajor: "2.0.0-SNAPSHOT",
+	} {
+		merged.Versioning.SnapshotBase = base
+		if got := twinVersion(merged, publishSnapshot); got != want {
+			t.Errorf("Expected snapshot_base %s to snapshot 1.2.3 as %s, got %s", base, want, got)
+		}
+		if cmd := pomCommand(merged, publishSnapshot, nil); !strings.Contains(cmd, "--next-version="+strings.TrimSuffix(want, "-SNAPSHOT")+" ") {
+			t.Errorf("Expected the snapshot POM to move to %s, got %q", want, cmd)
+		}
+	}
+	if got := twinVersion(merged, publishLocal); got != "1.2.3-local" {
+		t.Errorf("Expected the local twin to keep the version, got %s", got)
+	}
+
+	// A version from the build is only known to the publishers, which
+	// apply snapshot_base themselves.
+	merged.VersionSource = VersionSourceGitTag
+	merged.Versioning.SnapshotBase = snapshotBaseMajor
+	if cmd := pomCommand(merged, publishSnapshot, nil); !strings.Contains(cmd, "--snapshot-base=major --version-suffix=-SNAPSHOT") {
+		t.Errorf("Expected the snapshot POM of a git_tag bundle to take --snapshot-base, got %q", cmd)
+	}
+	if got, want := snapshotArgs(merged, "npm"), []string{"--snapshot-base=major", "--snapshot-qualifier=-next."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected npm snapshot args %v for a git_tag bundle, got %v", want, got)
+	}
+}
+
+// TestPublishMetadata checks metadata layers from lake.yaml through group.yaml
+// to bundle.yaml and reaches each publisher as the flags its manifest takes.
+func TestPublishMetadata(t *testing.T) {
+	lakeConfig := &LakeConfig{}
+	lakeConfig.Config.LanguageDefaults.Java.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Java.GroupId = "com.example"
+	lakeConfig.Config.LanguageDefaults.Java.ArtifactId = "{{.Name}}-proto"
+	lakeConfig.Config.LanguageDefaults.Python.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Python.PackagePrefix = "example"
+	lakeConfig.Config.LanguageDefaults.Javascript.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Javascript.PackageScope = "example"
+	lakeConfig.Metadata = Metadata{
+		Homepage:    "https://example.com/protos",
+		License:     "Apache-2.0",
+		LicenseURL:  "https://www.apache.org/licenses/LICENSE-2.0",
+		ScmURL:      "https://github.com/example/protos",
+		Developers:  []Developer{{Name: "Platform Team", Email: "platform@example.com"}},
+		Keywords:    []string{"protobuf"},
+		Classifiers: []string{"Programming Language :: Python :: 3"},
+	}
+
+	group := &GroupConfig{}
+	group.Metadata.ScmURL = "https://github.com/example/payments-protos"
+	group.Metadata.Developers = []Developer{{Name: "Payments Team", URL: "https://example.com/payments"}}
+
+	bundleConfig := &BundleConfig{}
+	bundleConfig.Name = "cards"
+	bundleConfig.DisplayName = "Cards API"
+	bundleConfig.Description = "Card issuing\nand the team's $PAN handling"
+	bundleConfig.Version = "1.0.0"
+	bundleConfig.Metadata.Keywords = []string{"cards", "payments"}
+
+	merged := MergeConfigurationLayers(lakeConfig, []*GroupConfig{group}, bundleConfig)
+	if merged.Metadata.License != "Apache-2.0" || merged.Metadata.Homepage != "https://example.com/protos" {
+		t.Errorf("Expected the lake's license and homepage, got %+v", merged.Metadata)
+	}
+	if merged.Metadata.ScmURL != "https://github.com/example/payments-protos" {
+		t.Errorf("Expected the group's scm_url, got %q", merged.Metadata.ScmURL)
+	}
+	if len(merged.Metadata.Developers) != 1 || merged.Metadata.Developers[0].Name != "Payments Team" {
+		t.Errorf("Expected the group's developers to replace the lake's, got %+v", merged.Metadata.Developers)
+	}
+	if !reflect.DeepEqual(merged.Metadata.Keywords, []string{"cards", "payments"}) {
+		t.Errorf("Expected the bundle's keywords, got %v", merged.Metadata.Keywords)
+	}
+
+	cmd := pomCommand(merged, "", nil)
+	for _, want := range []string{
+		"--name='Cards API' ",
+		`--description='Card issuing and the team'\''s $$PAN handling' `,
+		"--homepage=https://example.com/protos ",
+		"--license=Apache-2.0 ",
+		"--license-url=https://www.apache.org/licenses/LICENSE-2.0 ",
+		"--scm-url=https://github.com/example/payments-protos ",
+		"--developer='Payments Team (https://example.com/payments)' ",
+	} {
+		if !strings.Contains(cmd, want) {
+			t.Errorf("Expected the pom cmd to contain %q, got %q", want, cmd)
+		}
+	}
+	if strings.Contains(cmd, "--keyword") || strings.Contains(cmd, "--classifier") {
+		t.Errorf("Expected no keywords or classifiers in the POM, got %q", cmd)
+	}
+
+	c := &config.Config{RepoRoot: t.TempDir()}
+	byName := make(map[string]*rule.Rule)
+	for _, r := range generateBundleRules(merged, []string{":api_proto"}, "", c, nil) {
+		byName[r.Name()] = r
+	}
+	pypiArgs := byName["publish_cards_to_pypi"].AttrStrings("args")
+	for _, want := range []string{"--keyword=cards", "--keyword=payments", "--classifier='Programming Language :: Python :: 3'"} {
+		if !slices.Contains(pypiArgs, want) {
+			t.Errorf("Expected pypi publisher arg %q, got %v", want, pypiArgs)
+		}
+	}
+	if slices.Contains(pypiArgs, "--name='Cards API'") {
+		t.Errorf("Expected no --name on the pypi publisher, got %v", pypiArgs)
+	}
+	npmArgs := byName["publish_cards_to_npm"].AttrStrings("args")
+	if !slices.Contains(npmArgs, "--scm-url=https://github.com/example/payments-protos") || !slices.Contains(npmArgs, "--keyword=cards") {
+		t.Errorf("Expected the npm publisher to carry the scm url and keywords, got %v", npmArgs)
+	}
+	for _, arg := range npmArgs {
+		if strings.HasPrefix(arg, "--classifier") {
+			t.Errorf("Expected no classifiers on the npm publisher, got %v", npmArgs)
+		}
+	}
+
+	// Without metadata the command lines are unchanged.
+	plain := MergeConfigurations(&LakeConfig{}, &BundleConfig{Name: "plain", Version: "1.0.0"})
+	if got := metadataFlags(plain, metadataPypi); got != nil {
+		t.Errorf("Expected no metadata flags, got %v", got)
+	}
+}
+
+// TestBreakingCheck checks a breaking_check section adds the breaking check
+// test and the descriptor set it compares, and dropping it deletes the test.
+func TestBreakingCheck(t *testing.T) {
+	merged := &MergedConfig{
+		BundleName: "orders",
+		Version:    "2.0.0",
+		JavaConfig: JavaConfig{Enabled: true, GroupId: "com.example", ArtifactId: "orders-proto"},
+		BreakingCheck: &BreakingCheckConfig{
+			Baseline:        "@orders_baseline//file",
+			BaselineVersion: "1.4.0",
+		},
+	}
+	c := &config.Config{RepoRoot: t.TempDir()}
+	byName := make(map[string]*rule.Rule)
+	for _, r := range generateBundleRules(merged, []string{":api_proto"}, "", c, nil) {
+		byName[r.Name()] = r
+	}
+
+	check := byName["orders_breaking_check"]
+	if check == nil || check.Kind() != "proto_breaking_test" {
+		t.Fatalf("Expected proto_breaking_test orders_breaking_check, got %v", check)
+	}
+	for attr, want := range map[string]string{
+		"descriptor":       ":orders_descriptor",
+		"baseline":         "@orders_baseline//file",
+		"baseline_version": "1.4.0",
+		"bundle_yaml":      ":bundle.yaml",
+	} {
+		if got := check.AttrString(attr); got != want {
+			t.Errorf("Expected %s %q, got %q", attr, want, got)
+		}
+	}
+	// The check needs the descriptor set, but only generate_descriptor_set
+	// embeds it in the JAR.
+	if descriptor := byName["orders_descriptor"]; descriptor == nil || descriptor.Kind() != "proto_descriptor_set" {
+		t.Errorf("Expected proto_descriptor_set orders_descriptor for the check, got %v", descriptor)
+	}
+	if got := byName["orders_java_bundle"].AttrString("descriptor_pb"); got != "" {
+		t.Errorf("Expected no descriptor_pb without generate_descriptor_set, got %q", got)
+	}
+	// The configured check is never cleaned up.
+	for _, r := range generateLegacyCleanupRules(merged) {
+		if r.Name() == "orders_breaking_check" {
+			t.Errorf("Expected no cleanup of the configured breaking check, got an Empty %s", r.Kind())
+		}
+	}
+
+	// A git_tag bundle's check reads the stamped version.
+	stamped := *merged
+	stamped.VersionSource = VersionSourceGitTag
+	check = generateBreakingCheckRule(&stamped, "orders")
+	if got := check.AttrString("version_file"); got != ":orders_version" || check.AttrString("bundle_yaml") != "" {
+		t.Errorf("Expected version_file :orders_version and no bundle_yaml, got %q and %q", got, check.AttrString("bundle_yaml"))
+	}
+
+	merged.BreakingCheck = nil
+	var cleaned bool
+	for _, r := range generateLegacyCleanupRules(merged) {
+		cleaned = cleaned || (r.Kind() == "proto_breaking_test" && r.Name() == "orders_breaking_check")
+	}
+	if !cleaned {
+		t.Error("Expected an Empty proto_breaking_test orders_breaking_check without a breaking_check section")
+	}
+}
+
+// TestBufModule checks an opt-in buf block yields the buf.yaml genrule, the
+// BSR bundle and its publisher, with the module derived from a lake template.
+func TestBufModule(t *testing.T) {
+	lakeConfig := &LakeConfig{}
+	lakeConfig.Config.LanguageDefaults.Buf.Module = "buf.build/example/{{.Name}}"
+	lakeConfig.Config.LanguageDefaults.Buf.Deps = []string{"buf.build/googleapis/googleapis"}
+
+	bundleConfig := &BundleConfig{}
+	bundleConfig.Name = "ledger"
+	bundleConfig.Version = "1.0.0"
+
+	// Opt-in: a module template alone enables nothing.
+	merged := MergeConfigurations(lakeConfig, bundleConfig)
+	if merged.BufConfig.Enabled {
+		t.Fatal("Expected buf to stay disabled without enabled: true")
+	}
+
+	bundleConfig.Config.Languages.Buf.Enabled = boolPtr(true)
+	bundleConfig.Config.Languages.Buf.LocalRegistry = "bazel-bsr"
+	merged = MergeConfigurations(lakeConfig, bundleConfig)
+	if merged.BufConfig.Module != "buf.build/example/ledger" {
+		t.Errorf("Expected module buf.build/example/ledger, got %q", merged.BufConfig.Module)
+	}
+
+	c := &config.Config{RepoRoot: t.TempDir()}
+	byName := make(map[string]*rule.Rule)
+	for _, r := range generateBundleRules(merged, []string{":api_proto"}, "", c, nil) {
+		byName[r.Name()] = r
+	}
+
+	// The genrule cmd must write the buf.yaml once Bazel has expanded it.
+	cmd := byName["ledger_buf_yaml"].AttrString("cmd")
+	out := filepath.Join(t.TempDir(), "buf.yaml")
+	if output, err := exec.Command("sh", "-c", strings.ReplaceAll(cmd, "$@", out)).CombinedOutput(); err != nil {
+		t.Fatalf("buf.yaml cmd %q failed: %v\n%s", cmd, err, output)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Failed to read buf.yaml: %v", err)
+	}
+	want := "version: v2\nmodules:\n  - path: .\n    name: buf.build/example/ledger\ndeps:\n  - buf.build/googleapis/googleapis\n"
+	if string(data) != want {
+		t.Errorf("Expected buf.yaml:\n%s\ngot:\n%s", want, data)
+	}
+
+	bundle := byName["ledger_buf_bundle"]
+	if bundle == nil || bundle.Kind() != "buf_proto_bundle" {
+		t.Fatalf("Expected buf_proto_bundle ledger_buf_bundle, got %v", bundle)
+	}
+	if got := bundle.AttrString("buf_yaml"); got != ":ledger_buf_yaml" {
+		t.Errorf("Expected buf_yaml :ledger_buf_yaml, got %q", got)
+	}
+	wantArgs := []string{
+		"$(location :ledger_buf_bundle)",
+		"--module=buf.build/example/ledger",
+		"--bundle-yaml=$(location bundle.yaml)",
+		"--local-registry=bazel-bsr",
+	}
+	if got := byName["publish_ledger_to_buf"].AttrStrings("args"); !reflect.DeepEqual(got, wantArgs) {
+		t.Errorf("Expected publisher args %v, got %v", wantArgs, got)
+	}
+	if got := byName["publish_to_buf"].AttrString("actual"); got != ":publish_ledger_to_buf" {
+		t.Errorf("Expected publish_to_buf to point at the publisher, got %q", got)
+	}
+	if got := byName["all"].AttrStrings("targets"); !slices.Contains(got, ":ledger_buf_bundle") {
+		t.Errorf("Expected build_validation to cover the buf bundle, got %v", got)
+	}
+}
+
+func TestBundleConfigStructure(t *testing.T) {
+	// Test that we can create the basic structures without file I/O
+	bundleConfig := &BundleConfig{}
+	bundleConfig.Name = "test-bundle"
+	bundleConfig.DisplayName = "Test Bundle"
+	bundleConfig.Description = "Test bundle"
+	bundleConfig.Version = "1.0.0"
+	bundleConfig.Config.Languages.Java.Enabled = boolPtr(true)
+	bundleConfig.Config.Languages.Java.GroupId = "com.test.proto"
+	bundleConfig.Config.Languages.Java.ArtifactId = "test-proto"
+	bundleConfig.Config.Languages.Python.Enabled = boolPtr(true)
+	bundleConfig.Config.Languages.Python.PackageName = "test_proto"
+	bundleConfig.Config.Languages.Javascript.Enabled = boolPtr(true)
+	bundleConfig.Config.Languages.Javascript.PackageName = "@test/proto"
+
+	if bundleConfig.Name != "test-bundle" {
+		t.Errorf("Expected bundle name 'test-bundle', got '%s'", bundleConfig.Name)
+	}
+
+	if bundleConfig.Description != "Test bundle" {
+		t.Errorf("Expected description 'Test bundle', got '%s'", bundleConfig.Description)
+	}
+
+	if bundleConfig.Config.Languages.Java.GroupId != "com.test.proto" {
+		t.Errorf("Expected Java group_id 'com.test.proto', got '%s'", bundleConfig.Config.Languages.Java.GroupId)
+	}
+}
+
+func TestLakeConfigStructure(t *testing.T) {
+	// Test that we can create the basic structures without file I/O
+	lakeConfig := &LakeConfig{}
+	lakeConfig.Config.LanguageDefaults.Java.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Java.GroupId = "com.lake.proto"
+	lakeConfig.Config.LanguageDefaults.Java.SourceVersion = "11"
+	lakeConfig.Config.LanguageDefaults.Java.TargetVersion = "8"
+	lakeConfig.Config.LanguageDefaults.Python.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Python.PackageName = "lake_proto"
+	lakeConfig.Config.LanguageDefaults.Python.Version = ">=3.8"
+	lakeConfig.Config.LanguageDefaults.Javascript.Enabled = true
+	lakeConfig.Config.LanguageDefaults.Javascript.PackageName = "@lake/proto"
+
+	if lakeConfig.Config.LanguageDefaults.Java.GroupId != "com.lake.proto" {
+		t.Errorf("Expected Java group_id 'com.lake.proto', got '%s'", lakeConfig.Config.LanguageDefaults.Java.GroupId)
+	}
+
+	if lakeConfig.Config.LanguageDefaults.Java.SourceVersion != "11" {
+		t.Errorf("Expected Java source_version '11', got '%s'", lakeConfig.Config.LanguageDefaults.Java.SourceVersion)
+	}
+
+	if lakeConfig.Config.LanguageDefaults.Python.PackageName != "lake_proto" {
+		t.Errorf("Expected Python package_name 'lake_proto', got '%s'", lakeConfig.Config.LanguageDefaults.Python.PackageName)
+	}
+}
+
+// TestDiscoverProtoTargetsStructural covers proto_library shapes the old
+// regex scan (`proto_library\s*\(\s*[^)]*name...`) missed or mismatched: a
+// `)` before `name` (glob/select/comments), rules loaded under an alias or
+// wrapped by an alias_kind macro, and suffixed kinds like go_proto_library.
+func TestDiscoverProtoTargetsStructural(t *testing.T) {
+	repoRoot := t.TempDir()
+	pkgDir := filepath.Join(repoRoot, "com", "example")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatalf("Failed to create package dir: %v", err)
+	}
+	for _, name := range []string{"a.proto", "b.proto", "linux.proto", "skipped.proto"} {
+		if err := os.WriteFile(filepath.Join(pkgDir, name), []byte(`syntax = "proto3";`), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	buildContent := `load("@rules_proto//proto:defs.bzl", my_proto_library = "proto_library", "proto_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+load("//tools:macros.bzl", "company_proto_library")
+
+proto_library(
+    srcs = glob(["a.proto"]),
+    name = "globbed_proto",
+)
+
+proto_library(
+    # Generated by make_protos() (see tools/README)
+    name = "commented_proto",
+    srcs = ["b.proto"],
+)
+
+proto_library(
+    srcs = select({
+        "@platforms//os:linux": ["linux.proto"],
+        "//conditions:default": [],
+    }),
+    name = "selected_proto",
+)
+
+my_proto_library(
+    name = "aliased_proto",
+    srcs = ["a.proto"],
+)
+
+company_proto_library(
+    name = "macro_proto",
+    srcs = ["b.proto"],
+)
+
+proto_library(
+    name = "excluded_proto",
+    srcs = ["skipped.proto"],
+    tags = ["no-protolake"],
+)
+
+go_proto_library(
+    name = "example_go_proto",
+    protos = [":globbed_proto"],
+)
+`
+	if err := os.WriteFile(filepath.Join(pkgDir, "BUILD.bazel"), []byte(buildContent), 0644); err != nil {
+		t.Fatalf("Failed to write BUILD.bazel: %v", err)
+	}
+
+	c := &config.Config{
+		RepoRoot: repoRoot,
+		Exts:     make(map[string]interface{}),
+		AliasMap: map[string]string{"company_proto_library": "proto_library"},
+	}
+	ext := &protolakeExtension{}
+	targets := ext.discoverProtoTargetsInDirectory(c, pkgDir, "")
+
+	got := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		got[target] = true
+	}
+	for _, want := range []string{
+		"//com/example:globbed_proto",
+		"//com/example:commented_proto",
+		"//com/example:selected_proto",
+		"//com/example:aliased_proto",
+		"//com/example:macro_proto",
+	} {
+		if !got[want] {
+			t.Errorf("Expected proto target %s to be discovered, got %v", want, targets)
+		}
+	}
+	for _, unwanted := range []string{"//com/example:excluded_proto", "//com/example:example_go_proto"} {
+		if got[unwanted] {
+			t.Errorf("Target %s must not be discovered as a proto_library", unwanted)
+		}
+	}
+	if len(targets) != 5 {
+		t.Errorf("Expected exactly 5 proto targets, got %d: %v", len(targets), targets)
+	}
+
+	// srcs are read structurally too: glob() expands against the package
+	// and select() contributes every branch.
+	libs := loadProtoLibraries(c, pkgDir)
+	srcs := make(map[string][]string, len(libs))
+	for _, lib := range libs {
+		srcs[lib.Name] = lib.Srcs
+	}
+	if len(srcs["globbed_proto"]) != 1 || srcs["globbed_proto"][0] != "a.proto" {
+		t.Errorf("Expected glob srcs [a.proto], got %v", srcs["globbed_proto"])
+	}
+	if len(srcs["selected_proto"]) != 1 || srcs["selected_proto"][0] != "linux.proto" {
+		t.Errorf("Expected select srcs [linux.proto], got %v", srcs["selected_proto"])
+	}
+}
+
+// TestNestedBundles verifies that a bundle's discovery stops at a
+// subdirectory with its own bundle.yaml, and that compiling a nested
+// bundle's protos into the outer one is rejected.
+func TestNestedBundles(t *testing.T) {
+	repoRoot := t.TempDir()
+	files := map[string]string{
+		"com/example/bundle.yaml":              "name: outer\n",
+		"com/example/v1/outer.proto":           "syntax = \"proto3\";\nimport \"com/example/inner/v1/inner.proto\";\n",
+		"com/example/v1/BUILD.bazel":           "proto_library(name = \"outer_proto\", srcs = [\"outer.proto\"])\n",
+		"com/example/inner/bundle.yaml":        "name: inner\n",
+		"com/example/inner/v1/inner.proto":     "syntax = \"proto3\";\nimport \"com/example/inner/v1/deep.proto\";\n",
+		"com/example/inner/v1/deep.proto":      "syntax = \"proto3\";\n",
+		"com/example/inner/v1/BUILD.bazel":     "proto_library(name = \"inner_proto\", srcs = [\"inner.proto\", \"deep.proto\"])\n",
+		"com/example/inner/sub/v1/BUILD.bazel": "proto_library(name = \"sub_proto\", srcs = [])\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(repoRoot, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir for %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	c := &config.Config{RepoRoot: repoRoot, Exts: make(map[string]interface{})}
+	pe := NewLanguage().(*protolakeExtension)
+	outerDir := filepath.Join(repoRoot, "com", "example")
+
+	args := language.GenerateArgs{Config: c, Dir: outerDir, Rel: "com/example"}
+	if got := pe.discoverExistingProtoTargets(args, "outer"); !reflect.DeepEqual(got, []string{"//com/example/v1:outer_proto"}) {
+		t.Errorf("Expected the outer bundle to stop at the inner bundle, got %v", got)
+	}
+	innerDir := filepath.Join(outerDir, "inner")
+	innerArgs := language.GenerateArgs{Config: c, Dir: innerDir, Rel: "com/example/inner"}
+	if got := pe.discoverExistingProtoTargets(innerArgs, "inner"); !reflect.DeepEqual(got, []string{"//com/example/inner/sub/v1:sub_proto", "//com/example/inner/v1:inner_proto"}) {
+		t.Errorf("Expected the inner bundle's own targets, got %v", got)
+	}
+
+	// The outer bundle's imports of the inner bundle are cross-bundle
+	// imports; the inner bundle's own imports aren't the outer's.
+	if got := collectBundleImports(outerDir, nil); !reflect.DeepEqual(got, []string{"com/example/inner/v1/inner.proto"}) {
+		t.Errorf("Expected only the outer protos' imports, got %v", got)
+	}
+
+	// Compiling the inner bundle's protos into the outer one publishes them
+	// twice in every language both bundles publish.
+	outer := &MergedConfig{BundleName: "outer", JavaConfig: JavaConfig{Enabled: true}, PythonConfig: PythonConfig{Enabled: true}}
+	pe.bundleConfigs["com/example/inner"] = &MergedConfig{BundleName: "inner", JavaConfig: JavaConfig{Enabled: true}}
+	inner := label.New("", "com/example/inner/v1", "inner_proto")
+	nested := map[string][]label.Label{"com/example/inner": {inner, inner}}
+	err := pe.publishedTwice(c, outer, nested)
+	if err == nil {
+		t.Fatal("Expected the inner bundle's protos compiled into the outer bundle to be rejected")
+	}
+	if want := `//com/example/inner/v1:inner_proto, published by bundle "inner" at //com/example/inner for java`; !strings.Contains(err.Error(), want) {
+		t.Errorf("Expected the error to name the target, its bundle and the language (%q), got %v", want, err)
+	}
+	if n := strings.Count(err.Error(), "inner_proto"); n != 1 {
+		t.Errorf("Expected the target listed once, got %d in %v", n, err)
+	}
+
+	// A bundle not publishing a language leaves its protos to the
+	// importers to compile for it.
+	pe.bundleConfigs["com/example/inner"] = &MergedConfig{BundleName: "inner", JavaScriptConfig: JavaScriptConfig{Enabled: true}}
+	if err := pe.publishedTwice(c, outer, nested); err != nil {
+		t.Errorf("Expected no conflict without a shared language, got %v", err)
+	}
+	if err := pe.publishedTwice(c, outer, nil); err != nil {
+		t.Errorf("Expected no conflict without compiled-in protos, got %v", err)
+	}
+}
+
+// TestBuildImportIndexImportPrefixes verifies the import index keys each
+// proto under the path Bazel exposes it at, honoring strip_import_prefix
+// (package-relative and repo-absolute) and import_prefix.
+func TestBuildImportIndexImportPrefixes(t *testing.T) {
+	repoRoot := t.TempDir()
+
+	writeBuild := func(rel, content string) {
+		t.Helper()
+		dir := filepath.Join(repoRoot, rel)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "BUILD.bazel"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write BUILD.bazel in %s: %v", dir, err)
+		}
+	}
+
+	writeBuild("plain", `proto_library(name = "plain_proto", srcs = ["plain.proto"])`)
+	writeBuild("third_party/vendor/proto", `proto_library(
+    name = "vendored_proto",
+    srcs = ["acme/v1/acme.proto"],
+    strip_import_prefix = "/third_party/vendor/proto",
+)`)
+	writeBuild("relocated", `proto_library(
+    name = "relocated_proto",
+    srcs = ["r.proto"],
+    strip_import_prefix = "",
+    import_prefix = "company/shared",
+)`)
+	writeBuild("relative", `proto_library(
+    name = "relative_proto",
+    srcs = ["src/rel.proto"],
+    strip_import_prefix = "src",
+)`)
+
+	c := &config.Config{RepoRoot: repoRoot, Exts: make(map[string]interface{})}
+	index := NewLanguage().(*protolakeExtension).importIndex(c)
+
+	want := map[string]string{
+		"plain/plain.proto":                "//plain:plain_proto",
+		"acme/v1/acme.proto":               "//third_party/vendor/proto:vendored_proto",
+		"company/shared/relocated/r.proto": "//relocated:relocated_proto",
+		"rel.proto":                        "//relative:relative_proto",
+	}
+	for importPath, target := range want {
+		if index[importPath] != target {
+			t.Errorf("Expected import %s to resolve to %s, got %q", importPath, target, index[importPath])
+		}
+	}
+	if len(index) != len(want) {
+		t.Errorf("Expected %d indexed imports, got %d: %v", len(want), len(index), index)
+	}
+}
+
+// TestImportIndexWalk checks the index walk skips .bazelignore'd paths and
+// gazelle-excluded directories (from Configure and from BUILD files it parses),
+// is built once, and tracks packages gazelle generates after it was built.
+func TestImportIndexWalk(t *testing.T) {
+	repoRoot := t.TempDir()
+
+	writeBuild := func(rel, content string) {
+		t.Helper()
+		dir := filepath.Join(repoRoot, rel)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "BUILD.bazel"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write BUILD.bazel in %s: %v", dir, err)
+		}
+	}
+
+	writeBuild("kept", `proto_library(name = "kept_proto", srcs = ["kept.proto"])`)
+	writeBuild("ignored", `proto_library(name = "ignored_proto", srcs = ["ignored.proto"])`)
+	writeBuild("vendor", `# gazelle:exclude old
+`)
+	writeBuild("vendor/old", `proto_library(name = "old_proto", srcs = ["old.proto"])`)
+	writeBuild("generated/out", `proto_library(name = "out_proto", srcs = ["out.proto"])`)
+	if err := os.WriteFile(filepath.Join(repoRoot, ".bazelignore"), []byte("# build outputs\nignored/\n"), 0644); err != nil {
+		t.Fatalf("Failed to write .bazelignore: %v", err)
+	}
+
+	c := &config.Config{RepoRoot: repoRoot, Exts: make(map[string]interface{})}
+	ext := NewLanguage().(*protolakeExtension)
+	root, err := rule.LoadData(filepath.Join(repoRoot, "BUILD.bazel"), "", []byte("# gazelle:exclude generated\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse root BUILD: %v", err)
+	}
+	ext.Configure(c, "", root)
+
+	index := ext.importIndex(c)
+	if index["kept/kept.proto"] != "//kept:kept_proto" {
+		t.Errorf("Expected kept/kept.proto to be indexed, got %v", index)
+	}
+	for _, excluded := range []string{"ignored/ignored.proto", "vendor/old/old.proto", "generated/out/out.proto"} {
+		if target, ok := index[excluded]; ok {
+			t.Errorf("Expected %s to be skipped by the walk, got %s", excluded, target)
+		}
+	}
+
+	// Regenerating a package after the walk updates the cached index in place.
+	gen := rule.NewRule("proto_library", "renamed_proto")
+	gen.SetAttr("srcs", []string{"kept.proto"})
+	ext.recordProtoPackage(language.GenerateArgs{
+		Config:   c,
+		Dir:      filepath.Join(repoRoot, "kept"),
+		Rel:      "kept",
+		OtherGen: []*rule.Rule{gen},
+	})
+	if got := ext.importIndex(c)["kept/kept.proto"]; got != "//kept:renamed_proto" {
+		t.Errorf("Expected the cached index to follow the regenerated package, got %q", got)
+	}
+}
+
+// BenchmarkImportIndex compares rebuilding the import index for every bundle
+// (the old behaviour) with building it once per run, over a synthetic 80-bundle
+// lake with a few packages per bundle.
+func BenchmarkImportIndex(b *testing.B) {
+	const bundles, packagesPerBundle = 80, 4
+	repoRoot := b.TempDir()
+	for i := 0; i < bundles; i++ {
+		for j := 0; j < packagesPerBundle; j++ {
+			dir := filepath.Join(repoRoot, "com", "example", fmt.Sprintf("bundle%d", i), fmt.Sprintf("v%d", j))
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				b.Fatalf("Failed to create %s: %v", dir, err)
+			}
+			build := fmt.Sprintf("proto_library(\n    name = \"v%d_proto\",\n    srcs = [\"api.proto\", \"types.proto\"],\n)\n", j)
+			if err := os.WriteFile(filepath.Join(dir, "BUILD.bazel"), []byte(build), 0644); err != nil {
+				b.Fatalf("Failed to write BUILD.bazel: %v", err)
+			}
+		}
+	}
+	c := &config.Config{RepoRoot: repoRoot, Exts: make(map[string]interface{})}
+
+	b.Run("PerBundle", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for i := 0; i < bundles; i++ {
+				NewLanguage().(*protolakeExtension).importIndex(c)
+			}
+		}
+	})
+	b.Run("OncePerRun", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			ext := NewLanguage().(*protolakeExtension)
+			for i := 0; i < bundles; i++ {
+				ext.importIndex(c)
+			}
+		}
+	})
+}
+
+// stubProtoResolver stands in for gazelle's proto extension in the RuleIndex:
+// it indexes proto_library srcs under the "proto" language.
+type stubProtoResolver struct{}
+
+func (stubProtoResolver) Name() string { return protoLang }
+
+func (stubProtoResolver) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	var specs []resolve.ImportSpec
+	for _, src := range r.AttrStrings("srcs") {
+		specs = append(specs, resolve.ImportSpec{Lang: protoLang, Imp: path.Join(f.Pkg, src)})
+	}
+	return specs
+}
+
+func (stubProtoResolver) Embeds(r *rule.Rule, from label.Label) []label.Label { return nil }
+
+func (stubProtoResolver) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+}
+
+// newResolveConfig returns a config with gazelle's resolve extension set up,
+// as `# gazelle:resolve` lookups require, rooted at a fresh temp dir.
+func newResolveConfig(t *testing.T) (*config.Config, *resolve.Configurer) {
+	c := config.New()
+	c.RepoRoot = t.TempDir()
+	rc := &resolve.Configurer{}
+	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
+	rc.RegisterFlags(fs, "update", c)
+	if err := rc.CheckFlags(fs, c); err != nil {
+		t.Fatalf("CheckFlags: %v", err)
+	}
+	return c, rc
+}
+
+// TestImportsAndResolve checks bundle rules advertise the imports their bundle
+// provides, and compile rules resolve the bundle's imports through
+// `# gazelle:resolve proto` overrides and the RuleIndex.
+func TestImportsAndResolve(t *testing.T) {
+	c, rc := newResolveConfig(t)
+	root, err := rule.LoadData(filepath.Join(c.RepoRoot, "BUILD.bazel"), "",
+		[]byte("# gazelle:resolve proto vendor/acme/v1/acme.proto //third_party/acme:acme_proto\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse root BUILD: %v", err)
+	}
+	rc.Configure(c, "", root)
+
+	ext := NewLanguage().(*protolakeExtension)
+	ext.bundleProvides["com/shared"] = []string{"com/shared/v1/money.proto"}
+
+	sharedFile := rule.EmptyFile(filepath.Join(c.RepoRoot, "com", "shared", "BUILD.bazel"), "com/shared")
+	pyBundle := rule.NewRule("py_proto_bundle", "shared_py_bundle")
+	want := []resolve.ImportSpec{{Lang: protoLang, Imp: "com/shared/v1/money.proto"}}
+	if got := ext.Imports(c, pyBundle, sharedFile); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected py_proto_bundle to advertise %v, got %v", want, got)
+	}
+	if got := ext.Imports(c, rule.NewRule("py_binary", "publish"), sharedFile); got != nil {
+		t.Errorf("Expected py_binary to advertise nothing, got %v", got)
+	}
+
+	ix := resolve.NewRuleIndex(func(r *rule.Rule, pkgRel string) resolve.Resolver {
+		if r.Kind() == protoLibraryKind {
+			return stubProtoResolver{}
+		}
+		return nil
+	})
+	money := rule.NewRule(protoLibraryKind, "money_proto")
+	money.SetAttr("srcs", []string{"money.proto"})
+	ix.AddRule(c, money, rule.EmptyFile(filepath.Join(c.RepoRoot, "com", "shared", "v1", "BUILD.bazel"), "com/shared/v1"))
+	ix.Finish()
+
+	grpc := rule.NewRule("python_grpc_library", "orders_python_grpc")
+	grpc.SetAttr("protos", []string{"//com/orders/api/v1:api_proto"})
+	ext.Resolve(c, ix, nil, grpc, &bundleImports{
+		config: &MergedConfig{BundleName: "orders", BundleDependencies: BundleDependenciesCompile},
+		imports: []string{
+			"com/orders/api/v1/types.proto", // unresolvable: logged and skipped
+			"com/shared/v1/money.proto",
+			"vendor/acme/v1/acme.proto",
+		},
+	}, label.New("", "com/orders", "orders_python_grpc"))
+
+	wantProtos := []string{
+		"//com/orders/api/v1:api_proto",
+		"//com/shared/v1:money_proto",
+		"//third_party/acme:acme_proto",
+	}
+	if got := grpc.AttrStrings("protos"); !reflect.DeepEqual(got, wantProtos) {
+		t.Errorf("Expected protos %v, got %v", wantProtos, got)
+	}
+}
+
+// TestArtifactBundleDependencies checks that in artifact mode an import owned
+// by another bundle is declared as that bundle's artifact for each language it
+// publishes, and still compiled in for the languages it doesn't.
+func TestArtifactBundleDependencies(t *testing.T) {
+	c, _ := newResolveConfig(t)
+	if err := os.MkdirAll(filepath.Join(c.RepoRoot, "com", "shared", "v1"), 0755); err != nil {
+		t.Fatalf("Failed to create shared package: %v", err)
+	}
+
+	ext := NewLanguage().(*protolakeExtension)
+	ext.protoPackages["com/shared/v1"] = []protoLibraryInfo{{Name: "money_proto", Srcs: []string{"money.proto"}}}
+	// shared publishes Java and Python, not JavaScript.
+	ext.bundleConfigs["com/shared"] = &MergedConfig{
+		BundleName:   "shared",
+		Version:      "2.1.0",
+		JavaConfig:   JavaConfig{Enabled: true, GroupId: "com.example", ArtifactId: "shared-proto"},
+		PythonConfig: PythonConfig{Enabled: true, PackageName: "example-shared"},
+	}
+	orders := &MergedConfig{
+		BundleName:         "orders",
+		Version:            "1.0.0",
+		BundleDependencies: BundleDependenciesArtifact,
+		JavaConfig:         JavaConfig{Enabled: true, GroupId: "com.example", ArtifactId: "orders-proto"},
+		PythonConfig:       PythonConfig{Enabled: true, PackageName: "example-orders"},
+		JavaScriptConfig:   JavaScriptConfig{Enabled: true, PackageName: "@example/orders"},
+	}
+	ext.bundleConfigs["com/orders"] = orders
+
+	rules := generateBundleRules(orders, []string{"//com/orders/api/v1:api_proto"}, "com/orders", c, nil)
+	payload := &bundleImports{config: orders, imports: []string{"com/shared/v1/money.proto"}}
+	byName := make(map[string]*rule.Rule)
+	for _, r := range rules {
+		ext.Resolve(c, nil, nil, r, payload, label.New("", "com/orders", r.Name()))
+		byName[r.Name()] = r
+	}
+
+	own := []string{"//com/orders/api/v1:api_proto"}
+	if got := byName["orders_java_grpc"].AttrStrings("protos"); !reflect.DeepEqual(got, own) {
+		t.Errorf("Expected java_grpc_library protos %v, got %v", own, got)
+	}
+	if got := byName["orders_java_grpc"].AttrStrings("deps"); !slices.Contains(got, "//com/shared:shared_java_grpc") {
+		t.Errorf("Expected java_grpc_library deps on the shared Java library, got %v", got)
+	}
+	if got := byName["orders_python_grpc"].AttrStrings("protos"); !reflect.DeepEqual(got, own) {
+		t.Errorf("Expected python_grpc_library protos %v, got %v", own, got)
+	}
+	compiled := []string{"//com/orders/api/v1:api_proto", "//com/shared/v1:money_proto"}
+	if got := byName["orders_es_proto"].AttrStrings("protos"); !reflect.DeepEqual(got, compiled) {
+		t.Errorf("Expected es_proto_compile to compile in the protos of a bundle without JS, got %v", got)
+	}
+
+	if cmd := byName["orders_pom"].AttrString("cmd"); !strings.Contains(cmd, "--dependency com.example:shared-proto:2.1.0 ") {
+		t.Errorf("Expected the pom cmd to declare the shared artifact, got %q", cmd)
+	}
+	if cmd := byName["orders_pom_local"].AttrString("cmd"); !strings.Contains(cmd, "--dependency com.example:shared-proto:2.1.0-local ") {
+		t.Errorf("Expected the local pom cmd to declare the shared local artifact, got %q", cmd)
+	}
+	if args := byName["publish_orders_to_pypi"].AttrStrings("args"); !slices.Contains(args, "--requirement=example-shared==2.1.0") {
+		t.Errorf("Expected the pypi publisher to require the shared wheel, got %v", args)
+	}
+	if args := byName["publish_orders_to_pypi_local"].AttrStrings("args"); !slices.Contains(args, "--requirement=example-shared==2.1.0+local") {
+		t.Errorf("Expected the local pypi publisher to require the shared local wheel, got %v", args)
+	}
+	// Snapshots depend on the other bundles' releases.
+	if cmd := byName["orders_pom_snapshot"].AttrString("cmd"); !strings.Contains(cmd, "--dependency com.example:shared-proto:2.1.0 ") {
+		t.Errorf("Expected the snapshot pom cmd to declare the shared release, got %q", cmd)
+	}
+	if args := byName["publish_orders_to_pypi_snapshot"].AttrStrings("args"); !slices.Contains(args, "--requirement=example-shared==2.1.0") {
+		t.Errorf("Expected the snapshot pypi publisher to require the shared release wheel, got %v", args)
+	}
+	for _, arg := range byName["publish_orders_to_npm"].AttrStrings("args") {
+		if strings.HasPrefix(arg, "--dependency=") {
+			t.Errorf("Expected no npm dependency on a bundle without JS, got %q", arg)
+		}
+	}
+
+	// compile mode leaves every artifact self-contained.
+	orders.BundleDependencies = BundleDependenciesCompile
+	pom := rule.NewRule("genrule", "orders_pom")
+	pom.SetAttr("cmd", pomCommand(orders, "", nil))
+	grpc := rule.NewRule("java_grpc_library", "orders_java_grpc")
+	grpc.SetAttr("protos", own)
+	payload = &bundleImports{config: orders, imports: []string{"com/shared/v1/money.proto"}}
+	for _, r := range []*rule.Rule{pom, grpc} {
+		ext.Resolve(c, nil, nil, r, payload, label.New("", "com/orders", r.Name()))
+	}
+	if cmd := pom.AttrString("cmd"); strings.Contains(cmd, "--dependency") {
+		t.Errorf("Expected no --dependency in compile mode, got %q", cmd)
+	}
+	if got := grpc.AttrStrings("protos"); !reflect.DeepEqual(got, compiled) {
+		t.Errorf("Expected compile mode to compile in the shared protos, got %v", got)
+	}
+}
+
+// TestTransitiveBundleImports checks the import walk: protos no bundle owns
+// are followed transitively by default, including through an import cycle,
+// another bundle's protos only in transitive mode, and nothing in direct mode.
+func TestTransitiveBundleImports(t *testing.T) {
+	c, _ := newResolveConfig(t)
+	protos := map[string]string{
+		"lib/a/a.proto":             `import "lib/b/b.proto";`,
+		"lib/b/b.proto":             `import "lib/c/c.proto";`,
+		"lib/c/c.proto":             `import "lib/b/b.proto";`, // cycle back to b
+		"lib/d/d.proto":             ``,
+		"com/shared/v1/money.proto": `import "lib/d/d.proto";`,
+	}
+	for file, body := range protos {
+		dir := filepath.Join(c.RepoRoot, filepath.Dir(file))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+		name := filepath.Base(file)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("syntax = \"proto3\";\n"+body+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", file, err)
+		}
+		build := fmt.Sprintf("proto_library(\n    name = %q,\n    srcs = [%q],\n)\n", strings.TrimSuffix(name, ".proto")+"_proto", name)
+		if err := os.WriteFile(filepath.Join(dir, "BUILD.bazel"), []byte(build), 0644); err != nil {
+			t.Fatalf("Failed to write BUILD for %s: %v", file, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(c.RepoRoot, "com", "shared", "bundle.yaml"), []byte("name: shared\n"), 0644); err != nil {
+		t.Fatalf("Failed to write bundle.yaml: %v", err)
+	}
+
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{"", []string{"//com/shared/v1:money_proto", "//lib/a:a_proto", "//lib/b:b_proto", "//lib/c:c_proto"}},
+		{DependencyModeTransitive, []string{"//com/shared/v1:money_proto", "//lib/a:a_proto", "//lib/b:b_proto", "//lib/c:c_proto", "//lib/d:d_proto"}},
+		{DependencyModeDirect, []string{"//com/shared/v1:money_proto", "//lib/a:a_proto"}},
+	}
+	for _, tt := range tests {
+		t.Run("mode="+tt.mode, func(t *testing.T) {
+			ext := NewLanguage().(*protolakeExtension)
+			grpc := rule.NewRule("python_grpc_library", "orders_python_grpc")
+			ext.Resolve(c, nil, nil, grpc, &bundleImports{
+				config:  &MergedConfig{BundleName: "orders", BundleDependencies: BundleDependenciesCompile, DependencyMode: tt.mode},
+				imports: []string{"com/shared/v1/money.proto", "lib/a/a.proto"},
+			}, label.New("", "com/orders", "orders_python_grpc"))
+			if got := grpc.AttrStrings("protos"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected protos %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestLakePublishAll checks the lake root's publish_all targets run every
+// bundle's release publishers with the bundles it imports first, and that a
+// registry no bundle publishes to has its target deleted.
+func TestLakePublishAll(t *testing.T) {
+	c, _ := newResolveConfig(t)
+	if err := os.WriteFile(filepath.Join(c.RepoRoot, "lake.yaml"), []byte("config: {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write lake.yaml: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(c.RepoRoot, "com", "shared", "v1"), 0755); err != nil {
+		t.Fatalf("Failed to create shared package: %v", err)
+	}
+
+	ext := NewLanguage().(*protolakeExtension)
+	ext.protoPackages["com/shared/v1"] = []protoLibraryInfo{{Name: "money_proto", Srcs: []string{"money.proto"}}}
+	bundles := map[string]*bundleImports{
+		// audit sorts first and imports nothing.
+		"com/audit": {config: &MergedConfig{BundleName: "audit", GoConfig: GoConfig{Enabled: true}}},
+		// orders sorts before shared but imports it, in compile mode too.
+		"com/orders": {
+			config: &MergedConfig{
+				BundleName:       "orders",
+				JavaConfig:       JavaConfig{Enabled: true},
+				JavaScriptConfig: JavaScriptConfig{Enabled: true, ProtoLoader: true},
+			},
+			imports: []string{"com/shared/v1/money.proto"},
+		},
+		"com/shared": {config: &MergedConfig{BundleName: "shared", JavaConfig: JavaConfig{Enabled: true}}},
+	}
+	for pkg, bi := range bundles {
+		bi.config.BundleDependencies = BundleDependenciesCompile
+		ext.bundleConfigs[pkg] = bi.config
+		ext.recordLakeBundle(c, pkg, bi)
+	}
+
+	result := ext.generateLakePublishRules(language.GenerateArgs{Config: c, Dir: c.RepoRoot, Rel: ""})
+	byName := make(map[string]*rule.Rule)
+	for i, r := range result.Gen {
+		if r.Kind() != "multirun" {
+			t.Errorf("Expected %s to be a multirun, got %s", r.Name(), r.Kind())
+		}
+		if got := formatExpr(r.Attr("jobs")); got != "1" {
+			t.Errorf("%s: expected jobs = 1, got %s", r.Name(), got)
+		}
+		ext.Resolve(c, nil, nil, r, result.Imports[i], label.New("", "", r.Name()))
+		byName[r.Name()] = r
+	}
+
+	want := map[string][]string{
+		"publish_all": {
+			"//com/audit:publish_audit_to_goproxy",
+			"//com/shared:publish_shared_to_maven",
+			"//com/orders:publish_orders_to_maven",
+			"//com/orders:publish_orders_to_npm",
+			"//com/orders:publish_orders_proto_loader_to_npm",
+		},
+		"publish_all_maven":   {"//com/shared:publish_shared_to_maven", "//com/orders:publish_orders_to_maven"},
+		"publish_all_npm":     {"//com/orders:publish_orders_to_npm", "//com/orders:publish_orders_proto_loader_to_npm"},
+		"publish_all_goproxy": {"//com/audit:publish_audit_to_goproxy"},
+	}
+	if len(byName) != len(want) {
+		t.Errorf("Expected %d publish_all targets, got %d", len(want), len(byName))
+	}
+	for name, commands := range want {
+		r := byName[name]
+		if r == nil {
+			t.Errorf("Expected a %s target", name)
+			continue
+		}
+		if got := r.AttrStrings("commands"); !reflect.DeepEqual(got, commands) {
+			t.Errorf("%s: expected commands %v, got %v", name, commands, got)
+		}
+	}
+
+	var empty []string
+	for _, r := range result.Empty {
+		empty = append(empty, r.Kind()+" "+r.Name())
+	}
+	if wantEmpty := []string{"multirun publish_all_pypi", "multirun publish_all_buf"}; !reflect.DeepEqual(empty, wantEmpty) {
+		t.Errorf("Expected the unused registries' targets deleted, got %v", empty)
+	}
+}
+
+// TestBundleGraph checks the graph export: each bundle with its targets and
+// coordinates, imports followed through protos no bundle owns to the bundle
+// owning them, unresolved imports kept, and external providers per bundle.
+func TestBundleGraph(t *testing.T) {
+	c, _ := newResolveConfig(t)
+	protos := map[string]string{
+		"com/shared/v1/money.proto":     ``,
+		"lib/common/types.proto":        `import "com/shared/v1/money.proto";`,
+		"com/orders/api/v1/order.proto": `import "com/orders/api/v1/item.proto"; import "lib/common/types.proto"; import "acme/v1/acme.proto"; import "missing/v1/gone.proto";`,
+		"com/orders/api/v1/item.proto":  ``,
+	}
+	for file, body := range protos {
+		dir := filepath.Join(c.RepoRoot, filepath.Dir(file))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(c.RepoRoot, file), []byte("syntax = \"proto3\";\n"+body+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", file, err)
+		}
+	}
+	for pkg, build := range map[string]string{
+		"com/shared/v1":     `proto_library(name = "money_proto", srcs = ["money.proto"])`,
+		"lib/common":        `proto_library(name = "types_proto", srcs = ["types.proto"])`,
+		"com/orders/api/v1": `proto_library(name = "api_proto", srcs = ["item.proto", "order.proto"])`,
+	} {
+		if err := os.WriteFile(filepath.Join(c.RepoRoot, pkg, "BUILD.bazel"), []byte(build+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write BUILD for %s: %v", pkg, err)
+		}
+	}
+
+	acme := []ExternalProtoProvider{{Prefix: "acme/", ProtoLibraries: []string{"@acme//proto:acme_proto"}}}
+	ext := NewLanguage().(*protolakeExtension)
+	bundles := []struct {
+		rel     string
+		config  *MergedConfig
+		targets []string
+	}{
+		{"com/shared", &MergedConfig{
+			BundleName: "shared", Version: "2.1.0", VersionSource: VersionSourceLiteral,
+			JavaConfig: JavaConfig{Enabled: true, GroupId: "com.example", ArtifactId: "shared-proto"},
+		}, []string{"//com/shared/v1:money_proto"}},
+		{"com/orders", &MergedConfig{
+			BundleName: "orders", VersionSource: VersionSourceGitTag, ExternalProtos: acme,
+			PythonConfig:     PythonConfig{Enabled: true, PackageName: "example-orders"},
+			JavaScriptConfig: JavaScriptConfig{Enabled: true, PackageName: "@example/orders", ProtoLoader: true},
+		}, []string{"//com/orders/api/v1:api_proto"}},
+	}
+	for _, b := range bundles {
+		ext.bundleConfigs[b.rel] = b.config
+		dir := filepath.Join(c.RepoRoot, b.rel)
+		ext.recordGraphBundle(language.GenerateArgs{Config: c, Dir: dir, Rel: b.rel},
+			b.config, b.targets, collectBundleImports(dir, b.config.ExternalProtos))
+	}
+
+	out := filepath.Join(t.TempDir(), "out", "graph")
+	if err := ext.writeGraph(out); err != nil {
+		t.Fatalf("writeGraph: %v", err)
+	}
+	data, err := os.ReadFile(out + ".json")
+	if err != nil {
+		t.Fatalf("Failed to read the JSON graph: %v", err)
+	}
+	var graph bundleGraph
+	if err := json.Unmarshal(data, &graph); err != nil {
+		t.Fatalf("Failed to parse the JSON graph: %v\n%s", err, data)
+	}
+
+	wantBundles := []graphBundle{
+		{
+			Name: "orders", Package: "com/orders", VersionSource: VersionSourceGitTag,
+			ProtoTargets: []string{"//com/orders/api/v1:api_proto"},
+			Coordinates:  map[string]string{"pypi": "example-orders", "npm": "@example/orders", "npm_proto_loader": "@example/orders-loader"},
+			External:     &graphExternal{ProtoLibraries: []string{"@acme//proto:acme_proto"}},
+		},
+		{
+			Name: "shared", Package: "com/shared", Version: "2.1.0", VersionSource: VersionSourceLiteral,
+			ProtoTargets: []string{"//com/shared/v1:money_proto"},
+			Coordinates:  map[string]string{"maven": "com.example:shared-proto"},
+		},
+	}
+	if !reflect.DeepEqual(graph.Bundles, wantBundles) {
+		t.Errorf("Expected bundles %+v, got %+v", wantBundles, graph.Bundles)
+	}
+	wantImports := []graphImport{
+		{From: "com/orders", Import: "lib/common/types.proto", Target: "//lib/common:types_proto"},
+		{From: "com/orders", Import: "com/shared/v1/money.proto", Via: "lib/common/types.proto",
+			Target: "//com/shared/v1:money_proto", Bundle: "com/shared"},
+		{From: "com/orders", Import: "missing/v1/gone.proto"},
+	}
+	if !reflect.DeepEqual(graph.Imports, wantImports) {
+		t.Errorf("Expected imports %+v, got %+v", wantImports, graph.Imports)
+	}
+
+	dot, err := os.ReadFile(out + ".dot")
+	if err != nil {
+		t.Fatalf("Failed to read the DOT graph: %v", err)
+	}
+	for _, line := range []string{
+		`"//com/shared" [shape = box, label = "shared\n2.1.0"];`,
+		`"//com/orders" -> "//lib/common:types_proto" [label = "lib/common/types.proto"];`,
+		`"//lib/common:types_proto" -> "//com/shared" [label = "com/shared/v1/money.proto"];`,
+		`"//com/orders" -> "@acme//proto:acme_proto" [style = dashed];`,
+	} {
+		if !strings.Contains(string(dot), line) {
+			t.Errorf("Expected %s in the DOT graph:\n%s", line, dot)
+		}
+	}
+}
+
+// TestExternalProtoProviders checks lake.yaml's external_protos table: an
+// entry replaces the built-in provider with the same prefix, new prefixes
+// are added, the defaults stay in effect otherwise, and imports a provider
+// covers are not resolved in the repo.
+func TestExternalProtoProviders(t *testing.T) {
+	lakeDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(lakeDir, "lake.yaml"), []byte(`config:
+  external_protos:
+    - prefix: "google/api/"
+      java: ["@googleapis//google/api:api_java_proto"]
+      proto_libraries: ["@googleapis//google/api:annotations_proto"]
+    - prefix: "google/type/"
+      java: ["@googleapis//google/type:type_java_proto"]
+      proto_libraries: ["@googleapis//google/type:date_proto"]
+      go: ["@org_golang_google_genproto//googleapis/type/date"]
+    - prefix: "opentelemetry/"
+      proto_libraries: ["@opentelemetry-proto//:trace_proto"]
+`), 0644); err != nil {
+		t.Fatalf("Failed to write lake.yaml: %v", err)
+	}
+	lakeConfig, err := LoadLakeConfig(lakeDir)
+	if err != nil {
+		t.Fatalf("Failed to load lake.yaml: %v", err)
+	}
+	bundleConfig := &BundleConfig{}
+	bundleConfig.Name = "events"
+	merged := MergeConfigurations(lakeConfig, bundleConfig)
+
+	var prefixes []string
+	for _, p := range merged.ExternalProtos {
+		prefixes = append(prefixes, p.Prefix)
+	}
+	wantPrefixes := []string{"google/api/", "google/longrunning/", "buf/validate/", "google/type/", "opentelemetry/"}
+	if !reflect.DeepEqual(prefixes, wantPrefixes) {
+		t.Errorf("Expected providers %v, got %v", wantPrefixes, prefixes)
+	}
+
+	bundleDir := filepath.Join(lakeDir, "events")
+	if err := os.MkdirAll(bundleDir, 0755); err != nil {
+		t.Fatalf("Failed to create bundle dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(bundleDir, "events.proto"), []byte(`syntax = "proto3";
+import "google/api/annotations.proto";
+import "google/type/date.proto";
+import "buf/validate/validate.proto";
+import "opentelemetry/proto/trace/v1/trace.proto";
+import "com/shared/v1/money.proto";
+`), 0644); err != nil {
+		t.Fatalf("Failed to write proto: %v", err)
+	}
+
+	deps := detectExternalProtoImports(bundleDir, merged.ExternalProtos)
+	wantJava := []string{"@googleapis//google/api:api_java_proto", "//:protovalidate_java_proto", "@googleapis//google/type:type_java_proto"}
+	if !reflect.DeepEqual(deps.Java, wantJava) {
+		t.Errorf("Expected Java deps %v, got %v", wantJava, deps.Java)
+	}
+	wantProtos := []string{
+		"@googleapis//google/api:annotations_proto",
+		"@protovalidate//proto/protovalidate/buf/validate:validate_proto",
+		"@googleapis//google/type:date_proto",
+		"@opentelemetry-proto//:trace_proto",
+	}
+	if !reflect.DeepEqual(deps.ProtoLibraries, wantProtos) {
+		t.Errorf("Expected proto_library deps %v, got %v", wantProtos, deps.ProtoLibraries)
+	}
+	wantGo := []string{"@build_buf_gen_go_bufbuild_protovalidate_protocolbuffers_go//buf/validate", "@org_golang_google_genproto//googleapis/type/date"}
+	if !reflect.DeepEqual(deps.Go, wantGo) {
+		t.Errorf("Expected Go deps %v, got %v", wantGo, deps.Go)
+	}
+
+	if got := collectBundleImports(bundleDir, merged.ExternalProtos); !reflect.DeepEqual(got, []string{"com/shared/v1/money.proto"}) {
+		t.Errorf("Expected only the in-repo import to need resolving, got %v", got)
+	}
+}
+
+// TestEffectiveProtoLibraries checks the in-memory view of a package matches
+// what gazelle's merge writes: generated rules matched by srcs keep the
+// existing name, emptied rules drop out, brand-new rules appear, and an
+// unvisited package falls back to its BUILD file on disk.
+func TestEffectiveProtoLibraries(t *testing.T) {
+	repoRoot := t.TempDir()
+	pkgDir := filepath.Join(repoRoot, "com", "example")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatalf("Failed to create package dir: %v", err)
+	}
+
+	f, err := rule.LoadData(filepath.Join(pkgDir, "BUILD.bazel"), "com/example", []byte(`proto_library(
+    name = "hand_named",
+    srcs = ["a.proto"],
+    tags = ["no-protolake"],
+)
+
+proto_library(
+    name = "stale_proto",
+    srcs = ["deleted.proto"],
+)
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse BUILD: %v", err)
+	}
+
+	genA := rule.NewRule("proto_library", "example_proto")
+	genA.SetAttr("srcs", []string{"a.proto"})
+	genB := rule.NewRule("proto_library", "b_proto")
+	genB.SetAttr("srcs", []string{"b.proto"})
+
+	c := &config.Config{RepoRoot: repoRoot, Exts: make(map[string]interface{})}
+	ext := NewLanguage().(*protolakeExtension)
+	ext.recordProtoPackage(language.GenerateArgs{
+		Config:     c,
+		Dir:        pkgDir,
+		Rel:        "com/example",
+		File:       f,
+		OtherGen:   []*rule.Rule{genA, genB},
+		OtherEmpty: []*rule.Rule{rule.NewRule("proto_library", "stale_proto")},
+	})
+
+	libs := ext.protoLibrariesIn(c, pkgDir)
+	if len(libs) != 2 {
+		t.Fatalf("Expected 2 proto libraries, got %d: %+v", len(libs), libs)
+	}
+	if libs[0].Name != "hand_named" || !libs[0].hasTag(noProtolakeTag) {
+		t.Errorf("Expected a.proto to keep the existing name and tags, got %+v", libs[0])
+	}
+	if libs[1].Name != "b_proto" {
+		t.Errorf("Expected the brand-new rule b_proto, got %+v", libs[1])
+	}
+
+	// A package gazelle hasn't visited is read from disk.
+	otherDir := filepath.Join(repoRoot, "other")
+	if err := os.MkdirAll(otherDir, 0755); err != nil {
+		t.Fatalf("Failed to create other dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(otherDir, "BUILD.bazel"), []byte(`proto_library(
+    name = "other_proto",
+    srcs = ["other.proto"],
+)
+`), 0644); err != nil {
+		t.Fatalf("Failed to write BUILD: %v", err)
+	}
+	if libs := ext.protoLibrariesIn(c, otherDir); len(libs) != 1 || libs[0].Name != "other_proto" {
+		t.Errorf("Expected disk fallback to find other_proto, got %+v", libs)
+	}
+}
+
+// TestCheckMode checks the stale-bundle report diffs a bundle's BUILD file
+// before and after gazelle merges the regenerated rules into it: changed
+// scalars, list entries, added and deleted rules, and nothing for rules that
+// regenerate identically or only reorder a sorted list.
+func TestCheckMode(t *testing.T) {
+	ext := NewLanguage().(*protolakeExtension)
+	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
+	ext.RegisterFlags(fs, "update", &config.Config{Exts: make(map[string]interface{})})
+	if err := fs.Parse([]string{"-" + checkFlag}); err != nil || !ext.check {
+		t.Fatalf("Expected -%s to enable check mode (err %v)", checkFlag, err)
+	}
+
+	f, err := rule.LoadData("com/orders/BUILD.bazel", "com/orders", []byte(`maven_publish(
+    name = "publish_orders_to_maven",
+    coordinates = "com.example:orders:1.0.0",
+)
+
+build_validation(
+    name = "orders_validation",
+    targets = [
+        ":orders_java_bundle",
+        ":orders_py_bundle",
+    ],
+)
+
+proto_descriptor_set(
+    name = "orders_descriptor",
+    deps = [
+        "//com/orders/a:a_proto",
+        "//com/orders/b:b_proto",
+    ],
+)
+
+py_proto_bundle(
+    name = "orders_py_bundle",
+    package_name = "orders",
+)
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse BUILD: %v", err)
+	}
+
+	publish := rule.NewRule("maven_publish", "publish_orders_to_maven")
+	publish.SetAttr("coordinates", "com.example:orders:1.1.0")
+	validation := rule.NewRule("build_validation", "orders_validation")
+	validation.SetAttr("targets", []string{":orders_java_bundle", ":orders_js_bundle"})
+	descriptor := rule.NewRule("proto_descriptor_set", "orders_descriptor")
+	descriptor.SetAttr("deps", []string{"//com/orders/b:b_proto", "//com/orders/a:a_proto"})
+	jsBundle := rule.NewRule("js_proto_bundle", "orders_js_bundle")
+	jsBundle.SetAttr("package_name", "@example/orders")
+	gen := []*rule.Rule{publish, validation, descriptor, jsBundle}
+	empty := []*rule.Rule{rule.NewRule("py_proto_bundle", "orders_py_bundle")}
+
+	ext.recordBundleCheck(language.GenerateArgs{Rel: "com/orders", File: f}, "orders", gen, empty)
+	merger.MergeFile(f, empty, gen, merger.PreResolve, ext.KindInfo(), nil)
+
+	want := []string{
+		"bundle orders (com/orders/BUILD.bazel):",
+		`  publish_orders_to_maven: coordinates: "com.example:orders:1.0.0" -> "com.example:orders:1.1.0"`,
+		"  orders_validation: targets: -:orders_py_bundle, +:orders_js_bundle",
+		"  orders_js_bundle: missing, would be added",
+		"  orders_py_bundle: stale, would be deleted",
+	}
+	if got := ext.staleBundles(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected report:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	// A bundle with nothing to regenerate reports nothing.
+	upToDate := NewLanguage().(*protolakeExtension)
+	f2, _ := rule.LoadData("com/shared/BUILD.bazel", "com/shared", []byte(`maven_publish(
+    name = "publish_shared_to_maven",
+    coordinates = "com.example:shared:1.0.0",
+)
+`))
+	same := rule.NewRule("maven_publish", "publish_shared_to_maven")
+	same.SetAttr("coordinates", "com.example:shared:1.0.0")
+	upToDate.recordBundleCheck(language.GenerateArgs{Rel: "com/shared", File: f2}, "shared", []*rule.Rule{same}, nil)
+	merger.MergeFile(f2, nil, []*rule.Rule{same}, merger.PreResolve, upToDate.KindInfo(), nil)
+	if got := upToDate.staleBundles(); len(got) != 0 {
+		t.Errorf("Expected an up-to-date bundle to report nothing, got %v", got)
 	}
 }
 
@@ -0,0 +1,221 @@
+package language
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// Releasing a lake means running every bundle's publishers, and in artifact
+// mode a bundle's POM, requirements and package.json name the bundles it
+// imports, which must reach the registry first. The lake root package gets
+// multirun targets doing that: publish_all runs every release publisher of
+// every bundle, dependencies first, and publish_all_<registry> only those of
+// one registry. They are rebuilt from the bundles each full gazelle run
+// generates, so adding or removing a bundle updates them.
+
+const (
+	multirunKind   = "multirun"
+	publishAllName = "publish_all"
+)
+
+// lakeRegistries are the registries with a publish_all_<registry> target, in
+// the order publish_all runs one bundle's publishers.
+var lakeRegistries = []string{"maven", "pypi", "npm", "goproxy", "buf"}
+
+// releasePublishers returns the release publish targets config's bundle has
+// for registry; the local and snapshot twins are never part of a release.
+func releasePublishers(config *MergedConfig, registry string) []string {
+	name := config.BundleName
+	var targets []string
+	switch registry {
+	case "maven":
+		if config.JavaConfig.Enabled {
+			targets = append(targets, fmt.Sprintf("publish_%s_to_maven", name))
+		}
+	case "pypi":
+		if config.PythonConfig.Enabled {
+			targets = append(targets, fmt.Sprintf("publish_%s_to_pypi", name))
+		}
+	case "npm":
+		if config.JavaScriptConfig.Enabled {
+			targets = append(targets, fmt.Sprintf("publish_%s_to_npm", name))
+			if config.JavaScriptConfig.ProtoLoader {
+				targets = append(targets, fmt.Sprintf("publish_%s_proto_loader_to_npm", name))
+			}
+		}
+	case "goproxy":
+		if config.GoConfig.Enabled {
+			targets = append(targets, fmt.Sprintf("publish_%s_to_goproxy", name))
+		}
+	case "buf":
+		if config.BufConfig.Enabled {
+			targets = append(targets, fmt.Sprintf("publish_%s_to_buf", name))
+		}
+	}
+	return targets
+}
+
+// lakePublish is the Resolve payload of a publish_all target: the bundles
+// generated under its lake root this pass, by package, and the registry it
+// releases to, "" for all of them.
+type lakePublish struct {
+	bundles  map[string]*bundleImports
+	registry string
+}
+
+// commands returns the publish targets lp runs, bundle by bundle in order,
+// as labels relative to the lake root package from.
+func (lp *lakePublish) commands(order []string, from label.Label) []string {
+	registries := lakeRegistries
+	if lp.registry != "" {
+		registries = []string{lp.registry}
+	}
+	var commands []string
+	for _, pkg := range order {
+		for _, registry := range registries {
+			for _, name := range releasePublishers(lp.bundles[pkg].config, registry) {
+				commands = append(commands, relativeLabel(label.New("", pkg, name), from))
+			}
+		}
+	}
+	return commands
+}
+
+// isLakeRoot reports whether dir holds a lake.yaml.
+func isLakeRoot(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, lakeYamlFile))
+	return err == nil
+}
+
+// lakeRoot returns the package of the lake root pkg belongs to: the nearest
+// directory at or above it holding a lake.yaml.
+func lakeRoot(c *config.Config, pkg string) (string, bool) {
+	for p := pkg; ; p = path.Dir(p) {
+		if p == "." {
+			p = ""
+		}
+		if isLakeRoot(filepath.Join(c.RepoRoot, p)) {
+			return p, true
+		}
+		if p == "" {
+			return "", false
+		}
+	}
+}
+
+// recordLakeBundle adds the bundle at rel, with its Resolve payload, to the
+// bundles its lake root's publish_all targets release.
+func (pe *protolakeExtension) recordLakeBundle(c *config.Config, rel string, bi *bundleImports) {
+	root, ok := lakeRoot(c, rel)
+	if !ok {
+		return
+	}
+	if pe.lakeBundles == nil {
+		pe.lakeBundles = make(map[string]map[string]*bundleImports)
+	}
+	if pe.lakeBundles[root] == nil {
+		pe.lakeBundles[root] = make(map[string]*bundleImports)
+	}
+	pe.lakeBundles[root][rel] = bi
+}
+
+// generateLakePublishRules generates the publish_all targets of the lake
+// rooted at args' package. Gazelle visits subdirectories first, so every
+// bundle under the root has been generated by now. The commands are listed
+// in package order here; Resolve, once every bundle's imports can be
+// resolved, puts dependencies first (see publishOrder). A target left with
+// nothing to publish is deleted.
+func (pe *protolakeExtension) generateLakePublishRules(args language.GenerateArgs) language.GenerateResult {
+	bundles := pe.lakeBundles[args.Rel]
+	order := make([]string, 0, len(bundles))
+	for pkg := range bundles {
+		order = append(order, pkg)
+	}
+	sort.Strings(order)
+	from := label.New("", args.Rel, publishAllName)
+
+	var result language.GenerateResult
+	for _, registry := range append([]string{""}, lakeRegistries...) {
+		name := publishAllName
+		if registry != "" {
+			name += "_" + registry
+		}
+		lp := &lakePublish{bundles: bundles, registry: registry}
+		commands := lp.commands(order, from)
+		if len(commands) == 0 {
+			result.Empty = append(result.Empty, rule.NewRule(multirunKind, name))
+			continue
+		}
+		r := rule.NewRule(multirunKind, name)
+		r.SetAttr("commands", commands)
+		// One at a time, in order, stopping at the first failure: a bundle
+		// whose dependency didn't publish must not publish either.
+		r.SetAttr("jobs", 1)
+		result.Gen = append(result.Gen, r)
+		result.Imports = append(result.Imports, lp)
+	}
+	log.Printf("Generated %d publish_all targets for the lake at //%s over %d bundles",
+		len(result.Gen), args.Rel, len(bundles))
+	return result
+}
+
+// resolveLakePublishRule lists r's commands in publish order.
+func (pe *protolakeExtension) resolveLakePublishRule(c *config.Config, ix *resolve.RuleIndex, r *rule.Rule, lp *lakePublish, from label.Label) {
+	r.SetAttr("commands", lp.commands(pe.publishOrder(c, ix, lp.bundles), from))
+}
+
+// publishOrder returns the packages of bundles with every bundle after the
+// bundles its imports reach, and otherwise in package order. Bundles that
+// import each other are reported and published in package order.
+func (pe *protolakeExtension) publishOrder(c *config.Config, ix *resolve.RuleIndex, bundles map[string]*bundleImports) []string {
+	var remaining []string
+	deps := make(map[string][]string)
+	for pkg, bi := range bundles {
+		remaining = append(remaining, pkg)
+		// A bundle whose imports don't resolve fails in its own rules;
+		// its dependencies still order it here.
+		res, _ := pe.resolveBundleImports(c, ix, bi, label.New("", pkg, bi.config.BundleName+"_all_protos"))
+		for dep := range res.dependsOn {
+			if _, ok := bundles[dep]; ok {
+				deps[pkg] = append(deps[pkg], dep)
+			}
+		}
+	}
+	sort.Strings(remaining)
+
+	placed := make(map[string]bool)
+	order := make([]string, 0, len(remaining))
+	for len(remaining) > 0 {
+		next := -1
+		for i, pkg := range remaining {
+			ready := true
+			for _, dep := range deps[pkg] {
+				ready = ready && placed[dep]
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			log.Printf("Warning: bundles at %s import each other; publishing them in package order",
+				strings.Join(remaining, ", "))
+			next = 0
+		}
+		placed[remaining[next]] = true
+		order = append(order, remaining[next])
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+	return order
+}
@@ -0,0 +1,213 @@
+package language
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// The protobuf and gRPC runtimes a bundle's published artifacts declare. The
+// generated code only works with the runtime it was generated for, so the
+// POM, wheel and package.json must name the versions the lake builds with:
+// lake.yaml pins them per language, and validateLake fails when a pin
+// disagrees with what MODULE.bazel resolves.
+
+// Runtime packages the pinned versions are declared for.
+const (
+	pythonProtobufPackage = "protobuf"
+	pythonGrpcPackage     = "grpcio"
+	jsProtobufPackage     = "@bufbuild/protobuf"
+	jsGrpcPackage         = "@connectrpc/connect"
+)
+
+// Fallbacks for a lake that pins no Java runtime versions: the pom genrule
+// takes them from the environment at build time, defaulting to these.
+const (
+	protobufJavaVersionFallback = "$${PROTOBUF_JAVA_VERSION:-4.33.5}"
+	grpcJavaVersionFallback     = "$${GRPC_VERSION:-1.78.0}"
+)
+
+// runtimeRequirement returns the PEP 508 requirement on a Python runtime
+// package pinned at version: that version or any later one of the same
+// major, which is what protobuf promises generated code works with.
+func runtimeRequirement(pkg, version string) string {
+	major, _, _ := strings.Cut(version, ".")
+	if n, err := strconv.Atoi(major); err == nil {
+		return fmt.Sprintf("%s>=%s,<%d", pkg, version, n+1)
+	}
+	return fmt.Sprintf("%s>=%s", pkg, version)
+}
+
+// runtimeFlags returns the publisher flags declaring config's pinned Python
+// (target metadataPypi) or JavaScript (metadataNpm) runtime dependencies.
+func runtimeFlags(config *MergedConfig, target string) []string {
+	var flags []string
+	switch target {
+	case metadataPypi:
+		for _, r := range [][2]string{
+			{pythonProtobufPackage, config.PythonConfig.ProtobufVersion},
+			{pythonGrpcPackage, config.PythonConfig.GrpcVersion},
+		} {
+			if r[1] != "" {
+				flags = append(flags, quotedFlag("requirement", runtimeRequirement(r[0], r[1])))
+			}
+		}
+	case metadataNpm:
+		for _, r := range [][2]string{
+			{jsProtobufPackage, config.JavaScriptConfig.ProtobufVersion},
+			{jsGrpcPackage, config.JavaScriptConfig.GrpcVersion},
+		} {
+			if r[1] != "" {
+				flags = append(flags, quotedFlag("dependency", fmt.Sprintf("%s@^%s", r[0], r[1])))
+			}
+		}
+	}
+	return flags
+}
+
+// moduleVersions is what a MODULE.bazel resolves: bazel_dep versions by
+// module name and Maven artifact versions by "group:artifact", each with
+// where it is declared.
+type moduleVersions struct {
+	file  string
+	deps  map[string]moduleVersion
+	maven map[string]moduleVersion
+}
+
+type moduleVersion struct {
+	version string
+	pos     bzl.Position
+}
+
+// mavenCoordinatePattern matches a "group:artifact:version" string, as in
+// maven.install's artifacts.
+var mavenCoordinatePattern = regexp.MustCompile(`^([\w.-]+):([\w.-]+):([\w.+-]+)$`)
+
+// loadModuleVersions parses the MODULE.bazel in dir, or returns nil if there
+// is none. file is the path errors report.
+func loadModuleVersions(dir, file string) (*moduleVersions, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "MODULE.bazel"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	f, err := bzl.ParseModule(file, data)
+	if err != nil {
+		return nil, err
+	}
+
+	mv := &moduleVersions{file: file, deps: make(map[string]moduleVersion), maven: make(map[string]moduleVersion)}
+	for _, stmt := range f.Stmt {
+		bzl.Walk(stmt, func(x bzl.Expr, _ []bzl.Expr) {
+			switch x := x.(type) {
+			case *bzl.StringExpr:
+				if m := mavenCoordinatePattern.FindStringSubmatch(x.Value); m != nil {
+					mv.maven[m[1]+":"+m[2]] = moduleVersion{m[3], x.Start}
+				}
+			case *bzl.CallExpr:
+				args := callStringArgs(x)
+				start, _ := x.Span()
+				switch bzl.FormatString(x.X) {
+				case "bazel_dep":
+					if args["name"] != "" && args["version"] != "" {
+						mv.deps[args["name"]] = moduleVersion{args["version"], start}
+					}
+				case "maven.artifact":
+					if args["group"] != "" && args["artifact"] != "" && args["version"] != "" {
+						mv.maven[args["group"]+":"+args["artifact"]] = moduleVersion{args["version"], start}
+					}
+				}
+			}
+		})
+	}
+	return mv, nil
+}
+
+// callStringArgs returns call's keyword arguments with string literal values.
+func callStringArgs(call *bzl.CallExpr) map[string]string {
+	args := make(map[string]string)
+	for _, arg := range call.List {
+		if assign, ok := arg.(*bzl.AssignExpr); ok {
+			key, kok := assign.LHS.(*bzl.Ident)
+			value, vok := assign.RHS.(*bzl.StringExpr)
+			if kok && vok {
+				args[key.Name] = value.Value
+			}
+		}
+	}
+	return args
+}
+
+// runtimeVersionErrors checks the runtime versions lake pins against mv,
+// skipping what MODULE.bazel doesn't declare. Java versions are checked
+// against the protobuf-java and io.grpc Maven artifacts, or the protobuf and
+// grpc-java modules; Python ones against the protobuf and grpc modules.
+// JavaScript runtimes come from npm, which MODULE.bazel doesn't pin.
+func runtimeVersionErrors(lake *LakeConfig, mv *moduleVersions) ConfigErrors {
+	if mv == nil {
+		return nil
+	}
+	defaults := &lake.Config.LanguageDefaults
+	var errs ConfigErrors
+	check := func(field, pinned, what string, resolved moduleVersion, matches func(pinned, resolved string) bool) {
+		if pinned != "" && resolved.version != "" && !matches(pinned, resolved.version) {
+			errs = append(errs, ConfigError{
+				File:   mv.file,
+				Line:   resolved.pos.Line,
+				Column: resolved.pos.LineRune,
+				Msg: fmt.Sprintf("lake.yaml pins %s %s, but MODULE.bazel resolves %s to %s",
+					field, pinned, what, resolved.version),
+			})
+		}
+	}
+	equal := func(a, b string) bool { return a == b }
+
+	if v, ok := mv.maven["com.google.protobuf:protobuf-java"]; ok {
+		check("java protobuf_version", defaults.Java.ProtobufVersion, "com.google.protobuf:protobuf-java", v, equal)
+	} else {
+		check("java protobuf_version", defaults.Java.ProtobufVersion, "module protobuf", mv.deps["protobuf"], protobufRuntimeMatches)
+	}
+	if grpc := mv.grpcJavaArtifacts(); len(grpc) > 0 {
+		for _, artifact := range grpc {
+			check("java grpc_version", defaults.Java.GrpcVersion, artifact, mv.maven[artifact], equal)
+		}
+	} else {
+		check("java grpc_version", defaults.Java.GrpcVersion, "module grpc-java", mv.deps["grpc-java"], equal)
+	}
+	check("python protobuf_version", defaults.Python.ProtobufVersion, "module protobuf", mv.deps["protobuf"], protobufRuntimeMatches)
+	check("python grpc_version", defaults.Python.GrpcVersion, "module grpc", mv.deps["grpc"], equal)
+	return errs
+}
+
+// grpcJavaRuntimeArtifacts are the io.grpc artifacts generated Java code
+// depends on; other io.grpc artifacts, like grpc-kotlin's, version apart.
+var grpcJavaRuntimeArtifacts = []string{"io.grpc:grpc-api", "io.grpc:grpc-protobuf", "io.grpc:grpc-stub"}
+
+// grpcJavaArtifacts returns the grpcJavaRuntimeArtifacts mv declares.
+func (mv *moduleVersions) grpcJavaArtifacts() []string {
+	var artifacts []string
+	for _, artifact := range grpcJavaRuntimeArtifacts {
+		if _, ok := mv.maven[artifact]; ok {
+			artifacts = append(artifacts, artifact)
+		}
+	}
+	return artifacts
+}
+
+// protobufRuntimeMatches reports whether a protobuf language runtime version
+// (protobuf-java "4.31.1", Python protobuf "6.31.1") belongs to the protobuf
+// module release ("31.1"): since protobuf 22 a runtime's version is its own
+// major followed by the protoc release.
+func protobufRuntimeMatches(runtime, module string) bool {
+	if runtime == module {
+		return true
+	}
+	_, release, ok := strings.Cut(runtime, ".")
+	return ok && release == module
+}
@@ -0,0 +1,369 @@
+package language
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+)
+
+// ConfigError is one problem in a lake.yaml or bundle.yaml, located by the
+// yaml node it concerns.
+type ConfigError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e ConfigError) Error() string {
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+	case e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Msg)
+}
+
+// ConfigErrors is every problem found in one or more config files, in file
+// and position order.
+type ConfigErrors []ConfigError
+
+func (es ConfigErrors) Error() string {
+	lines := make([]string, len(es))
+	for i, e := range es {
+		lines[i] = e.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// yamlLinePattern extracts the line yaml.v3 puts in its syntax errors.
+var yamlLinePattern = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// decodeConfig strictly decodes the yaml in data, read from file, into out (a
+// pointer to LakeConfig, GroupConfig or BundleConfig). yaml.Unmarshal drops
+// unknown keys without a word, so a misspelled `group-id` just leaves
+// group_id empty; instead the node tree is checked against out's type first,
+// and every unknown key, wrongly typed value, missing `schema:"required"`
+// field, value outside a `schema:"enum=..."` list and broken
+// `schema:"template"` is reported with its position.
+// The decode itself then runs with KnownFields as a backstop.
+func decodeConfig(file string, data []byte, out interface{}) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		e := ConfigError{File: file, Msg: err.Error()}
+		if m := yamlLinePattern.FindStringSubmatch(err.Error()); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Msg = strings.TrimPrefix(err.Error(), m[0])
+		}
+		return ConfigErrors{e}
+	}
+
+	var errs ConfigErrors
+	root := &doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind == yaml.DocumentNode {
+		// Empty file: nothing to check beyond required fields.
+		root = &yaml.Node{Kind: yaml.MappingNode, Line: 1, Column: 1}
+	}
+	validateNode(file, root, reflect.TypeOf(out).Elem(), "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(out); err != nil && err != io.EOF {
+		return ConfigErrors{{File: file, Msg: err.Error()}}
+	}
+	return nil
+}
+
+// validateNode checks node against type t, appending a ConfigError per
+// problem. path is the dotted key path of node, for messages.
+func validateNode(file string, node *yaml.Node, t reflect.Type, path string, errs *ConfigErrors) {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return // an explicit null leaves the zero value
+	}
+	fail := func(n *yaml.Node, format string, args ...interface{}) {
+		*errs = append(*errs, ConfigError{File: file, Line: n.Line, Column: n.Column, Msg: fmt.Sprintf(format, args...)})
+	}
+	where := path
+	if where == "" {
+		where = "the top level"
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			fail(node, "%s must be a mapping, got %s", where, nodeKindName(node))
+			return
+		}
+		fields := yamlFields(t)
+		seen := make(map[string]bool)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			f, ok := fields[key.Value]
+			if !ok {
+				msg := fmt.Sprintf("unknown field %q in %s", key.Value, path)
+				if path == "" {
+					msg = fmt.Sprintf("unknown field %q at the top level", key.Value)
+				}
+				if s := suggestField(key.Value, fields); s != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", s)
+				}
+				fail(key, "%s", msg)
+				continue
+			}
+			seen[key.Value] = true
+			childPath := joinPath(path, key.Value)
+			validateNode(file, value, f.Type, childPath, errs)
+			if allowed := schemaEnum(f); allowed != nil && value.Kind == yaml.ScalarNode && value.Value != "" && !contains(allowed, value.Value) {
+				fail(value, "%s must be one of %s, got %q", childPath, strings.Join(quoteAll(allowed), ", "), value.Value)
+			}
+			if schemaOption(f, "template") && value.Kind == yaml.ScalarNode {
+				if err := checkCoordinateTemplate(value.Value); err != nil {
+					fail(value, "%s: %v", childPath, err)
+				}
+			}
+			if schemaRequired(f) && value.Kind == yaml.ScalarNode && value.Value == "" {
+				fail(value, "required field %s is empty", childPath)
+			}
+		}
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if schemaRequired(fields[name]) && !seen[name] {
+				fail(node, "missing required field %s", joinPath(path, name))
+			}
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			fail(node, "%s must be a list, got %s", where, nodeKindName(node))
+			return
+		}
+		for i, item := range node.Content {
+			validateNode(file, item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			fail(node, "%s must be a string, got %s", where, nodeKindName(node))
+		}
+	case reflect.Bool:
+		var b bool
+		if node.Kind != yaml.ScalarNode || node.Decode(&b) != nil {
+			fail(node, "%s must be true or false, got %s", where, nodeDescription(node))
+		}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		var n int64
+		if node.Kind != yaml.ScalarNode || node.Decode(&n) != nil {
+			fail(node, "%s must be an integer, got %s", where, nodeDescription(node))
+		}
+	}
+}
+
+// yamlFields maps the yaml keys of struct type t to their fields.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+func schemaRequired(f reflect.StructField) bool {
+	return schemaOption(f, "required")
+}
+
+// schemaOption reports whether f's schema tag lists option.
+func schemaOption(f reflect.StructField, option string) bool {
+	for _, opt := range strings.Split(f.Tag.Get("schema"), ",") {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+func schemaEnum(f reflect.StructField) []string {
+	for _, opt := range strings.Split(f.Tag.Get("schema"), ",") {
+		if strings.HasPrefix(opt, "enum=") {
+			return strings.Split(strings.TrimPrefix(opt, "enum="), "|")
+		}
+	}
+	return nil
+}
+
+// suggestField returns the known key closest to key: the same key with
+// dashes for underscores or different case, else one within two edits.
+func suggestField(key string, fields map[string]reflect.StructField) string {
+	normalized := strings.ToLower(strings.ReplaceAll(key, "-", "_"))
+	if _, ok := fields[normalized]; ok {
+		return normalized
+	}
+	best, bestDist := "", 3
+	for name := range fields {
+		if d := editDistance(normalized, name); d < bestDist || (d == bestDist && name < best) {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func nodeKindName(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return nodeDescription(n)
+}
+
+func nodeDescription(n *yaml.Node) string {
+	if n.Kind == yaml.ScalarNode {
+		return strconv.Quote(n.Value)
+	}
+	return nodeKindName(n)
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteAll(list []string) []string {
+	out := make([]string, len(list))
+	for i, s := range list {
+		out[i] = strconv.Quote(s)
+	}
+	return out
+}
+
+// lakeRuntimeErrors checks the runtime versions lake pins against the
+// MODULE.bazel at repoRoot. The workspace's module pins the runtimes, wherever
+// below it the lake.yaml sits.
+func lakeRuntimeErrors(lake *LakeConfig, repoRoot string) ConfigErrors {
+	mv, err := loadModuleVersions(repoRoot, "MODULE.bazel")
+	if err != nil {
+		return ConfigErrors{{File: "MODULE.bazel", Msg: err.Error()}}
+	}
+	return runtimeVersionErrors(lake, mv)
+}
+
+// validateLake checks every lake.yaml, group.yaml and bundle.yaml in the
+// repo, once per run, and fails with all the problems at once: fixing one
+// file per gazelle run is slow, and a bundle whose config doesn't load must
+// not be skipped quietly. The files are the ones the import index walk found.
+func (pe *protolakeExtension) validateLake(c *config.Config) {
+	if pe.lakeValidated {
+		return
+	}
+	pe.lakeValidated = true
+	if errs := pe.lakeConfigErrors(c); len(errs) > 0 {
+		log.Fatalf("[protolake-gazelle] invalid lake configuration (%d errors):\n  %s",
+			len(errs), strings.ReplaceAll(errs.Error(), "\n", "\n  "))
+	}
+}
+
+// lakeConfigErrors returns the problems in every lake.yaml, group.yaml and
+// bundle.yaml under the repo root, with file paths relative to it.
+func (pe *protolakeExtension) lakeConfigErrors(c *config.Config) ConfigErrors {
+	pe.importIndex(c)
+	var errs ConfigErrors
+	for _, file := range pe.index.configFiles {
+		var out interface{}
+		switch path.Base(file) {
+		case lakeYamlFile:
+			out = &LakeConfig{}
+		case groupYamlFile:
+			out = &GroupConfig{}
+		default:
+			out = &BundleConfig{}
+		}
+		data, err := os.ReadFile(filepath.Join(c.RepoRoot, filepath.FromSlash(file)))
+		if err != nil {
+			errs = append(errs, ConfigError{File: file, Msg: err.Error()})
+			continue
+		}
+		if err := decodeConfig(file, data, out); err != nil {
+			errs = append(errs, err.(ConfigErrors)...)
+		} else if lake, ok := out.(*LakeConfig); ok {
+			errs = append(errs, lakeRuntimeErrors(lake, c.RepoRoot)...)
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].File != errs[j].File {
+			return errs[i].File < errs[j].File
+		}
+		if errs[i].Line != errs[j].Line {
+			return errs[i].Line < errs[j].Line
+		}
+		return errs[i].Column < errs[j].Column
+	})
+	return errs
+}
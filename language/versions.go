@@ -0,0 +1,351 @@
+package language
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A bundle.yaml version is baked into the maven_publish coordinates and read
+// by every publisher, each of which has its own rules: Maven takes anything,
+// npm wants semver, and PyPI wants PEP 440. Checking it against semver at
+// gazelle time catches a "1.0" or "v1.2.3" before a registry rejects it, and
+// lake.yaml's versioning section narrows which prereleases a lake ships.
+
+// VersioningConfig is lake.yaml's versioning section: the lake's policy for
+// bundle.yaml versions.
+type VersioningConfig struct {
+	// PrereleaseTags lists the allowed prerelease tags, the leading letters
+	// of a prerelease ("rc" in "1.2.0-rc.1"); empty allows any.
+	PrereleaseTags []string `yaml:"prerelease_tags"`
+	// Snapshots says whether a "-SNAPSHOT" version may be committed to
+	// bundle.yaml: "allow" (the default) or "reject".
+	Snapshots string `yaml:"snapshots" schema:"enum=allow|reject"`
+	// SnapshotBase is the release the snapshot twins publish ahead of: the
+	// next "patch" (the default), "minor" or "major" version.
+	SnapshotBase string `yaml:"snapshot_base" schema:"enum=patch|minor|major"`
+}
+
+// Values of VersioningConfig.SnapshotBase.
+const (
+	snapshotBasePatch = "patch"
+	snapshotBaseMinor = "minor"
+	snapshotBaseMajor = "major"
+)
+
+// snapshotBase returns config's SnapshotBase, defaulting to the next patch.
+func snapshotBase(config *MergedConfig) string {
+	if config.Versioning.SnapshotBase == "" {
+		return snapshotBasePatch
+	}
+	return config.Versioning.SnapshotBase
+}
+
+// snapshotPrerelease is Maven's snapshot qualifier, as a semver prerelease.
+const snapshotPrerelease = "SNAPSHOT"
+
+// semverPattern is the semver 2.0.0 grammar: MAJOR.MINOR.PATCH, an optional
+// -prerelease and an optional +build.
+var semverPattern = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// semver is a parsed bundle.yaml version.
+type semver struct {
+	release    string // "1.2.0"
+	prerelease string // "rc.1", or empty
+	build      string // build metadata, or empty
+}
+
+// parseSemver parses version, or reports why it isn't a semantic version.
+func parseSemver(version string) (semver, error) {
+	m := semverPattern.FindStringSubmatch(version)
+	if m == nil {
+		msg := fmt.Sprintf("%q is not a semantic version (MAJOR.MINOR.PATCH[-PRERELEASE])", version)
+		if s := suggestSemver(version); s != "" {
+			msg += fmt.Sprintf("; did you mean %q?", s)
+		}
+		return semver{}, fmt.Errorf("%s", msg)
+	}
+	return semver{release: m[1] + "." + m[2] + "." + m[3], prerelease: m[4], build: m[5]}, nil
+}
+
+// suggestSemver returns the semantic version version most likely meant:
+// without a leading "v" and padded to three parts ("v1.2" gives "1.2.0"), or
+// "" if that isn't one either.
+func suggestSemver(version string) string {
+	v := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(version), "v"), "V")
+	release, rest := v, ""
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		release, rest = v[:i], v[i:]
+	}
+	for strings.Count(release, ".") < 2 && release != "" {
+		release += ".0"
+	}
+	if s := release + rest; s != version && semverPattern.MatchString(s) {
+		return s
+	}
+	return ""
+}
+
+// prereleaseTag splits v's prerelease into its tag and number, "rc" and "1"
+// for both "rc.1" and "rc1"; rest is whatever identifiers follow them.
+func (v semver) prereleaseTag() (tag, number, rest string) {
+	ids := strings.Split(v.prerelease, ".")
+	tag = strings.TrimRight(ids[0], "0123456789")
+	number, ids = ids[0][len(tag):], ids[1:]
+	if number == "" && len(ids) > 0 {
+		if _, err := strconv.Atoi(ids[0]); err == nil {
+			number, ids = ids[0], ids[1:]
+		}
+	}
+	return tag, number, strings.Join(ids, ".")
+}
+
+// nextVersion returns the version a snapshot of v leads up to. A snapshot
+// qualifier sorts below the version it qualifies, so a snapshot of 1.2.0
+// is one of 1.2.1 (base "patch"), 1.3.0 ("minor") or 2.0.0 ("major"), and
+// one of 1.2.0-rc.1 is one of the next prerelease, 1.2.0-rc.2. A snapshot
+// is already one of its release: 1.2.0-SNAPSHOT gives 1.2.0.
+func (v semver) nextVersion(base string) string {
+	switch v.prerelease {
+	case "":
+	case snapshotPrerelease:
+		return v.release
+	default:
+		return v.release + "-" + v.nextPrerelease()
+	}
+	parts := strings.Split(v.release, ".")
+	major, _ := strconv.Atoi(parts[0])
+	minor, _ := strconv.Atoi(parts[1])
+	patch, _ := strconv.Atoi(parts[2])
+	switch base {
+	case snapshotBaseMajor:
+		return fmt.Sprintf("%d.0.0", major+1)
+	case snapshotBaseMinor:
+		return fmt.Sprintf("%d.%d.0", major, minor+1)
+	}
+	return fmt.Sprintf("%d.%d.%d", major, minor, patch+1)
+}
+
+// nextPrerelease returns the prerelease after v's, numbered as v's is:
+// "rc.2" after "rc.1", "beta3" after "beta2", "rc.1" after "rc". Whatever
+// follows the number is dropped.
+func (v semver) nextPrerelease() string {
+	tag, number, _ := v.prereleaseTag()
+	n, _ := strconv.Atoi(number)
+	if first := strings.SplitN(v.prerelease, ".", 2)[0]; first != tag || tag == "" {
+		// The number is part of the first identifier: "rc1", or a bare "1".
+		return fmt.Sprintf("%s%d", tag, n+1)
+	}
+	return fmt.Sprintf("%s.%d", tag, n+1)
+}
+
+// pep440Prereleases maps prerelease tags to their PEP 440 spelling; the
+// pypi publisher normalizes a version the same way.
+var pep440Prereleases = map[string]string{
+	"a": "a", "alpha": "a",
+	"b": "b", "beta": "b",
+	"c": "rc", "rc": "rc", "pre": "rc", "preview": "rc",
+	"dev": ".dev",
+}
+
+// pep440 returns the PEP 440 version the pypi publisher publishes v as:
+// "1.2.0-rc.1" as "1.2.0rc1", "1.2.0-SNAPSHOT" as "1.2.0.dev0".
+func (v semver) pep440() (string, error) {
+	switch {
+	case v.build != "":
+		return "", fmt.Errorf("build metadata %q would make a PEP 440 local version, which PyPI refuses", "+"+v.build)
+	case v.prerelease == "":
+		return v.release, nil
+	case v.prerelease == snapshotPrerelease:
+		return v.release + ".dev0", nil
+	}
+	tag, number, rest := v.prereleaseTag()
+	spelling, ok := pep440Prereleases[strings.ToLower(tag)]
+	if !ok || rest != "" {
+		return "", fmt.Errorf("prerelease %q has no PEP 440 equivalent (use alpha, beta, rc or dev, optionally numbered)", v.prerelease)
+	}
+	n, _ := strconv.Atoi(number) // PEP 440 drops leading zeros; no number is 0
+	return fmt.Sprintf("%s%s%d", v.release, spelling, n), nil
+}
+
+// publishedVersions lists the version each enabled publisher publishes
+// config's bundle at, for the log: "maven 1.2.0-rc.1, pypi 1.2.0rc1, npm
+// 1.2.0-rc.1". Maven and npm take the semantic version as is.
+func publishedVersions(config *MergedConfig) string {
+	var names []string
+	if config.JavaConfig.Enabled {
+		names = append(names, "maven "+config.Version)
+	}
+	if config.PythonConfig.Enabled {
+		if v, err := parseSemver(config.Version); err == nil {
+			if pep, err := v.pep440(); err == nil {
+				names = append(names, "pypi "+pep)
+			}
+		}
+	}
+	if config.JavaScriptConfig.Enabled {
+		names = append(names, "npm "+config.Version)
+	}
+	if config.GoConfig.Enabled {
+		names = append(names, "go v"+config.Version)
+	}
+	return strings.Join(names, ", ")
+}
+
+// versionError checks config's version against semver and the lake's
+// versioning policy, and what the enabled publishers accept.
+func versionError(config *MergedConfig) error {
+	v, err := parseSemver(config.Version)
+	if err != nil {
+		return err
+	}
+	policy := config.Versioning
+	if v.prerelease == snapshotPrerelease {
+		if policy.Snapshots == "reject" {
+			return fmt.Errorf("%q is a snapshot, and lake.yaml's versioning.snapshots rejects them", config.Version)
+		}
+	} else if v.prerelease != "" && len(policy.PrereleaseTags) > 0 {
+		if tag, _, _ := v.prereleaseTag(); !contains(policy.PrereleaseTags, tag) {
+			return fmt.Errorf("prerelease tag %q of %q is not one of lake.yaml's versioning.prerelease_tags (%s)",
+				tag, config.Version, strings.Join(policy.PrereleaseTags, ", "))
+		}
+	}
+	if config.PythonConfig.Enabled {
+		if _, err := v.pep440(); err != nil {
+			return fmt.Errorf("%q can't be published to PyPI: %v", config.Version, err)
+		}
+	}
+	if config.GoConfig.Enabled {
+		if err := goModuleError(config.GoConfig.ModulePath, config.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// goModuleError reports whether the go tool rejects modulePath at the
+// semantic version version: from v2 on, a module path ends in the major
+// version ("/v2"), and a path that does names version's major version.
+func goModuleError(modulePath, version string) error {
+	major := strings.SplitN(version, ".", 2)[0]
+	suffix := ""
+	if i := strings.LastIndex(modulePath, "/v"); i >= 0 {
+		if n, err := strconv.Atoi(modulePath[i+2:]); err == nil && n >= 2 && modulePath[i+2] != '0' {
+			suffix = modulePath[i+2:]
+		}
+	}
+	switch {
+	case suffix != "" && suffix != major:
+		return fmt.Errorf("Go module %s can't be published at v%s: its path names major version %s", modulePath, version, suffix)
+	case suffix == "" && major != "0" && major != "1":
+		return fmt.Errorf("Go module %s can't be published at v%s: from v2 on, the module path must end in /v%s (%s/v%s)",
+			modulePath, version, major, modulePath, major)
+	}
+	return nil
+}
+
+// Values of MergedConfig.VersionSource: where a bundle's version comes from.
+// A literal version is bundle.yaml's `version`, baked into the maven_publish
+// coordinates, so releasing means editing bundle.yaml and rerunning gazelle.
+// The other two leave the version to the build: the <bundle>_version genrule
+// (see generateVersionRule) reads it from Bazel's workspace status, and every
+// bundle rule and publisher reads that file instead of bundle.yaml.
+const (
+	VersionSourceLiteral = "literal"
+	// VersionSourceGitTag takes the version from the nearest <bundle>-vX.Y.Z
+	// tag, which tools/workspace_status.sh reports as STABLE_GIT_TAG_<bundle>.
+	VersionSourceGitTag = "git_tag"
+	// VersionSourceStamp takes the version from STABLE_VERSION_<bundle>,
+	// which the lake's own --workspace_status_command reports.
+	VersionSourceStamp = "stamp"
+)
+
+// versionFromBuild reports whether config's version is only known at build
+// time, from the <bundle>_version genrule.
+func versionFromBuild(config *MergedConfig) bool {
+	return config.VersionSource == VersionSourceGitTag || config.VersionSource == VersionSourceStamp
+}
+
+// versionStatusKey returns the workspace status key config's version is
+// read from.
+func versionStatusKey(config *MergedConfig) string {
+	if config.VersionSource == VersionSourceGitTag {
+		return "STABLE_GIT_TAG_" + config.BundleName
+	}
+	return "STABLE_VERSION_" + config.BundleName
+}
+
+// versionCommand builds the <bundle>_version genrule cmd: it looks up
+// versionStatusKey in the stable status file, strips the "<bundle>-v" tag
+// prefix for git_tag, and fails the build unless what is left looks like a
+// semantic version. versionError can't check a version gazelle never sees,
+// so this catches a missing key or a mistyped tag before anything publishes,
+// and versionPolicyChecks holds the version to the same rules a literal one
+// must follow.
+func versionCommand(config *MergedConfig) string {
+	key := versionStatusKey(config)
+	prefix := key + " "
+	hint := fmt.Sprintf("%s: no %s in the workspace status; set it from --workspace_status_command", config.BundleName, key)
+	if config.VersionSource == VersionSourceGitTag {
+		prefix += config.BundleName + "-v"
+		hint = fmt.Sprintf("%s: no %s-vX.Y.Z tag in the workspace status; build with --workspace_status_command=tools/workspace_status.sh",
+			config.BundleName, config.BundleName)
+	}
+	cmd := fmt.Sprintf("v=$$(sed -n %s bazel-out/stable-status.txt); "+
+		"echo \"$$v\" | grep -Eq %s || { echo %s >&2; exit 1; }; ",
+		shellQuote("s/^"+prefix+"//p"),
+		shellQuote(`^[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`),
+		shellQuote(hint))
+	for _, check := range versionPolicyChecks(config) {
+		cmd += check + "; "
+	}
+	return cmd + "echo \"$$v\" > $@"
+}
+
+// versionPolicyChecks returns the shell checks of $$v that versionError
+// makes of a literal version: lake.yaml's versioning policy, and PEP 440
+// when the bundle publishes to PyPI.
+func versionPolicyChecks(config *MergedConfig) []string {
+	const release = `^[0-9]+\.[0-9]+\.[0-9]+`
+	fail := func(msg string) string {
+		return fmt.Sprintf("{ echo %s\"$$v\"%s >&2; exit 1; }", shellQuote(config.BundleName+": version "), shellQuote(" "+msg))
+	}
+	var checks []string
+	policy := config.Versioning
+	if policy.Snapshots == "reject" {
+		checks = append(checks, fmt.Sprintf("! echo \"$$v\" | grep -Eq %s || %s",
+			shellQuote(release+`-SNAPSHOT(\+.*)?$`),
+			fail("is a snapshot, and lake.yaml's versioning.snapshots rejects them")))
+	}
+	if len(policy.PrereleaseTags) > 0 {
+		// The tag is the leading letters of the prerelease, as in
+		// semver.prereleaseTag.
+		tags := make([]string, len(policy.PrereleaseTags))
+		for i, tag := range policy.PrereleaseTags {
+			tags[i] = regexp.QuoteMeta(tag)
+		}
+		checks = append(checks, fmt.Sprintf("echo \"$$v\" | grep -Eq %s || %s",
+			shellQuote(release+`(-(SNAPSHOT|(`+strings.Join(tags, "|")+`)[0-9]*(\.[0-9A-Za-z-]+)*))?(\+.*)?$`),
+			fail("has a prerelease tag that is not one of lake.yaml's versioning.prerelease_tags ("+
+				strings.Join(policy.PrereleaseTags, ", ")+")")))
+	}
+	if config.PythonConfig.Enabled {
+		// semver.pep440's rules: no build metadata, and a SNAPSHOT or a
+		// known, optionally numbered, prerelease tag.
+		tags := make([]string, 0, len(pep440Prereleases))
+		for tag := range pep440Prereleases {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		checks = append(checks, fmt.Sprintf("{ echo \"$$v\" | grep -Eq %s || echo \"$$v\" | grep -Eiq %s; } || %s",
+			shellQuote(release+`(-SNAPSHOT)?$`),
+			shellQuote(release+`-(`+strings.Join(tags, "|")+`)([0-9]+|\.[0-9]+)?$`),
+			fail("can't be published to PyPI: it has build metadata or a prerelease with no PEP 440 equivalent "+
+				"(use alpha, beta, rc or dev, optionally numbered)")))
+	}
+	return checks
+}
@@ -0,0 +1,367 @@
+// Command breaking is the checker behind a bundle's <bundle>_breaking_check
+// test (see proto_breaking_test in //tools:proto_bundle.bzl). It compares
+// the bundle's descriptor set against the baseline one, the descriptor set
+// of the last published version, and fails on a wire-incompatible change
+// unless the bundle's version bumps the baseline version's major part (its
+// minor part below 1.0.0, as semver allows).
+//
+// Usage:
+//
+//	breaking --baseline=base.pb --baseline-version=1.3.0 \
+//	    (--bundle-yaml=bundle.yaml | --version-file=version.txt) current.pb
+//
+// A change is breaking when it changes how existing data decodes or an
+// existing client calls a service:
+//   - a message, enum or service removed
+//   - a field renumbered, or its type, message or enum type or label changed
+//   - a field or enum value removed without reserving its number, or its
+//     number reused with a different type
+//   - an enum value renumbered
+//   - an RPC removed, or its request or response type or streaming changed
+//
+// Renaming a field or enum value while keeping its number is not breaking
+// on the wire and passes.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"gopkg.in/yaml.v3"
+)
+
+func main() {
+	baseline := flag.String("baseline", "", "descriptor set of the baseline version")
+	baselineVersion := flag.String("baseline-version", "", "version the baseline was published as")
+	bundleYaml := flag.String("bundle-yaml", "", "bundle.yaml to read the current version from")
+	versionFile := flag.String("version-file", "", "file holding the current version")
+	flag.Parse()
+	if *baseline == "" || *baselineVersion == "" || flag.NArg() != 1 || (*bundleYaml == "") == (*versionFile == "") {
+		log.Fatalf("usage: breaking --baseline=base.pb --baseline-version=X.Y.Z (--bundle-yaml=F | --version-file=F) current.pb")
+	}
+
+	version, err := readVersion(*bundleYaml, *versionFile)
+	if err != nil {
+		log.Fatalf("breaking: %v", err)
+	}
+	old, err := readDescriptorSet(*baseline)
+	if err != nil {
+		log.Fatalf("breaking: %v", err)
+	}
+	cur, err := readDescriptorSet(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("breaking: %v", err)
+	}
+
+	changes := breakingChanges(old, cur)
+	if len(changes) == 0 {
+		fmt.Printf("No breaking changes against %s\n", *baselineVersion)
+		return
+	}
+	allowed, err := allowsBreaking(*baselineVersion, version)
+	if err != nil {
+		log.Fatalf("breaking: %v", err)
+	}
+	if allowed {
+		fmt.Printf("%d breaking change(s) against %s, allowed by version %s:\n", len(changes), *baselineVersion, version)
+		for _, change := range changes {
+			fmt.Printf("  %s\n", change)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d breaking change(s) against %s, which version %s doesn't bump the major version of:\n",
+		len(changes), *baselineVersion, version)
+	for _, change := range changes {
+		fmt.Fprintf(os.Stderr, "  %s\n", change)
+	}
+	os.Exit(1)
+}
+
+// readVersion returns the bundle's current version, from the version key of
+// bundleYaml or else the contents of versionFile.
+func readVersion(bundleYaml, versionFile string) (string, error) {
+	if versionFile != "" {
+		data, err := os.ReadFile(versionFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	data, err := os.ReadFile(bundleYaml)
+	if err != nil {
+		return "", err
+	}
+	var bundle struct {
+		Version string `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &bundle); err != nil {
+		return "", fmt.Errorf("%s: %v", bundleYaml, err)
+	}
+	if bundle.Version == "" {
+		return "", fmt.Errorf("%s has no version", bundleYaml)
+	}
+	return bundle.Version, nil
+}
+
+func readDescriptorSet(path string) (*descriptorpb.FileDescriptorSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("%s is not a descriptor set: %v", path, err)
+	}
+	return set, nil
+}
+
+// allowsBreaking reports whether version may break compatibility with
+// baseline: a higher major version, or a higher minor version below 1.0.0.
+func allowsBreaking(baseline, version string) (bool, error) {
+	baseMajor, baseMinor, err := majorMinor(baseline)
+	if err != nil {
+		return false, err
+	}
+	major, minor, err := majorMinor(version)
+	if err != nil {
+		return false, err
+	}
+	if major != baseMajor {
+		return major > baseMajor, nil
+	}
+	return major == 0 && minor > baseMinor, nil
+}
+
+// majorMinor parses the major and minor parts of a semver version.
+func majorMinor(version string) (int, int, error) {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 3 {
+		return 0, 0, fmt.Errorf("version %q is not MAJOR.MINOR.PATCH", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("version %q is not MAJOR.MINOR.PATCH", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("version %q is not MAJOR.MINOR.PATCH", version)
+	}
+	return major, minor, nil
+}
+
+// schema indexes the messages, enums and services of a descriptor set by
+// fully qualified name, nested types included.
+type schema struct {
+	messages map[string]*descriptorpb.DescriptorProto
+	enums    map[string]*descriptorpb.EnumDescriptorProto
+	services map[string]*descriptorpb.ServiceDescriptorProto
+}
+
+func index(set *descriptorpb.FileDescriptorSet) *schema {
+	s := &schema{
+		messages: make(map[string]*descriptorpb.DescriptorProto),
+		enums:    make(map[string]*descriptorpb.EnumDescriptorProto),
+		services: make(map[string]*descriptorpb.ServiceDescriptorProto),
+	}
+	for _, file := range set.GetFile() {
+		scope := file.GetPackage()
+		for _, m := range file.GetMessageType() {
+			s.addMessage(scope, m)
+		}
+		for _, e := range file.GetEnumType() {
+			s.enums[qualify(scope, e.GetName())] = e
+		}
+		for _, svc := range file.GetService() {
+			s.services[qualify(scope, svc.GetName())] = svc
+		}
+	}
+	return s
+}
+
+func (s *schema) addMessage(scope string, m *descriptorpb.DescriptorProto) {
+	name := qualify(scope, m.GetName())
+	s.messages[name] = m
+	for _, nested := range m.GetNestedType() {
+		s.addMessage(name, nested)
+	}
+	for _, e := range m.GetEnumType() {
+		s.enums[qualify(name, e.GetName())] = e
+	}
+}
+
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+// breakingChanges lists the wire-incompatible changes from old to cur, in
+// name order.
+func breakingChanges(old, cur *descriptorpb.FileDescriptorSet) []string {
+	was, is := index(old), index(cur)
+	var changes []string
+
+	for _, name := range sortedKeys(was.messages) {
+		m := is.messages[name]
+		if m == nil {
+			changes = append(changes, fmt.Sprintf("message %s removed", name))
+			continue
+		}
+		changes = append(changes, fieldChanges(name, was.messages[name], m)...)
+	}
+	for _, name := range sortedKeys(was.enums) {
+		e := is.enums[name]
+		if e == nil {
+			changes = append(changes, fmt.Sprintf("enum %s removed", name))
+			continue
+		}
+		changes = append(changes, enumChanges(name, was.enums[name], e)...)
+	}
+	for _, name := range sortedKeys(was.services) {
+		svc := is.services[name]
+		if svc == nil {
+			changes = append(changes, fmt.Sprintf("service %s removed", name))
+			continue
+		}
+		changes = append(changes, methodChanges(name, was.services[name], svc)...)
+	}
+	return changes
+}
+
+func fieldChanges(message string, old, cur *descriptorpb.DescriptorProto) []string {
+	byName := make(map[string]*descriptorpb.FieldDescriptorProto)
+	byNumber := make(map[int32]*descriptorpb.FieldDescriptorProto)
+	for _, f := range cur.GetField() {
+		byName[f.GetName()] = f
+		byNumber[f.GetNumber()] = f
+	}
+	var changes []string
+	for _, f := range old.GetField() {
+		name := message + "." + f.GetName()
+		if g := byName[f.GetName()]; g != nil {
+			if g.GetNumber() != f.GetNumber() {
+				changes = append(changes, fmt.Sprintf("field %s renumbered from %d to %d", name, f.GetNumber(), g.GetNumber()))
+			} else if fieldType(g) != fieldType(f) {
+				changes = append(changes, fmt.Sprintf("field %s changed from %s to %s", name, fieldType(f), fieldType(g)))
+			}
+			continue
+		}
+		if g := byNumber[f.GetNumber()]; g != nil {
+			if fieldType(g) != fieldType(f) {
+				changes = append(changes, fmt.Sprintf("field %s (%d) replaced by %s %s", name, f.GetNumber(), fieldType(g), g.GetName()))
+			}
+			continue
+		}
+		if !messageReserves(cur, f.GetNumber()) {
+			changes = append(changes, fmt.Sprintf("field %s (%d) removed without reserving its number", name, f.GetNumber()))
+		}
+	}
+	return changes
+}
+
+// fieldType describes what decides a field's encoding: its label and its
+// scalar, message or enum type.
+func fieldType(f *descriptorpb.FieldDescriptorProto) string {
+	label := strings.ToLower(strings.TrimPrefix(f.GetLabel().String(), "LABEL_"))
+	typ := strings.ToLower(strings.TrimPrefix(f.GetType().String(), "TYPE_"))
+	if f.GetTypeName() != "" {
+		typ = strings.TrimPrefix(f.GetTypeName(), ".")
+	}
+	return label + " " + typ
+}
+
+func messageReserves(m *descriptorpb.DescriptorProto, number int32) bool {
+	for _, r := range m.GetReservedRange() {
+		// The end of a message's reserved range is exclusive.
+		if number >= r.GetStart() && number < r.GetEnd() {
+			return true
+		}
+	}
+	return false
+}
+
+func enumChanges(enum string, old, cur *descriptorpb.EnumDescriptorProto) []string {
+	byName := make(map[string]*descriptorpb.EnumValueDescriptorProto)
+	byNumber := make(map[int32]bool)
+	for _, v := range cur.GetValue() {
+		byName[v.GetName()] = v
+		byNumber[v.GetNumber()] = true
+	}
+	var changes []string
+	for _, v := range old.GetValue() {
+		name := enum + "." + v.GetName()
+		if w := byName[v.GetName()]; w != nil {
+			if w.GetNumber() != v.GetNumber() {
+				changes = append(changes, fmt.Sprintf("enum value %s renumbered from %d to %d", name, v.GetNumber(), w.GetNumber()))
+			}
+			continue
+		}
+		if !byNumber[v.GetNumber()] && !enumReserves(cur, v.GetNumber()) {
+			changes = append(changes, fmt.Sprintf("enum value %s (%d) removed without reserving its number", name, v.GetNumber()))
+		}
+	}
+	return changes
+}
+
+func enumReserves(e *descriptorpb.EnumDescriptorProto, number int32) bool {
+	for _, r := range e.GetReservedRange() {
+		// Unlike a message's, the end of an enum's reserved range is
+		// inclusive.
+		if number >= r.GetStart() && number <= r.GetEnd() {
+			return true
+		}
+	}
+	return false
+}
+
+func methodChanges(service string, old, cur *descriptorpb.ServiceDescriptorProto) []string {
+	byName := make(map[string]*descriptorpb.MethodDescriptorProto)
+	for _, m := range cur.GetMethod() {
+		byName[m.GetName()] = m
+	}
+	var changes []string
+	for _, m := range old.GetMethod() {
+		name := service + "." + m.GetName()
+		n := byName[m.GetName()]
+		if n == nil {
+			changes = append(changes, fmt.Sprintf("rpc %s removed", name))
+			continue
+		}
+		if signature(n) != signature(m) {
+			changes = append(changes, fmt.Sprintf("rpc %s changed from %s to %s", name, signature(m), signature(n)))
+		}
+	}
+	return changes
+}
+
+// signature describes an RPC's request and response types, as
+// "(stream pkg.Req) returns (pkg.Resp)".
+func signature(m *descriptorpb.MethodDescriptorProto) string {
+	stream := func(streaming bool, typ string) string {
+		typ = strings.TrimPrefix(typ, ".")
+		if streaming {
+			return "stream " + typ
+		}
+		return typ
+	}
+	return fmt.Sprintf("(%s) returns (%s)",
+		stream(m.GetClientStreaming(), m.GetInputType()),
+		stream(m.GetServerStreaming(), m.GetOutputType()))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
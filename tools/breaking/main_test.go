@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// loadFixture reads testdata/<name>.textproto, writes it as the binary
+// descriptor set proto_descriptor_set would build, and reads that back.
+func loadFixture(t *testing.T, name string) *descriptorpb.FileDescriptorSet {
+	t.Helper()
+	text, err := os.ReadFile(filepath.Join("testdata", name+".textproto"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture %s: %v", name, err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := prototext.Unmarshal(text, set); err != nil {
+		t.Fatalf("Failed to parse fixture %s: %v", name, err)
+	}
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatalf("Failed to marshal fixture %s: %v", name, err)
+	}
+	path := filepath.Join(t.TempDir(), name+".pb")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	loaded, err := readDescriptorSet(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return loaded
+}
+
+func TestBreakingChanges(t *testing.T) {
+	baseline := loadFixture(t, "baseline")
+
+	t.Run("Unchanged", func(t *testing.T) {
+		if got := breakingChanges(baseline, baseline); got != nil {
+			t.Errorf("Expected no changes, got %v", got)
+		}
+	})
+
+	t.Run("Compatible", func(t *testing.T) {
+		// Renames in place, reserved removals and additions.
+		if got := breakingChanges(baseline, loadFixture(t, "compatible")); got != nil {
+			t.Errorf("Expected no breaking changes, got %v", got)
+		}
+	})
+
+	t.Run("Breaking", func(t *testing.T) {
+		want := []string{
+			"field orders.Order.id renumbered from 1 to 10",
+			"field orders.Order.quantity changed from optional int32 to optional int64",
+			"field orders.Order.status (3) replaced by optional string state",
+			"field orders.Order.notes changed from repeated string to optional string",
+			"field orders.Order.legacy_ref (5) removed without reserving its number",
+			"message orders.Order.Item removed",
+			"enum value orders.Status.STATUS_OPEN renumbered from 1 to 5",
+			"enum value orders.Status.STATUS_HELD (3) removed without reserving its number",
+			"rpc orders.OrderService.WatchOrder changed from (orders.GetOrderRequest) returns (stream orders.Order) " +
+				"to (orders.GetOrderRequest) returns (orders.Order)",
+			"rpc orders.OrderService.CancelOrder removed",
+		}
+		if got := breakingChanges(baseline, loadFixture(t, "breaking")); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected changes\n%q\ngot\n%q", want, got)
+		}
+	})
+
+	t.Run("Removed", func(t *testing.T) {
+		want := []string{
+			"message orders.GetOrderRequest removed",
+			"message orders.Order removed",
+			"message orders.Order.Item removed",
+			"enum orders.Status removed",
+			"service orders.OrderService removed",
+		}
+		if got := breakingChanges(baseline, &descriptorpb.FileDescriptorSet{}); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected changes\n%q\ngot\n%q", want, got)
+		}
+	})
+}
+
+func TestAllowsBreaking(t *testing.T) {
+	for _, tc := range []struct {
+		baseline, version string
+		want              bool
+	}{
+		{"1.3.0", "2.0.0", true},
+		{"1.3.0", "2.0.0-rc1", true},
+		{"1.3.0", "1.4.0", false},
+		{"1.3.0", "1.3.1", false},
+		{"1.3.0", "0.9.0", false},
+		{"0.3.0", "0.4.0", true},
+		{"0.3.0", "0.3.1", false},
+		{"0.3.0", "1.0.0", true},
+	} {
+		got, err := allowsBreaking(tc.baseline, tc.version)
+		if err != nil {
+			t.Errorf("allowsBreaking(%q, %q): %v", tc.baseline, tc.version, err)
+		} else if got != tc.want {
+			t.Errorf("allowsBreaking(%q, %q) = %v, want %v", tc.baseline, tc.version, got, tc.want)
+		}
+	}
+	if _, err := allowsBreaking("1.3.0", "2.0"); err == nil {
+		t.Error("Expected an error for a version without a patch part")
+	}
+}
+
+func TestReadVersion(t *testing.T) {
+	dir := t.TempDir()
+	bundleYaml := filepath.Join(dir, "bundle.yaml")
+	os.WriteFile(bundleYaml, []byte("name: \"orders\"\nversion: \"2.0.0\"\n"), 0644)
+	if got, err := readVersion(bundleYaml, ""); err != nil || got != "2.0.0" {
+		t.Errorf("Expected 2.0.0 from bundle.yaml, got %q (%v)", got, err)
+	}
+
+	versionFile := filepath.Join(dir, "version.txt")
+	os.WriteFile(versionFile, []byte("2.1.0\n"), 0644)
+	if got, err := readVersion("", versionFile); err != nil || got != "2.1.0" {
+		t.Errorf("Expected 2.1.0 from the version file, got %q (%v)", got, err)
+	}
+
+	os.WriteFile(bundleYaml, []byte("name: \"orders\"\n"), 0644)
+	if _, err := readVersion(bundleYaml, ""); err == nil {
+		t.Error("Expected an error for a bundle.yaml without a version")
+	}
+}
+
+func TestReadDescriptorSetRejectsGarbage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.pb")
+	os.WriteFile(path, []byte("not a descriptor set"), 0644)
+	if _, err := readDescriptorSet(path); err == nil {
+		t.Error("Expected an error for a file that isn't a descriptor set")
+	}
+}
@@ -0,0 +1,120 @@
+// Command javasources writes the sources JAR of a Java bundle: the Java
+// files of the given source JARs (the -src.jar of the bundle's compiled
+// library, itself built from the protoc-generated srcjar), each at its
+// package path.
+//
+// Usage:
+//
+//	javasources --output bundle-sources.jar lib-src.jar [more-src.jar ...]
+//
+// Entries are written in name order with a fixed timestamp, so the JAR is
+// byte-for-byte reproducible. The same path in two inputs is kept once when
+// the contents match and is an error otherwise: a silently dropped class
+// would publish sources that don't match the binary JAR.
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// entryTime is the modification time of every entry, as zipper and
+// singlejar use, so identical inputs give an identical JAR.
+var entryTime = time.Date(2010, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func main() {
+	output := flag.String("output", "", "path of the sources JAR to write")
+	flag.Parse()
+	if *output == "" || flag.NArg() == 0 {
+		log.Fatalf("usage: javasources --output out.jar src.jar...")
+	}
+	if err := writeSourcesJar(*output, flag.Args()); err != nil {
+		log.Fatalf("javasources: %v", err)
+	}
+}
+
+// writeSourcesJar merges the .java entries of inputs into a JAR at output.
+func writeSourcesJar(output string, inputs []string) error {
+	sources := make(map[string][]byte)
+	origin := make(map[string]string)
+	for _, input := range inputs {
+		if err := readSources(input, sources, origin); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	add := func(name string, data []byte) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: entryTime})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	if err := add("META-INF/MANIFEST.MF", []byte("Manifest-Version: 1.0\r\nCreated-By: protolake\r\n\r\n")); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := add(name, sources[name]); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// readSources adds the .java entries of the JAR at path to sources, keyed by
+// their path in the JAR.
+func readSources(path string, sources map[string][]byte, origin map[string]string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", path, err)
+	}
+	defer zr.Close()
+	for _, file := range zr.File {
+		name := strings.TrimPrefix(file.Name, "/")
+		if file.FileInfo().IsDir() || !strings.HasSuffix(name, ".java") {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("reading %s in %s: %v", name, path, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("reading %s in %s: %v", name, path, err)
+		}
+		if prev, ok := sources[name]; ok {
+			if !bytes.Equal(prev, data) {
+				return fmt.Errorf("%s differs between %s and %s", name, origin[name], path)
+			}
+			continue
+		}
+		sources[name] = data
+		origin[name] = path
+	}
+	return nil
+}
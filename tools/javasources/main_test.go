@@ -0,0 +1,128 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeJar writes a JAR at path holding files, name -> content.
+func writeJar(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", path, err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	f.Close()
+}
+
+// readJar returns the entries of the JAR at path, name -> content, and their
+// names in archive order.
+func readJar(t *testing.T, path string) (map[string]string, []string) {
+	t.Helper()
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer zr.Close()
+	files := make(map[string]string)
+	var names []string
+	for _, file := range zr.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s in %s: %v", file.Name, path, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[file.Name] = string(data)
+		names = append(names, file.Name)
+	}
+	return files, names
+}
+
+// TestWriteSourcesJar: the sources JAR keeps every generated Java file at
+// its package path, so same-named classes in different packages both
+// survive, and leaves out everything that isn't Java source.
+func TestWriteSourcesJar(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "libuser_java_grpc-src.jar")
+	writeJar(t, lib, map[string]string{
+		"META-INF/MANIFEST.MF":                         "Manifest-Version: 1.0\r\n",
+		"com/example/user/v1/UserProto.java":           "package com.example.user.v1;",
+		"com/example/user/v1/UserServiceGrpc.java":     "package com.example.user.v1; // grpc",
+		"com/example/user/v2/UserProto.java":           "package com.example.user.v2;",
+		"com/example/user/v2/":                         "",
+		"com/example/user/v2/UserProto.class":          "\xca\xfe\xba\xbe",
+		"com/example/common/v1/CommonProto.java":       "package com.example.common.v1;",
+		"com/example/common/v1/CommonProtoOrBuilder.x": "not java",
+	})
+	extra := filepath.Join(dir, "extra-src.jar")
+	writeJar(t, extra, map[string]string{
+		// Also in lib, with the same content: kept once.
+		"com/example/user/v1/UserProto.java": "package com.example.user.v1;",
+	})
+
+	out := filepath.Join(dir, "user_java_sources.jar")
+	if err := writeSourcesJar(out, []string{lib, extra}); err != nil {
+		t.Fatalf("writeSourcesJar: %v", err)
+	}
+
+	files, names := readJar(t, out)
+	wantNames := []string{
+		"META-INF/MANIFEST.MF",
+		"com/example/common/v1/CommonProto.java",
+		"com/example/user/v1/UserProto.java",
+		"com/example/user/v1/UserServiceGrpc.java",
+		"com/example/user/v2/UserProto.java",
+	}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("Expected entries %v, got %v", wantNames, names)
+	}
+	if got := files["com/example/user/v2/UserProto.java"]; got != "package com.example.user.v2;" {
+		t.Errorf("Expected v2's UserProto.java alongside v1's, got %q", got)
+	}
+	if !strings.HasPrefix(files["META-INF/MANIFEST.MF"], "Manifest-Version: 1.0") {
+		t.Errorf("Expected a manifest, got %q", files["META-INF/MANIFEST.MF"])
+	}
+
+	// Reproducible: the same inputs give the same bytes.
+	again := filepath.Join(dir, "again.jar")
+	if err := writeSourcesJar(again, []string{lib, extra}); err != nil {
+		t.Fatalf("writeSourcesJar: %v", err)
+	}
+	first, _ := os.ReadFile(out)
+	second, _ := os.ReadFile(again)
+	if string(first) != string(second) {
+		t.Errorf("Expected identical JARs from identical inputs")
+	}
+}
+
+// TestWriteSourcesJarConflict: one path with two different contents fails
+// rather than publishing whichever came last.
+func TestWriteSourcesJarConflict(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a-src.jar")
+	b := filepath.Join(dir, "b-src.jar")
+	writeJar(t, a, map[string]string{"com/example/Foo.java": "class Foo {}"})
+	writeJar(t, b, map[string]string{"com/example/Foo.java": "class Foo { int x; }"})
+
+	err := writeSourcesJar(filepath.Join(dir, "out.jar"), []string{a, b})
+	if err == nil || !strings.Contains(err.Error(), "com/example/Foo.java differs between") {
+		t.Errorf("Expected a conflict on com/example/Foo.java, got %v", err)
+	}
+}
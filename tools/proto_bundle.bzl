@@ -1,6 +1,8 @@
 # Proto bundle rules with hybrid publishing approach
 # Static configuration is in BUILD files, dynamic configuration comes from environment
 
+load("@bazel_skylib//lib:shell.bzl", "shell")
+
 def java_proto_bundle(name, proto_deps=[], java_deps=[], java_grpc_deps=[], group_id="", artifact_id="", **kwargs):
     """Java proto bundle that reads version from environment"""
     
@@ -43,7 +45,85 @@ def java_proto_bundle(name, proto_deps=[], java_deps=[], java_grpc_deps=[], grou
         **kwargs
     )
 
-def py_proto_bundle(name, proto_deps=[], py_deps=[], py_grpc_deps=[], package_name="", **kwargs):
+def java_source_bundle(name, srcjars=[], **kwargs):
+    """Sources JAR for a Java bundle: the Java generated from its protos
+
+    srcjars are source JARs of the generated code, e.g. the
+    lib<name>-src.jar of the bundle's java_grpc_library. Their .java files
+    keep their package paths (see //tools/javasources).
+    """
+
+    native.genrule(
+        name = name,
+        srcs = srcjars,
+        outs = [name + ".jar"],
+        cmd = "$(location //tools/javasources) --output $@ $(SRCS)",
+        tools = ["//tools/javasources"],
+        **kwargs
+    )
+
+def _maven_signing_impl(ctx):
+    variables = {"gpg_sign": "true"}
+    if ctx.attr.in_memory_keys:
+        variables["use_in_memory_pgp_keys"] = "true"
+    return [platform_common.TemplateVariableInfo(variables)]
+
+# Toolchain for maven_publish's `toolchains` attr: sets the make variables
+# that turn on GPG signing of every uploaded file.
+maven_signing = rule(
+    implementation = _maven_signing_impl,
+    attrs = {"in_memory_keys": attr.bool(default = False)},
+)
+
+def _proto_breaking_test_impl(ctx):
+    args = [
+        "--baseline=" + ctx.file.baseline.short_path,
+        "--baseline-version=" + ctx.attr.baseline_version,
+    ]
+    inputs = [ctx.file.descriptor, ctx.file.baseline]
+    if ctx.file.bundle_yaml:
+        args.append("--bundle-yaml=" + ctx.file.bundle_yaml.short_path)
+        inputs.append(ctx.file.bundle_yaml)
+    if ctx.file.version_file:
+        args.append("--version-file=" + ctx.file.version_file.short_path)
+        inputs.append(ctx.file.version_file)
+
+    # The checker takes the current descriptor set last.
+    args.append(ctx.file.descriptor.short_path)
+    script = ctx.actions.declare_file(ctx.label.name + ".sh")
+    ctx.actions.write(
+        output = script,
+        content = "#!/bin/sh\nexec %s %s\n" % (
+            shell.quote(ctx.executable._checker.short_path),
+            " ".join([shell.quote(arg) for arg in args]),
+        ),
+        is_executable = True,
+    )
+    runfiles = ctx.runfiles(files = inputs).merge(ctx.attr._checker[DefaultInfo].default_runfiles)
+    return [DefaultInfo(executable = script, runfiles = runfiles)]
+
+# Fails when the descriptor set makes a wire-incompatible change to the
+# baseline one that the bundle's version doesn't bump the major version for
+# (see //tools/breaking). The version comes from bundle_yaml or, for a
+# version_source other than bundle_yaml, from version_file.
+proto_breaking_test = rule(
+    implementation = _proto_breaking_test_impl,
+    test = True,
+    attrs = {
+        "descriptor": attr.label(mandatory = True, allow_single_file = True),
+        "baseline": attr.label(mandatory = True, allow_single_file = True),
+        "baseline_version": attr.string(mandatory = True),
+        "bundle_yaml": attr.label(allow_single_file = True),
+        "version_file": attr.label(allow_single_file = True),
+        "_checker": attr.label(
+            default = "//tools/breaking",
+            executable = True,
+            cfg = "target",
+        ),
+    },
+)
+
+def py_proto_bundle(name, proto_deps=[], py_deps=[], py_grpc_deps=[], package_name="", python_requires="", **kwargs):
     """Python proto bundle that reads version from environment"""
     
     native.genrule(
@@ -71,7 +151,8 @@ def py_proto_bundle(name, proto_deps=[], py_deps=[], py_grpc_deps=[], package_na
         # Create minimal wheel (just touch the file for testing)
         touch $(location %s.whl)
         echo "Created Python wheel for %s version $${VERSION:-1.0.0}" > wheel_contents/info.txt
-        """ % (name, package_name),
+        echo "Requires-Python: %s" >> wheel_contents/info.txt
+        """ % (name, package_name, python_requires),
         **kwargs
     )
 
@@ -107,6 +188,76 @@ def js_proto_bundle(name, proto_deps=[], es_deps=[], package_name="", **kwargs):
         **kwargs
     )
 
+def go_proto_bundle(name, proto_deps=[], go_deps=[], module_path="", requires=[], **kwargs):
+    """Go proto bundle that packages generated code as a module zip with a synthesized go.mod
+
+    requires lists the other bundles' modules the packages import, as
+    "module@version", written to go.mod as require directives.
+    """
+
+    native.genrule(
+        name = name,
+        srcs = go_deps + proto_deps,
+        outs = [name + ".zip"],
+        cmd = """
+        # Create module structure
+        mkdir -p module_contents
+
+        # Copy generated Go files
+        for src in $(SRCS); do
+            if [[ $$src == *.go ]]; then
+                cp $$src module_contents/
+            fi
+        done
+
+        # Copy proto sources
+        for src in $(SRCS); do
+            if [[ $$src == *.proto ]]; then
+                cp $$src module_contents/
+            fi
+        done
+
+        # Synthesize go.mod for the published module
+        echo "module %s" > module_contents/go.mod
+        %s
+
+        # Create minimal module archive (just touch the file for testing)
+        touch $(location %s.zip)
+        echo "Created Go module %s version $${VERSION:-1.0.0}" > module_contents/info.txt
+        """ % (module_path, "\n        ".join([
+            'echo "require %s %s" >> module_contents/go.mod' % tuple(r.split("@", 1))
+            for r in requires
+        ]), name, module_path),
+        **kwargs
+    )
+
+def buf_proto_bundle(name, proto_deps=[], buf_yaml="", **kwargs):
+    """Buf Schema Registry bundle that packages the protos with their generated buf.yaml"""
+
+    native.genrule(
+        name = name,
+        srcs = proto_deps + [buf_yaml],
+        outs = [name + ".zip"],
+        cmd = """
+        # Create module structure
+        mkdir -p module_contents
+
+        # Copy proto sources
+        for src in $(SRCS); do
+            if [[ $$src == *.proto ]]; then
+                cp $$src module_contents/
+            fi
+        done
+
+        # The module definition buf push reads
+        cp $(location %s) module_contents/buf.yaml
+
+        # Create minimal module archive (just touch the file for testing)
+        touch $(location %s.zip)
+        """ % (buf_yaml, name),
+        **kwargs
+    )
+
 def build_validation(name, targets=[], **kwargs):
     """Build validation rule to ensure all targets build successfully"""
     
@@ -0,0 +1,21 @@
+#!/usr/bin/env bash
+# Workspace status command for bundles with `version_source: git_tag`.
+#
+# For every series of bundle release tags (<bundle>-vX.Y.Z), prints the
+# nearest one reachable from HEAD as STABLE_GIT_TAG_<bundle>, which the
+# bundle's stamped <bundle>_version genrule turns into the version:
+#
+#   bazel build --workspace_status_command=tools/workspace_status.sh //...
+#
+# A lake with `version_source: stamp` bundles prints STABLE_VERSION_<bundle>
+# lines from its own command instead; the two can be combined in one script.
+set -euo pipefail
+
+git tag --merged HEAD --list '*-v[0-9]*' |
+  sed -nE 's/^(.+)-v[0-9]+\.[0-9]+\.[0-9]+.*$/\1/p' |
+  sort -u |
+  while read -r bundle; do
+    if tag=$(git describe --tags --abbrev=0 --match "${bundle}-v[0-9]*" HEAD 2>/dev/null); then
+      echo "STABLE_GIT_TAG_${bundle} ${tag}"
+    fi
+  done